@@ -3,12 +3,18 @@ package main
 // CLI for bommom tools. Also used to launch web interface.
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 )
 
@@ -21,6 +27,7 @@ var anonUser = &User{name: "common"}
 var (
 	templatePath  = flag.String("templatepath", "./templates", "path to template directory")
 	fileStorePath = flag.String("path", "./filestore", "path to flat file data store top-level directory")
+	storeSpec     = flag.String("store", "", "BOM datastore as kind:location, eg sqlite:bommom.db or git:../hw.git (default json:<path>)")
 	verbose       = flag.Bool("verbose", false, "print extra info")
 	helpFlag      = flag.Bool("help", false, "print full help info")
 	outFormat     = flag.String("format", "", "command output format (for 'dump' etc)")
@@ -29,15 +36,44 @@ var (
 	listenHost    = flag.String("host", "", "hostname to listen on (HTTP serve)")
 	sessionSecret = flag.String("sessionSecret", "12345", "cookie session secret")
 	octoApiKey    = flag.String("octopartApiKey", "", "octopart.com API key (for pricing info)")
+	categoryFile  = flag.String("categories", "", "file of extra part categories to register, one per line")
+	variantName   = flag.String("variant", "", "assembly variant to output the fitted BOM for (for 'dump' etc)")
+	currencyName  = flag.String("currency", "USD", "currency to report prices and costs in")
+	ratesFile     = flag.String("rates", "", "CSV file of currency exchange rates (effective_date,from,to,rate)")
+	attritionFile = flag.String("attrition", "", "JSON file of attrition rules for build costs (for 'cost' etc)")
+	centroidFile  = flag.String("centroid", "", "centroid/pick-and-place file with element placements (for 'load' etc)")
+	consolidate   = flag.Bool("consolidate", false, "merge duplicate line items on import (for 'load' etc)")
+	keepComments  = flag.Bool("keepcomments", false, "give lines without a comment the one from the head version (for 'load')")
+	tagFilter     = flag.String("tag", "", "only output line items with this tag (for 'dump' etc)")
+	mfgAliasFile  = flag.String("manufacturers", "", "CSV file of extra manufacturer names and aliases (canonical name first)")
+	showArchived  = flag.Bool("archived", false, "include archived BOMs (for 'list' etc)")
+	maxLeadWeeks  = flag.Uint("leadweeks", 12, "lead times longer than this many weeks are a risk (for 'risk' etc)")
+	fsckRepair    = flag.Bool("repair", false, "fix what can safely be fixed (for 'fsck')")
+	suppressRules = flag.String("suppress", "", "comma separated lint rules (or rule:line) to suppress for the BOM (for 'lint')")
+	unsuppress    = flag.String("unsuppress", "", "comma separated lint suppressions to remove (for 'lint')")
 )
 
 func main() {
 
 	// Parse configuration
 	flag.Parse()
+	parseCommandFlags()
+	if *categoryFile != "" {
+		loadCategoryFile(*categoryFile)
+	}
+	if *mfgAliasFile != "" {
+		loadManufacturerFile(*mfgAliasFile)
+	}
+	if *attritionFile != "" {
+		loadAttritionFile(*attritionFile)
+	}
+	if *ratesFile != "" {
+		loadExchangeRatesFile(*ratesFile)
+	}
 	if *verbose {
 		log.Println("template dir:", *templatePath)
 		log.Println("filestore dir:", *fileStorePath)
+		log.Println("bom store:", *storeSpec)
 		log.Println("anon user:", anonUser.name)
 	}
 
@@ -69,21 +105,166 @@ func main() {
 		convertCmd()
 	case "list":
 		listCmd()
+	case "verify":
+		verifyCmd()
+	case "fsck":
+		fsckCmd()
+	case "diff":
+		diffCmd()
+	case "history":
+		historyCmd()
+	case "delete":
+		deleteCmd()
+	case "archive", "unarchive":
+		archiveCmd(flag.Arg(0) == "archive")
+	case "revert":
+		revertCmd()
+	case "fork", "copy":
+		forkCmd(flag.Arg(0) == "copy")
+	case "transfer":
+		transferCmd()
+	case "linelog":
+		lineLogCmd()
+	case "merge":
+		mergeCmd()
+	case "lint":
+		lintCmd()
+	case "consolidate":
+		consolidateCmd()
+	case "cost":
+		costCmd()
+	case "risk":
+		riskCmd()
+	case "whereused":
+		whereUsedCmd()
+	case "partstats":
+		partStatsCmd()
+	case "export-store":
+		exportStoreCmd()
+	case "import-store":
+		importStoreCmd()
+	case "manufacturers":
+		manufacturersCmd()
 	case "serve":
 		// defined in serve.go
 		serveCmd()
 	}
 }
 
+// Flags may also be given after the command name (eg, "bommom dump -variant
+// lite common gizmo"); this re-parses them out of the remaining arguments so
+// that flag.Arg() only returns the command and its positional arguments.
+func parseCommandFlags() {
+	if flag.NArg() < 2 {
+		return
+	}
+	positional := []string{flag.Arg(0)}
+	rest := flag.Args()[1:]
+	for len(rest) > 0 {
+		if err := flag.CommandLine.Parse(rest); err != nil {
+			log.Fatal(err)
+		}
+		if flag.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flag.Arg(0))
+		rest = flag.Args()[1:]
+	}
+	// the command name isn't a flag, so this just resets flag.Args()
+	flag.CommandLine.Parse(positional)
+}
+
+func loadManufacturerFile(fname string) {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := LoadManufacturers(f); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadCategoryFile(fname string) {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := LoadCategories(f); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadAttritionFile(fname string) {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if attritionRules, err = LoadAttritionRules(f); err != nil {
+		log.Fatal("error parsing attrition rules: " + err.Error())
+	}
+}
+
+func loadExchangeRatesFile(fname string) {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if exchangeRates, err = LoadExchangeTable(f); err != nil {
+		log.Fatal("error parsing exchange rates: " + err.Error())
+	}
+}
+
 func openBomStore() {
 	// defaults to JSON file store
+	spec := *storeSpec
+	if spec == "" {
+		spec = "json:" + *fileStorePath
+	}
 	var err error
-	bomstore, err = OpenJSONFileBomStore(*fileStorePath)
+	bomstore, err = OpenBomStore(spec)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// Exit codes for the kinds of BomStore error, so scripts can tell a missing
+// BOM from a broken one. Anything else exits with 1, like log.Fatal.
+const (
+	exitNotFound = 3
+	exitExists   = 4
+	exitInvalid  = 5
+	exitCorrupt  = 6
+)
+
+func storeExitCode(err error) int {
+	switch StoreErrorKind(err) {
+	case ErrNotFound:
+		return exitNotFound
+	case ErrExists:
+		return exitExists
+	case ErrInvalid:
+		return exitInvalid
+	case ErrCorrupt:
+		return exitCorrupt
+	}
+	return 1
+}
+
+func storeFatal(err error) {
+	log.Print(err)
+	os.Exit(storeExitCode(err))
+}
+
+// For names given on the command line which aren't ShortNames.
+func fatalInvalid(v ...interface{}) {
+	log.Print(v...)
+	os.Exit(exitInvalid)
+}
+
 func openAuthStore() {
 	// defaults to dummy auth system
 	auth = DummyAuth(true)
@@ -95,6 +276,15 @@ func openPricingSource() {
 
 func dumpOut(fname string, bm *BomMeta, b *Bom) {
 	var outFile io.Writer
+	if *variantName != "" {
+		var err error
+		if b, err = b.ForVariant(*variantName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tagFilter != "" {
+		b = b.FilterByTag(*tagFilter)
+	}
 	if fname == "" {
 		outFile = os.Stdout
 	} else {
@@ -170,9 +360,35 @@ func loadIn(fname string) (bm *BomMeta, b *Bom) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *centroidFile != "" {
+		loadCentroidFile(*centroidFile, b)
+	}
+	for _, name := range CanonicalizeManufacturers(b) {
+		log.Println("unrecognized manufacturer: " + name)
+	}
+	if *consolidate {
+		removed, notes := ConsolidateBom(b)
+		DumpConsolidationNotes(removed, notes, os.Stderr)
+	}
+	ClassifyBom(b)
 	return bm, b
 }
 
+func loadCentroidFile(fname string, b *Bom) {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	unmatched, err := LoadCentroid(f, b)
+	if err != nil {
+		log.Fatal("error parsing centroid file: " + err.Error())
+	}
+	if len(unmatched) > 0 {
+		log.Printf("warning: %d placements not in BOM: %v", len(unmatched), unmatched)
+	}
+}
+
 func initCmd() {
 
 	openBomStore()
@@ -189,7 +405,9 @@ func initCmd() {
 		HeadVersion:  b.Version,
 		IsPublicView: true,
 		IsPublicEdit: true}
-	bomstore.Persist(bm, b, "v001")
+	if err := bomstore.Persist(bm, b, "v001"); err != nil {
+		storeFatal(err)
+	}
 }
 
 func dumpCmd() {
@@ -201,7 +419,7 @@ func dumpCmd() {
 	nameStr := flag.Arg(2)
 
 	if !isShortName(userStr) || !isShortName(nameStr) {
-		log.Fatal("Error: not valid ShortName: " + userStr +
+		fatalInvalid("Error: not valid ShortName: " + userStr +
 			" and/or " + nameStr)
 	}
 
@@ -217,7 +435,7 @@ func dumpCmd() {
 
 	bm, b, err := bomstore.GetHead(ShortName(userStr), ShortName(nameStr))
 	if err != nil {
-		log.Fatal(err)
+		storeFatal(err)
 	}
 
 	dumpOut(fname, bm, b)
@@ -235,25 +453,40 @@ func loadCmd() {
 	version = flag.Arg(4)
 
 	if !(isShortName(userName) && isShortName(bomName) && isShortName(version)) {
-		log.Fatal("user, name, and version must be ShortNames")
+		fatalInvalid("Error: user, name, and version must be ShortNames")
 	}
 
-	bm, b := loadIn(inFname)
-	if *inFormat == "csv" && bm == nil {
-		// TODO: from inname? if ShortName?
-		bm = &BomMeta{}
-	}
-
-	bm.Owner = userName
-	bm.Name = bomName
+	uploaded, b := loadIn(inFname)
 	b.Progeny = "File import from " + inFname + " (" + *inFormat + ")"
 	b.Created = time.Now()
 	b.Version = version
 
 	openBomStore()
+	bm, metaChanged, err := metaForUpload(bomstore, uploaded, ShortName(userName), ShortName(bomName))
+	if err != nil {
+		storeFatal(err)
+	}
+	// carry line uids over from the current head, if there is one
+	var head *Bom
+	if bm.HeadVersion != "" {
+		if _, head, err = bomstore.GetHead(ShortName(userName), ShortName(bomName)); err != nil {
+			storeFatal(err)
+		}
+	}
+	AssignLineUids(b, head)
+	if *keepComments {
+		CarryLineComments(b, head)
+	}
+	if head != nil && b.ContentHash() == head.ContentHash() {
+		log.Println("identical to head version " + head.Version + ", nothing saved")
+		if metaChanged {
+			log.Println("(the description and homepage in " + inFname + " were not saved either)")
+		}
+		return
+	}
 
 	if err := bomstore.Persist(bm, b, ShortName(version)); err != nil {
-		log.Fatal(err)
+		storeFatal(err)
 	}
 }
 
@@ -304,39 +537,830 @@ func listCmd() {
 	if flag.NArg() == 2 {
 		name := flag.Arg(1)
 		if !isShortName(name) {
-			log.Fatal("Error: not a possible username: " + name)
+			fatalInvalid("Error: not a possible username: " + name)
 		}
-		bomMetas, err = bomstore.ListBoms(ShortName(name))
+		bomMetas, err = bomstore.ListBoms(ShortName(name), *showArchived)
 		if err != nil {
-			log.Fatal(err)
+			storeFatal(err)
 		}
 	} else {
 		// list all boms from all names
-		bomMetas, err = bomstore.ListBoms("")
+		bomMetas, err = bomstore.ListBoms("", *showArchived)
+		if err != nil {
+			storeFatal(err)
+		}
+	}
+	for _, bm := range bomMetas {
+		if bm.IsArchived {
+			fmt.Println(bm.Owner + "/" + bm.Name + " (archived)")
+		} else {
+			fmt.Println(bm.Owner + "/" + bm.Name)
+		}
+	}
+}
+
+// Checks the stored content hash of every recorded version of each BOM (all
+// BOMs, a user's, or just one). Exits non-zero if any fail.
+func verifyCmd() {
+	if flag.NArg() > 3 {
+		log.Fatal("Error: too many arguments (expected optional user and BOM name)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+
+	openBomStore()
+	var bomMetas []BomMeta
+	if flag.NArg() == 3 {
+		bm, err := bomstore.GetBomMeta(ShortName(flag.Arg(1)), ShortName(flag.Arg(2)))
+		if err != nil {
+			storeFatal(err)
+		}
+		bomMetas = []BomMeta{*bm}
+	} else {
+		var err error
+		if bomMetas, err = bomstore.ListBoms(ShortName(flag.Arg(1)), true); err != nil {
+			storeFatal(err)
+		}
+	}
+
+	failed := 0
+	tabWriter := tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+	for _, bm := range bomMetas {
+		versions := make([]string, 0, len(bm.Hashes)+1)
+		for v := range bm.Hashes {
+			versions = append(versions, v)
+		}
+		if _, ok := bm.Hashes[bm.HeadVersion]; !ok {
+			versions = append(versions, bm.HeadVersion)
+		}
+		sort.Strings(versions)
+		for _, v := range versions {
+			status := "ok"
+			if _, err := bomstore.GetBom(ShortName(bm.Owner), ShortName(bm.Name), ShortName(v)); err != nil {
+				status = "FAILED: " + err.Error()
+				failed++
+			} else if bm.Hashes[v] == "" {
+				status = "no hash recorded"
+			}
+			fmt.Fprintf(tabWriter, "%s/%s\t%s\t%s\n", bm.Owner, bm.Name, v, status)
+		}
+	}
+	tabWriter.Flush()
+	if failed > 0 {
+		log.Printf("Error: %d versions failed verification", failed)
+		os.Exit(exitCorrupt)
+	}
+}
+
+func diffCmd() {
+	if flag.NArg() != 5 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, and two versions)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	user, name := ShortName(flag.Arg(1)), ShortName(flag.Arg(2))
+
+	openBomStore()
+	before, err := bomstore.GetBom(user, name, ShortName(flag.Arg(3)))
+	if err != nil {
+		storeFatal(err)
+	}
+	after, err := bomstore.GetBom(user, name, ShortName(flag.Arg(4)))
+	if err != nil {
+		storeFatal(err)
+	}
+
+	d := DiffBoms(before, after)
+	switch *outFormat {
+	case "text", "":
+		DumpDiffAsText(d, os.Stdout)
+	case "json":
+		DumpDiffAsJSON(d, os.Stdout)
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+}
+
+func historyCmd() {
+	if flag.NArg() != 3 {
+		log.Fatal("Error: wrong number of arguments (expected user and BOM name)")
+	}
+	user, name := flag.Arg(1), flag.Arg(2)
+	if !isShortName(user) || !isShortName(name) {
+		fatalInvalid("Error: not valid ShortName: " + user + " and/or " + name)
+	}
+
+	openBomStore()
+	bm, err := bomstore.GetBomMeta(ShortName(user), ShortName(name))
+	if err != nil {
+		storeFatal(err)
+	}
+	versions, err := bomstore.ListVersions(ShortName(user), ShortName(name))
+	if err != nil {
+		storeFatal(err)
+	}
+	switch *outFormat {
+	case "text", "":
+		tabWriter := tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+		fmt.Fprintf(tabWriter, "\tversion\tcreated\tlines\tqty\tsource\n")
+		for _, bv := range versions {
+			head := ""
+			if bv.Version == bm.HeadVersion {
+				head = "*"
+			}
+			fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%d\t%d\t%s\n",
+				head,
+				bv.Version,
+				bv.Created.Format("2006-01-02 15:04"),
+				bv.Lines,
+				bv.Elements,
+				bv.Progeny)
+		}
+		tabWriter.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(versions); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+}
+
+// Deletes a single version, which can't be the head.
+func deleteCmd() {
+	if flag.NArg() != 4 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, and version)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	openBomStore()
+	if err := bomstore.DeleteVersion(ShortName(flag.Arg(1)), ShortName(flag.Arg(2)), ShortName(flag.Arg(3))); err != nil {
+		storeFatal(err)
+	}
+}
+
+func archiveCmd(archived bool) {
+	if flag.NArg() != 3 {
+		log.Fatal("Error: wrong number of arguments (expected user and BOM name)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	openBomStore()
+	if err := bomstore.SetArchived(ShortName(flag.Arg(1)), ShortName(flag.Arg(2)), archived); err != nil {
+		storeFatal(err)
+	}
+}
+
+// Forks one version (by default the head) of a BOM to a new owner and/or name,
+// or with withHistory copies all of them.
+func forkCmd(withHistory bool) {
+	if flag.NArg() != 5 && !(flag.NArg() == 6 && !withHistory) {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, new user and new BOM name)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	openBomStore()
+	user, name := ShortName(flag.Arg(1)), ShortName(flag.Arg(2))
+	newOwner, newName := ShortName(flag.Arg(3)), ShortName(flag.Arg(4))
+	var err error
+	if withHistory {
+		err = bomstore.CopyWithHistory(user, name, newOwner, newName)
+	} else {
+		err = bomstore.Fork(user, name, ShortName(flag.Arg(5)), newOwner, newName)
+	}
+	if err != nil {
+		storeFatal(err)
+	}
+}
+
+func transferCmd() {
+	if flag.NArg() != 4 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, and new owner)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	openBomStore()
+	if err := bomstore.TransferOwnership(ShortName(flag.Arg(1)), ShortName(flag.Arg(2)), ShortName(flag.Arg(3))); err != nil {
+		storeFatal(err)
+	}
+}
+
+// Points the head back (or forward) at an existing version.
+func revertCmd() {
+	if flag.NArg() != 4 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, and version)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	openBomStore()
+	if err := bomstore.SetHead(ShortName(flag.Arg(1)), ShortName(flag.Arg(2)), ShortName(flag.Arg(3))); err != nil {
+		storeFatal(err)
+	}
+}
+
+// Shows how one line changed over a list of versions (by default, all of
+// them), oldest first. The line can be given by uid, manufacturer::mpn, mpn,
+// or any of its designators, as of the last version listed.
+func lineLogCmd() {
+	if flag.NArg() < 4 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, line, and optional versions)")
+	}
+	user, name := flag.Arg(1), flag.Arg(2)
+	if !isShortName(user) || !isShortName(name) {
+		fatalInvalid("Error: not valid ShortName: " + user + " and/or " + name)
+	}
+	ref := flag.Arg(3)
+
+	openBomStore()
+	versionNames := flag.Args()[4:]
+	if len(versionNames) == 0 {
+		all, err := bomstore.ListVersions(ShortName(user), ShortName(name))
+		if err != nil {
+			storeFatal(err)
+		}
+		for _, bv := range all {
+			versionNames = append(versionNames, bv.Version)
+		}
+	}
+	versions := []*Bom{}
+	for _, v := range versionNames {
+		if !isShortName(v) {
+			fatalInvalid("Error: not valid ShortName: " + v)
+		}
+		b, err := bomstore.GetBom(ShortName(user), ShortName(name), ShortName(v))
 		if err != nil {
+			storeFatal(err)
+		}
+		versions = append(versions, b)
+	}
+	if len(versions) == 0 {
+		log.Fatal("Error: no versions of " + user + "/" + name)
+	}
+	last := versions[len(versions)-1]
+	li := last.FindLine(ref)
+	if li == nil {
+		log.Fatal("Error: no line matching \"" + ref + "\" in " + last.Version)
+	}
+	key := li.Uid
+	if key == "" {
+		key = li.Id()
+	}
+
+	events := LineHistory(versions, key)
+	switch *outFormat {
+	case "text", "":
+		DumpLineHistoryAsText(events, os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(events); err != nil {
 			log.Fatal(err)
 		}
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+}
+
+func mergeCmd() {
+	if flag.NArg() != 7 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, base, ours, and theirs versions, and new version)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	user, name := ShortName(flag.Arg(1)), ShortName(flag.Arg(2))
+	version := ShortName(flag.Arg(6))
+
+	openBomStore()
+	bm, err := bomstore.GetBomMeta(user, name)
+	if err != nil {
+		storeFatal(err)
 	}
+	boms := make([]*Bom, 3)
+	for i := range boms {
+		if boms[i], err = bomstore.GetBom(user, name, ShortName(flag.Arg(3+i))); err != nil {
+			storeFatal(err)
+		}
+	}
+
+	merged, conflicts := MergeBoms(boms[0], boms[1], boms[2])
+	if len(conflicts) > 0 {
+		switch *outFormat {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			if err := enc.Encode(conflicts); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			DumpConflictsAsText(conflicts, os.Stdout)
+		}
+		log.Fatalf("Error: %d merge conflicts, nothing saved", len(conflicts))
+	}
+	AssignLineUids(merged, boms[1])
+	if err := bomstore.Persist(bm, merged, version); err != nil {
+		storeFatal(err)
+	}
+}
+
+func lintCmd() {
+	if flag.NArg() != 3 && flag.NArg() != 4 {
+		log.Fatal("Error: wrong number of arguments (expected user and BOM name, optional version)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	user, name := ShortName(flag.Arg(1)), ShortName(flag.Arg(2))
+
+	openBomStore()
+	bm, b, err := bomstore.GetHead(user, name)
+	if err != nil {
+		storeFatal(err)
+	}
+	if *suppressRules != "" || *unsuppress != "" {
+		split := func(s string) []string {
+			if s == "" {
+				return nil
+			}
+			return strings.Split(s, ",")
+		}
+		suppress, err := EditLintSuppress(bm.LintSuppress, split(*suppressRules), split(*unsuppress))
+		if err != nil {
+			fatalInvalid("Error: " + err.Error())
+		}
+		if err := bomstore.SetLintSuppress(user, name, suppress); err != nil {
+			storeFatal(err)
+		}
+		bm.LintSuppress = suppress
+	}
+	if flag.NArg() == 4 {
+		if b, err = bomstore.GetBom(user, name, ShortName(flag.Arg(3))); err != nil {
+			storeFatal(err)
+		}
+	}
+	if *octoApiKey != "" {
+		openPricingSource()
+		if err := pricingSource.AttachMarketInfoBom(b); err != nil {
+			log.Println("error attaching market info: " + err.Error())
+		}
+	}
+
+	results := LintBom(bm, b, *currencyName)
+	switch *outFormat {
+	case "text", "":
+		DumpLintAsText(results, os.Stdout)
+	case "json":
+		DumpLintAsJSON(results, os.Stdout)
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+	if LintSummary(results)["error"] > 0 {
+		os.Exit(1)
+	}
+}
+
+func consolidateCmd() {
+	if flag.NArg() != 4 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, and new version)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	user, name := ShortName(flag.Arg(1)), ShortName(flag.Arg(2))
+	version := ShortName(flag.Arg(3))
+
+	openBomStore()
+	bm, b, err := bomstore.GetHead(user, name)
+	if err != nil {
+		storeFatal(err)
+	}
+	removed, notes := ConsolidateBom(b)
+	DumpConsolidationNotes(removed, notes, os.Stdout)
+	if removed == 0 {
+		log.Println("no duplicate lines, nothing saved")
+		return
+	}
+	b.Progeny = "Consolidated duplicate lines from " + b.Version
+	b.Created = time.Now()
+	if err := bomstore.Persist(bm, b, version); err != nil {
+		storeFatal(err)
+	}
+}
+
+func costCmd() {
+	if flag.NArg() != 4 {
+		log.Fatal("Error: wrong number of arguments (expected user, BOM name, and build quantity)")
+	}
+	userStr, nameStr := flag.Arg(1), flag.Arg(2)
+	if !isShortName(userStr) || !isShortName(nameStr) {
+		fatalInvalid("Error: not valid ShortName: " + userStr +
+			" and/or " + nameStr)
+	}
+	buildQty, err := strconv.ParseUint(flag.Arg(3), 10, 32)
+	if err != nil || buildQty == 0 {
+		log.Fatal("Error: build quantity must be a positive number: " + flag.Arg(3))
+	}
+
+	openBomStore()
+	_, b, err := bomstore.GetHead(ShortName(userStr), ShortName(nameStr))
+	if err != nil {
+		storeFatal(err)
+	}
+	if *variantName != "" {
+		if b, err = b.ForVariant(*variantName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *octoApiKey != "" {
+		openPricingSource()
+		if err := pricingSource.AttachMarketInfoBom(b); err != nil {
+			log.Println("error attaching market info: " + err.Error())
+		}
+	}
+
+	bc := CostRollup(b, uint32(buildQty), attritionRules, *currencyName)
+	switch *outFormat {
+	case "text", "":
+		DumpCostAsText(bc, os.Stdout)
+	case "json":
+		DumpCostAsJSON(bc, os.Stdout)
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+}
+
+func riskCmd() {
+	if flag.NArg() != 3 && flag.NArg() != 4 {
+		log.Fatal("Error: wrong number of arguments (expected user and BOM name, optional version)")
+	}
+	for _, s := range flag.Args()[1:] {
+		if !isShortName(s) {
+			fatalInvalid("Error: not valid ShortName: " + s)
+		}
+	}
+	user, name := ShortName(flag.Arg(1)), ShortName(flag.Arg(2))
+
+	openBomStore()
+	_, b, err := bomstore.GetHead(user, name)
+	if err != nil {
+		storeFatal(err)
+	}
+	if flag.NArg() == 4 {
+		if b, err = bomstore.GetBom(user, name, ShortName(flag.Arg(3))); err != nil {
+			storeFatal(err)
+		}
+	}
+	if *variantName != "" {
+		if b, err = b.ForVariant(*variantName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *octoApiKey != "" {
+		openPricingSource()
+		if err := pricingSource.AttachMarketInfoBom(b); err != nil {
+			log.Println("error attaching market info: " + err.Error())
+		}
+	}
+
+	risks := RiskReport(b, uint32(*maxLeadWeeks))
+	switch *outFormat {
+	case "text", "":
+		DumpRisksAsText(risks, os.Stdout)
+	case "json":
+		DumpRisksAsJSON(risks, os.Stdout)
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+}
+
+// The cross-BOM queries need the relational tables of an SQL store.
+func openSQLBomStore() *SQLBomStore {
+	openBomStore()
+	sqlstore, ok := bomstore.(*SQLBomStore)
+	if !ok {
+		log.Fatal("Error: this command needs an SQL BOM store (eg, -store sqlite:bommom.db)")
+	}
+	return sqlstore
+}
+
+// Lists BOMs whose head uses a part, and the offers they have for it.
+func whereUsedCmd() {
+	if flag.NArg() != 3 {
+		log.Fatal("Error: wrong number of arguments (expected manufacturer and mpn)")
+	}
+	sqlstore := openSQLBomStore()
+	uses, err := sqlstore.WhereUsed(flag.Arg(1), flag.Arg(2))
+	if err != nil {
+		storeFatal(err)
+	}
+	switch *outFormat {
+	case "text", "":
+		if len(uses) == 0 {
+			fmt.Println("not used in any BOM")
+			return
+		}
+		tabWriter := tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+		fmt.Fprintf(tabWriter, "bom\tversion\tqty\tas\n")
+		for _, pu := range uses {
+			as := "primary"
+			if pu.AltStatus != "" {
+				as = pu.AltStatus + " alternate"
+			}
+			fmt.Fprintf(tabWriter, "%s/%s\t%s\t%d\t%s\n", pu.Owner, pu.Name, pu.Version, pu.Qty, as)
+		}
+		tabWriter.Flush()
+		offers, err := sqlstore.PartOffers(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			storeFatal(err)
+		}
+		if len(offers) == 0 {
+			return
+		}
+		fmt.Println()
+		tabWriter = tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+		fmt.Fprintf(tabWriter, "bom\tdistributor\tsku\tavail\tprices\n")
+		for _, po := range offers {
+			prices := []string{}
+			for _, op := range po.Prices {
+				prices = append(prices, fmt.Sprintf("%d+ %s %s", op.MinQty, op.Price, op.Currency))
+			}
+			fmt.Fprintf(tabWriter, "%s/%s\t%s\t%s\t%d\t%s\n", po.Owner, po.Name, po.Distributor, po.Sku,
+				po.Available, strings.Join(prices, ", "))
+		}
+		tabWriter.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(uses); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+}
+
+// The most widely used parts across all BOMs.
+func partStatsCmd() {
+	if flag.NArg() > 2 {
+		log.Fatal("Error: wrong number of arguments (expected an optional number of parts)")
+	}
+	limit := 20
+	if flag.NArg() == 2 {
+		n, err := strconv.Atoi(flag.Arg(1))
+		if err != nil || n <= 0 {
+			log.Fatal("Error: number of parts must be a positive number: " + flag.Arg(1))
+		}
+		limit = n
+	}
+	stats, err := openSQLBomStore().PartStats(limit)
+	if err != nil {
+		storeFatal(err)
+	}
+	switch *outFormat {
+	case "text", "":
+		tabWriter := tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+		fmt.Fprintf(tabWriter, "manufacturer\tmpn\tboms\tqty\talt boms\n")
+		for _, ps := range stats {
+			fmt.Fprintf(tabWriter, "%s\t%s\t%d\t%d\t%d\n", ps.Manufacturer, ps.Mpn, ps.Boms, ps.Qty, ps.AltBoms)
+		}
+		tabWriter.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(stats); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+}
+
+// Checks (and with -repair, fixes) the files of a JSON file store. Exits
+// like verify if any problems are left.
+func fsckCmd() {
+	if flag.NArg() != 1 {
+		log.Fatal("Error: wrong number of arguments (expected none)")
+	}
+	openBomStore()
+	jfbs, ok := bomstore.(*JSONFileBomStore)
+	if !ok {
+		log.Fatal("Error: fsck only works on a JSON file store (eg, -path ./filestore)")
+	}
+	problems, err := jfbs.Fsck(*fsckRepair)
+	if err != nil {
+		log.Fatal(err)
+	}
+	left := 0
+	for _, p := range problems {
+		if !p.Repaired {
+			left++
+		}
+	}
+	switch *outFormat {
+	case "text", "":
+		tabWriter := tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+		for _, p := range problems {
+			status := ""
+			if p.Repaired {
+				status = "repaired"
+			}
+			fmt.Fprintf(tabWriter, "%s\t%s\t%s\n", p.Path, p.Problem, status)
+		}
+		tabWriter.Flush()
+		fmt.Printf("%d problems found, %d repaired\n", len(problems), len(problems)-left)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(problems); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
+	}
+	if left > 0 {
+		os.Exit(exitCorrupt)
+	}
+}
+
+// Writes the whole store to a gzipped tarball. The archive is written next to
+// its final name and renamed into place, so a failed export never replaces an
+// earlier backup with a partial one.
+func exportStoreCmd() {
+	if flag.NArg() != 2 {
+		log.Fatal("Error: wrong number of arguments (expected archive file name)")
+	}
+	fname := flag.Arg(1)
+	openBomStore()
+	f, err := ioutil.TempFile(path.Dir(fname), "."+path.Base(fname)+".tmp")
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats, err := ExportStore(bomstore, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		storeFatal(err)
+	}
+	fmt.Printf("exported %d BOMs (%d versions) to %s\n", stats.Boms, stats.Versions, fname)
+}
+
+// Restores an archive from export-store into the -store, which needn't be the
+// same kind of store it came from.
+func importStoreCmd() {
+	if flag.NArg() != 2 {
+		log.Fatal("Error: wrong number of arguments (expected archive file name)")
+	}
+	f, err := os.Open(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	openBomStore()
+	stats, err := ImportStore(bomstore, f)
+	if err != nil {
+		if stats.Boms > 0 {
+			log.Printf("imported %d BOMs (%d versions) before failing", stats.Boms, stats.Versions)
+		}
+		storeFatal(err)
+	}
+	fmt.Printf("imported %d BOMs (%d versions) from %s\n", stats.Boms, stats.Versions, flag.Arg(1))
+}
+
+// Lists manufacturer names used in the head version of any BOM which aren't
+// in the alias registry, with how many lines and BOMs use them.
+func manufacturersCmd() {
+	if flag.NArg() > 2 {
+		log.Fatal("Error: too many arguments (expected optional user)")
+	}
+	user := ShortName("")
+	if flag.NArg() == 2 {
+		if !isShortName(flag.Arg(1)) {
+			fatalInvalid("Error: not a possible username: " + flag.Arg(1))
+		}
+		user = ShortName(flag.Arg(1))
+	}
+
+	openBomStore()
+	bomMetas, err := bomstore.ListBoms(user, *showArchived)
+	if err != nil {
+		storeFatal(err)
+	}
+	lines := make(map[string]int)
+	boms := make(map[string][]string)
 	for _, bm := range bomMetas {
-		fmt.Println(bm.Owner + "/" + bm.Name)
+		_, b, err := bomstore.GetHead(ShortName(bm.Owner), ShortName(bm.Name))
+		if err != nil {
+			storeFatal(err)
+		}
+		for _, name := range CanonicalizeManufacturers(b) {
+			boms[name] = append(boms[name], bm.Owner+"/"+bm.Name)
+		}
+		for _, li := range b.LineItems {
+			if _, ok := boms[li.Manufacturer]; ok {
+				lines[li.Manufacturer]++
+			}
+			for _, alt := range li.Alternates {
+				if _, ok := boms[alt.Manufacturer]; ok {
+					lines[alt.Manufacturer]++
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(boms))
+	for name := range boms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch *outFormat {
+	case "text", "":
+		if len(names) == 0 {
+			fmt.Println("all manufacturer names are recognized")
+			return
+		}
+		tabWriter := tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+		fmt.Fprintf(tabWriter, "manufacturer\tlines\tboms\n")
+		for _, name := range names {
+			fmt.Fprintf(tabWriter, "%s\t%d\t%s\n", name, lines[name], strings.Join(boms[name], ", "))
+		}
+		tabWriter.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(boms); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Error: unknown/unimplemented format: " + *outFormat)
 	}
 }
 
 func printUsage() {
 	fmt.Println("bommom is a tool for managing and publishing electronics BOMs")
 	fmt.Println("")
-	fmt.Println("Usage (flags must go first?):")
+	fmt.Println("Usage (flags may go before or after the command):")
 	fmt.Println("\tbommom [options] command [arguments]")
 	fmt.Println("")
 	fmt.Println("Commands:")
 	fmt.Println("")
 	fmt.Println("\tinit \t\t initialize BOM and authentication datastores")
-	fmt.Println("\tlist [user]\t\t list BOMs, optionally filtered by user")
+	fmt.Println("\tlist [user]\t\t list BOMs, optionally filtered by user (-archived to include archived)")
 	fmt.Println("\tload <file.type> <user> <bom_name> <version>\t import a BOM")
 	fmt.Println("\tdump <user> <name> [file.type]\t dump a BOM to stdout")
 	fmt.Println("\tconvert <infile.type> <outfile.type>\t convert a BOM file")
+	fmt.Println("\tverify [user] [name]\t check stored versions against their content hashes")
+	fmt.Println("\tfsck\t\t check the JSON file store for broken or hand-edited files (-repair to fix)")
+	fmt.Println("\tdiff <user> <name> <v1> <v2>\t show changes between two versions")
+	fmt.Println("\thistory <user> <name>\t list all versions of a BOM")
+	fmt.Println("\trevert <user> <name> <version>\t make an earlier version the head")
+	fmt.Println("\tfork <user> <name> <newuser> <newname> [version]\t fork the head (or a version) of a BOM")
+	fmt.Println("\tcopy <user> <name> <newuser> <newname>\t fork a BOM with all of its history")
+	fmt.Println("\ttransfer <user> <name> <newuser>\t give a BOM to a different owner")
+	fmt.Println("\tdelete <user> <name> <version>\t delete a version (not the head)")
+	fmt.Println("\tarchive <user> <name>\t hide a BOM from listings (unarchive to undo)")
+	fmt.Println("\tlinelog <user> <name> <line> [versions...]\t history of one line across versions")
+	fmt.Println("\tmerge <user> <name> <base> <ours> <theirs> <version>\t three-way merge of versions")
+	fmt.Println("\tlint <user> <name> [version]\t check a BOM for common problems (-suppress rule[:line] to silence one)")
+	fmt.Println("\tconsolidate <user> <name> <version>\t merge duplicate lines into a new version")
+	fmt.Println("\tcost <user> <name> <qty>\t cost of building qty boards")
+	fmt.Println("\tmanufacturers [user]\t list manufacturer names missing from the alias registry")
+	fmt.Println("\trisk <user> <name> [version]\t report obsolete, single-sourced, long-lead and non-compliant parts")
+	fmt.Println("\twhereused <manufacturer> <mpn>\t list BOMs using a part (SQL store only)")
+	fmt.Println("\tpartstats [count]\t most widely used parts (SQL store only)")
+	fmt.Println("\texport-store <archive.tar.gz>\t write every BOM and version to an archive")
+	fmt.Println("\timport-store <archive.tar.gz>\t restore an archive into the (possibly different) -store")
 	fmt.Println("\tserve\t\t serve up web interface over HTTP")
 	fmt.Println("")
+	fmt.Println("Exit status is 3 if a BOM or version doesn't exist, 4 if it already does,")
+	fmt.Println("5 for invalid names or requests, 6 for corrupt files, and 1 for other errors.")
+	fmt.Println("")
 	fmt.Println("Extra command line options:")
 	fmt.Println("")
 	flag.PrintDefaults()
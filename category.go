@@ -0,0 +1,373 @@
+package main
+
+// Part category taxonomy and automatic classification of LineItems.
+//
+// Categories are written as a hierarchy in a comma seperated list, most
+// general first, eg "Passive,Capacitor,Ceramic". This is the same format as
+// LineItem.Category.
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Category struct {
+	Name     string
+	Parent   *Category
+	Children []*Category
+}
+
+// Full hierarchy path of the category, eg "Passive,Capacitor,Ceramic".
+func (c *Category) Path() string {
+	if c.Parent == nil || c.Parent.Parent == nil {
+		return c.Name
+	}
+	return c.Parent.Path() + "," + c.Name
+}
+
+func (c *Category) child(name string) *Category {
+	for _, ch := range c.Children {
+		if strings.EqualFold(ch.Name, name) {
+			return ch
+		}
+	}
+	return nil
+}
+
+// Root of the category tree; it has no name of its own.
+var categoryRoot = &Category{}
+
+var defaultCategories = []string{
+	"Passive,Resistor,Chip",
+	"Passive,Resistor,Through Hole",
+	"Passive,Resistor,Network",
+	"Passive,Resistor,Potentiometer",
+	"Passive,Capacitor,Ceramic",
+	"Passive,Capacitor,Electrolytic",
+	"Passive,Capacitor,Tantalum",
+	"Passive,Capacitor,Film",
+	"Passive,Inductor",
+	"Passive,Ferrite Bead",
+	"Passive,Crystal",
+	"Passive,Fuse",
+	"Discrete,Diode,LED",
+	"Discrete,Diode,Zener",
+	"Discrete,Diode,Schottky",
+	"Discrete,Transistor,MOSFET",
+	"Discrete,Transistor,BJT",
+	"IC,MCU",
+	"IC,Memory",
+	"IC,Power,Regulator",
+	"IC,Power,Converter",
+	"IC,Interface",
+	"IC,Logic",
+	"IC,Analog,Op Amp",
+	"IC,Sensor",
+	"IC,Oscillator",
+	"Connector,Header",
+	"Connector,USB",
+	"Connector,Card Slot",
+	"Electromechanical,Switch",
+	"Electromechanical,Relay",
+	"Electromechanical,Speaker",
+	"Mechanical,PCB",
+	"Mechanical,Hardware",
+	"Mechanical,Enclosure",
+}
+
+func init() {
+	for _, path := range defaultCategories {
+		RegisterCategory(path)
+	}
+}
+
+// Adds a category (and any missing parents) to the tree, returning the leaf.
+// Registering an existing category is harmless.
+func RegisterCategory(path string) *Category {
+	node := categoryRoot
+	for _, name := range splitCategory(path) {
+		next := node.child(name)
+		if next == nil {
+			next = &Category{Name: name, Parent: node}
+			node.Children = append(node.Children, next)
+		}
+		node = next
+	}
+	return node
+}
+
+// Finds a registered category by path, or returns nil.
+func GetCategory(path string) *Category {
+	node := categoryRoot
+	for _, name := range splitCategory(path) {
+		if node = node.child(name); node == nil {
+			return nil
+		}
+	}
+	if node == categoryRoot {
+		return nil
+	}
+	return node
+}
+
+// Paths of every registered category, in tree order.
+func ListCategories() []string {
+	paths := []string{}
+	var walk func(c *Category)
+	walk = func(c *Category) {
+		for _, ch := range c.Children {
+			paths = append(paths, ch.Path())
+			walk(ch)
+		}
+	}
+	walk(categoryRoot)
+	return paths
+}
+
+// Registers extra categories from a file with one path per line. Blank lines
+// and lines starting with '#' are ignored.
+func LoadCategories(input io.Reader) error {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		RegisterCategory(line)
+	}
+	return scanner.Err()
+}
+
+func splitCategory(path string) []string {
+	names := []string{}
+	for _, name := range strings.Split(path, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Top level of a category path, eg "Passive" for "Passive,Capacitor,Ceramic".
+func topCategory(path string) string {
+	names := splitCategory(path)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// ---------- classification
+
+// Designator prefixes (letters only), longest match first.
+var designatorCategories = []struct{ prefix, category string }{
+	{"FB", "Passive,Ferrite Bead"},
+	{"RN", "Passive,Resistor,Network"},
+	{"VR", "Passive,Resistor,Potentiometer"},
+	{"LED", "Discrete,Diode,LED"},
+	{"IC", "IC"},
+	{"SW", "Electromechanical,Switch"},
+	{"CN", "Connector"},
+	{"JP", "Connector,Header"},
+	{"R", "Passive,Resistor"},
+	{"C", "Passive,Capacitor"},
+	{"L", "Passive,Inductor"},
+	{"Y", "Passive,Crystal"},
+	{"X", "Passive,Crystal"},
+	{"F", "Passive,Fuse"},
+	{"D", "Discrete,Diode"},
+	{"Q", "Discrete,Transistor"},
+	{"U", "IC"},
+	{"J", "Connector"},
+	{"P", "Connector"},
+	{"K", "Electromechanical,Relay"},
+	{"S", "Electromechanical,Switch"},
+}
+
+// Keywords looked for in descriptions (lowercased), first match wins.
+var keywordCategories = []struct{ keyword, category string }{
+	{"microcontroller", "IC,MCU"},
+	{"mcu", "IC,MCU"},
+	{"processor", "IC,MCU"},
+	{"eeprom", "IC,Memory"},
+	{"flash", "IC,Memory"},
+	{"sdram", "IC,Memory"},
+	{"regulator", "IC,Power,Regulator"},
+	{"ldo", "IC,Power,Regulator"},
+	{"dc-dc", "IC,Power,Converter"},
+	{"buck", "IC,Power,Converter"},
+	{"boost", "IC,Power,Converter"},
+	{"op amp", "IC,Analog,Op Amp"},
+	{"opamp", "IC,Analog,Op Amp"},
+	{"usb-to-serial", "IC,Interface"},
+	{"transceiver", "IC,Interface"},
+	{"sensor", "IC,Sensor"},
+	{"oscillator", "IC,Oscillator"},
+	{"crystal", "Passive,Crystal"},
+	{"ferrite", "Passive,Ferrite Bead"},
+	{"led", "Discrete,Diode,LED"},
+	{"zener", "Discrete,Diode,Zener"},
+	{"schottky", "Discrete,Diode,Schottky"},
+	{"diode", "Discrete,Diode"},
+	{"mosfet", "Discrete,Transistor,MOSFET"},
+	{"transistor", "Discrete,Transistor"},
+	{"electrolytic", "Passive,Capacitor,Electrolytic"},
+	{"tantalum", "Passive,Capacitor,Tantalum"},
+	{"ceramic", "Passive,Capacitor,Ceramic"},
+	{"capacitor", "Passive,Capacitor"},
+	{"inductor", "Passive,Inductor"},
+	{"resistor", "Passive,Resistor"},
+	{"potentiometer", "Passive,Resistor,Potentiometer"},
+	{"fuse", "Passive,Fuse"},
+	{"header", "Connector,Header"},
+	{"usb", "Connector,USB"},
+	{"microsd", "Connector,Card Slot"},
+	{"connector", "Connector"},
+	{"switch", "Electromechanical,Switch"},
+	{"relay", "Electromechanical,Relay"},
+	{"speaker", "Electromechanical,Speaker"},
+	{"pcb", "Mechanical,PCB"},
+	{"screw", "Mechanical,Hardware"},
+	{"standoff", "Mechanical,Hardware"},
+	{"enclosure", "Mechanical,Enclosure"},
+}
+
+// Values in specs like "10k", "4.7uF", "100nH", "22R"
+var (
+	capacitanceSpec = regexp.MustCompile(`(?i)^[0-9.]+ ?[pnuµm]f$`)
+	inductanceSpec  = regexp.MustCompile(`(?i)^[0-9.]+ ?[pnuµm]h$`)
+	resistanceSpec  = regexp.MustCompile(`(?i)^[0-9.]+ ?([rkm]|ohms?|Ω)[0-9]*$`)
+)
+
+var designatorPrefix = regexp.MustCompile(`^[A-Za-z]+`)
+
+// Guesses a category path for a LineItem from (in order of preference) its
+// description, parsed specs, and element designator prefixes. Returns "" if
+// nothing matched.
+func ClassifyLineItem(li *LineItem) string {
+	desc := strings.ToLower(li.Description + " " + li.FormFactor)
+	for _, kc := range keywordCategories {
+		if containsWord(desc, kc.keyword) {
+			return refineCategory(kc.category, li)
+		}
+	}
+	for _, spec := range strings.Split(li.Specs, ",") {
+		spec = strings.TrimSpace(spec)
+		switch {
+		case capacitanceSpec.MatchString(spec):
+			return refineCategory("Passive,Capacitor", li)
+		case inductanceSpec.MatchString(spec):
+			return "Passive,Inductor"
+		case resistanceSpec.MatchString(spec):
+			return refineCategory("Passive,Resistor", li)
+		}
+	}
+	for _, el := range li.Elements {
+		prefix := strings.ToUpper(designatorPrefix.FindString(el.Id))
+		if prefix == "" {
+			continue
+		}
+		for _, dc := range designatorCategories {
+			if prefix == dc.prefix {
+				return refineCategory(dc.category, li)
+			}
+		}
+	}
+	return ""
+}
+
+// Adds a more specific sub-category where the form factor makes it obvious.
+func refineCategory(path string, li *LineItem) string {
+	ff := strings.ToUpper(li.FormFactor)
+	smd := strings.HasPrefix(ff, "SM") || strings.Contains(ff, "0402") ||
+		strings.Contains(ff, "0603") || strings.Contains(ff, "0805") ||
+		strings.Contains(ff, "1206")
+	switch path {
+	case "Passive,Resistor":
+		if smd {
+			return "Passive,Resistor,Chip"
+		}
+	case "Passive,Capacitor":
+		if smd {
+			return "Passive,Capacitor,Ceramic"
+		}
+	}
+	return path
+}
+
+func containsWord(s, word string) bool {
+	for start := 0; ; {
+		i := strings.Index(s[start:], word)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(word)
+		if (i == 0 || !isAlnum(s[i-1])) && (end == len(s) || !isAlnum(s[end])) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func isAlnum(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('0' <= c && c <= '9')
+}
+
+// Fills in Category for every LineItem which doesn't already have one.
+func ClassifyBom(b *Bom) {
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		if strings.TrimSpace(li.Category) == "" {
+			li.Category = ClassifyLineItem(li)
+		}
+	}
+}
+
+// ---------- breakdowns
+
+// Count and cost totals for one top-level category of a Bom.
+type CategoryStat struct {
+	Category string
+	Lines    int
+	Elements int
+	Cost     Money
+	Unpriced int // lines with no available offer
+}
+
+// Breaks a Bom down by top-level category, with per-board cost (in currency)
+// based on the cheapest available source for each line. Sorted by category
+// name, with uncategorized lines last.
+func CategoryBreakdown(b *Bom, currency string) []CategoryStat {
+	stats := make(map[string]*CategoryStat)
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		cat := topCategory(li.Category)
+		st, ok := stats[cat]
+		if !ok {
+			st = &CategoryStat{Category: cat}
+			stats[cat] = st
+		}
+		st.Lines++
+		st.Elements += len(li.Elements)
+		if src := li.BestSource(currency); src != nil {
+			st.Cost += src.UnitPrice.Mul(uint32(li.FittedQty()))
+		} else {
+			st.Unpriced++
+		}
+	}
+	list := make([]CategoryStat, 0, len(stats))
+	for _, st := range stats {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Category == "") != (list[j].Category == "") {
+			return list[j].Category == ""
+		}
+		return list[i].Category < list[j].Category
+	})
+	return list
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCategoryTree(t *testing.T) {
+	if c := GetCategory("passive, capacitor, ceramic"); c == nil || c.Path() != "Passive,Capacitor,Ceramic" {
+		t.Errorf("built-in category not found")
+	}
+	if GetCategory("Passive,Capacitor,Unobtainium") != nil {
+		t.Errorf("found a category which was never registered")
+	}
+	LoadCategories(strings.NewReader("# extra\nPassive,Capacitor,Unobtainium\n"))
+	if GetCategory("Passive,Capacitor,Unobtainium") == nil {
+		t.Errorf("registered category not found")
+	}
+}
+
+var classifyTests = []struct {
+	li       LineItem
+	category string
+}{
+	{LineItem{Elements: NewElements("R5"), FormFactor: "SM0603"}, "Passive,Resistor,Chip"},
+	{LineItem{Elements: NewElements("C1", "C2")}, "Passive,Capacitor"},
+	{LineItem{Specs: "4.7uF, 10V"}, "Passive,Capacitor"},
+	{LineItem{Elements: NewElements("U1"), Description: "ARM920T Processor (180MHz)"}, "IC,MCU"},
+	{LineItem{Elements: NewElements("IC1"), Description: "USB-to-Serial Converter"}, "IC,Interface"},
+	{LineItem{Elements: NewElements("J3")}, "Connector"},
+	{LineItem{Description: "controlled impedance thingy"}, ""},
+}
+
+func TestClassifyLineItem(t *testing.T) {
+	for _, ct := range classifyTests {
+		if got := ClassifyLineItem(&ct.li); got != ct.category {
+			t.Errorf("expected %q, got %q for %v", ct.category, got, ct.li)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+// Merging of duplicate LineItems, eg from CSV files which list the same part
+// on several rows.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Something which couldn't be combined cleanly when merging duplicate lines.
+type ConsolidationNote struct {
+	Id      string `json:"id"`
+	Field   string `json:"field"`
+	Kept    string `json:"kept"`
+	Dropped string `json:"dropped"`
+}
+
+// Merges LineItems with the same identity (manufacturer and mpn) into the
+// first such line: elements, tags and alternates are combined, and comments are
+// joined. For other fields the first line's value is kept; differing values
+// are reported in the returned notes. Returns the number of lines removed.
+func ConsolidateBom(b *Bom) (int, []ConsolidationNote) {
+	notes := []ConsolidationNote{}
+	lines := []LineItem{}
+	index := make(map[string]int)
+	for _, li := range b.LineItems {
+		id := li.Id()
+		// lines with no part number at all are placeholders; leave them be
+		i, ok := index[id]
+		if !ok || strings.TrimSpace(li.Mpn) == "" {
+			index[id] = len(lines)
+			lines = append(lines, li)
+			continue
+		}
+		notes = append(notes, consolidateLine(&lines[i], &li)...)
+	}
+	removed := len(b.LineItems) - len(lines)
+	b.LineItems = lines
+	return removed, notes
+}
+
+func consolidateLine(into, from *LineItem) []ConsolidationNote {
+	notes := []ConsolidationNote{}
+	keepFirst := func(field string, kept *string, other string) {
+		switch {
+		case other == "" || other == *kept:
+		case *kept == "":
+			*kept = other
+		default:
+			notes = append(notes, ConsolidationNote{Id: into.Id(), Field: field, Kept: *kept, Dropped: other})
+		}
+	}
+	keepFirst("description", &into.Description, from.Description)
+	keepFirst("form_factor", &into.FormFactor, from.FormFactor)
+	keepFirst("specs", &into.Specs, from.Specs)
+	keepFirst("category", &into.Category, from.Category)
+	if from.Comment != "" && from.Comment != into.Comment {
+		if into.Comment != "" {
+			notes = append(notes, ConsolidationNote{Id: into.Id(), Field: "comment", Kept: into.Comment + "; " + from.Comment})
+			into.Comment += "; " + from.Comment
+		} else {
+			into.Comment = from.Comment
+		}
+	}
+
+	// placeholder ("") elements only count towards quantity; real designators
+	// are only listed once
+	seen := stringSet(into.ElementIds())
+	for _, el := range from.Elements {
+		if el.Id == "" || !seen[el.Id] {
+			into.Elements = append(into.Elements, el)
+			seen[el.Id] = true
+		}
+	}
+	into.Tags = ParseTags(strings.Join(append(into.Tags, from.Tags...), ","))
+	if len(into.Offers) == 0 {
+		into.Offers = from.Offers
+	}
+	for _, alt := range from.Alternates {
+		dup := false
+		for _, existing := range into.Alternates {
+			if existing.Manufacturer == alt.Manufacturer && existing.Mpn == alt.Mpn {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			into.Alternates = append(into.Alternates, alt)
+		}
+	}
+	return notes
+}
+
+func DumpConsolidationNotes(removed int, notes []ConsolidationNote, out io.Writer) {
+	fmt.Fprintf(out, "%d duplicate lines merged\n", removed)
+	for _, n := range notes {
+		if n.Dropped != "" {
+			fmt.Fprintf(out, "\t%s %s: kept %q, dropped %q\n", n.Id, n.Field, n.Kept, n.Dropped)
+		} else {
+			fmt.Fprintf(out, "\t%s %s: combined as %q\n", n.Id, n.Field, n.Kept)
+		}
+	}
+}
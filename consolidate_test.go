@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConsolidateBom(t *testing.T) {
+	b := NewBom("v001")
+	b.LineItems = []LineItem{
+		{Manufacturer: "Yageo", Mpn: "RC0603-10K", Description: "10k resistor", Elements: NewElements("R1", "R2")},
+		{Manufacturer: "Atmel", Mpn: "ATTINY85", Elements: NewElements("U1")},
+		{Manufacturer: "Yageo", Mpn: "RC0603-10K", Description: "pull-up", Comment: "1%", Elements: NewElements("R2", "R3"), Tags: []string{"power"}},
+		{Elements: NewElements("")},
+		{Elements: NewElements("")},
+	}
+	removed, notes := ConsolidateBom(b)
+	if removed != 1 || len(b.LineItems) != 4 {
+		t.Fatalf("expected one line merged, got %d (%d lines)", removed, len(b.LineItems))
+	}
+	li := b.GetLineItem("Yageo", "RC0603-10K")
+	if len(li.Elements) != 3 || li.Comment != "1%" || len(li.Tags) != 1 {
+		t.Errorf("lines not combined properly: %v", li)
+	}
+	if len(notes) != 1 || notes[0].Field != "description" || notes[0].Kept != "10k resistor" {
+		t.Errorf("expected description conflict to be reported, got %v", notes)
+	}
+
+	// GetLineItem should allow in-place edits
+	li.Comment = "edited"
+	if b.LineItems[0].Comment != "edited" {
+		t.Errorf("edit through GetLineItem was lost")
+	}
+}
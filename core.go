@@ -1,13 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type OfferPrice struct {
-	Currency string  `json:"currency"`
-	MinQty   uint32  `json:"min_qty"`
-	Price    float32 `json:"price"`
+	Currency string `json:"currency"`
+	MinQty   uint32 `json:"min_qty"`
+	Price    Money  `json:"price"`
 }
 
 type Offer struct {
@@ -16,25 +17,167 @@ type Offer struct {
 	Url         string       `json:"distributor_url"`
 	Comment     string       `json:"comment"`
 	Available   uint32       `json:"avail"`
+	LeadWeeks   uint32       `json:"lead_weeks,omitempty"` // factory lead time
 	Prices      []OfferPrice `json:"prices"`
 }
 
 type LineItem struct {
+	Uid           string            `json:"uid,omitempty"` // stable across versions; see AssignLineUids
 	Manufacturer  string            `json:"manufacturer"`
 	Mpn           string            `json:"mpn"`
 	Description   string            `json:"description"`
 	FormFactor    string            `json:"form_factor"` // type:string
 	Specs         string            `json:"specs"`       // comma seperated list
 	Comment       string            `json:"comment"`
-	Tag           string            `json:"tag"`      // comma seperated list
-	Category      string            `json:"category"` // hierarchy as comma seperated list
-	Elements      []string          `json:"elements"`
+	Tags          []string          `json:"tags"`
+	Category      string            `json:"category"`             // hierarchy as comma seperated list
+	Lifecycle     string            `json:"lifecycle,omitempty"`  // active, nrnd, eol, obsolete
+	Rohs          string            `json:"rohs,omitempty"`       // compliant, exempt, non-compliant
+	Reach         string            `json:"reach,omitempty"`      // compliant, exempt, non-compliant
+	LeadWeeks     uint32            `json:"lead_weeks,omitempty"` // overrides offer lead times
+	Elements      []Element         `json:"elements"`
 	Offers        []Offer           `json:"offers"`
-	AggregateInfo map[string]string `json:"miscinfo"`
+	Alternates    []Alternate       `json:"alternates"`
+	AggregateInfo map[string]string `json:"miscinfo" xml:"-"`
 }
 
+// Identity of the part, using the canonical manufacturer name so that aliases
+// of the same company match.
 func (li *LineItem) Id() string {
-	return li.Manufacturer + "::" + li.Mpn
+	return CanonicalManufacturer(li.Manufacturer) + "::" + li.Mpn
+}
+
+// Older JSON files have a single comma seperated "tag" string instead of the
+// "tags" list; this accepts either.
+func (li *LineItem) UnmarshalJSON(data []byte) error {
+	type plainLineItem LineItem
+	aux := struct {
+		*plainLineItem
+		Tag string `json:"tag"`
+	}{plainLineItem: (*plainLineItem)(li)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(li.Tags) == 0 && aux.Tag != "" {
+		li.Tags = ParseTags(aux.Tag)
+	}
+	return nil
+}
+
+// Approval states for an Alternate, per the approved vendor list (AVL).
+const (
+	AltApproved = "approved"
+	AltPending  = "pending"
+	AltRejected = "rejected"
+)
+
+// An interchangeable "second source" part which can be fitted in place of the
+// primary Manufacturer/Mpn of a LineItem.
+type Alternate struct {
+	Manufacturer  string            `json:"manufacturer"`
+	Mpn           string            `json:"mpn"`
+	Status        string            `json:"status"` // approved, pending, or rejected
+	Comment       string            `json:"comment"`
+	Offers        []Offer           `json:"offers"`
+	AggregateInfo map[string]string `json:"miscinfo" xml:"-"`
+}
+
+// Returns the price break applicable when buying qty units, or nil if qty is
+// below the smallest minimum quantity.
+func (o *Offer) PriceAt(qty uint32) *OfferPrice {
+	var best *OfferPrice
+	for i := range o.Prices {
+		op := &o.Prices[i]
+		if op.MinQty <= qty && (best == nil || op.MinQty > best.MinQty) {
+			best = op
+		}
+	}
+	return best
+}
+
+// Cheapest source for a single board's worth of this LineItem, priced in the
+// given currency.
+func (li *LineItem) BestSource(currency string) *Source {
+	return li.CheapestSource(uint32(li.FittedQty()), currency)
+}
+
+// Whether the primary part or an approved Alternate has an offer with a price
+// break for, and at least, qty units in stock. Unlike CheapestSource this
+// doesn't depend on exchange rates.
+func (li *LineItem) HasStock(qty uint32) bool {
+	stocked := func(offers []Offer) bool {
+		for i := range offers {
+			if offers[i].Available >= qty && offers[i].PriceAt(qty) != nil {
+				return true
+			}
+		}
+		return false
+	}
+	if stocked(li.Offers) {
+		return true
+	}
+	for _, alt := range li.Alternates {
+		if alt.Status == AltApproved && stocked(alt.Offers) {
+			return true
+		}
+	}
+	return false
+}
+
+// A concrete place to buy the part for a LineItem: either the primary part or
+// one of the Alternates, with the selected Offer and price break. UnitPrice is
+// the price break converted to Currency.
+type Source struct {
+	Manufacturer string     `json:"manufacturer"`
+	Mpn          string     `json:"mpn"`
+	IsAlternate  bool       `json:"is_alternate"`
+	Offer        *Offer     `json:"offer"`
+	Price        OfferPrice `json:"price"`
+	UnitPrice    Money      `json:"unit_price"`
+	Currency     string     `json:"currency"`
+}
+
+// Picks the cheapest offer with at least qty units in stock, considering the
+// primary part and every approved Alternate. Prices are
+// compared in the given currency using the current exchangeRates; offers which
+// can't be converted are skipped. Returns nil if no source is available.
+func (li *LineItem) CheapestSource(qty uint32, currency string) *Source {
+	var best *Source
+	now := time.Now()
+	consider := func(mfg, mpn string, isAlt bool, offers []Offer) {
+		for i := range offers {
+			o := &offers[i]
+			if o.Available < qty {
+				continue
+			}
+			op := o.PriceAt(qty)
+			if op == nil {
+				continue
+			}
+			price, err := exchangeRates.Convert(op.Price, op.Currency, currency, now)
+			if err != nil {
+				continue
+			}
+			if best == nil || price < best.UnitPrice {
+				best = &Source{Manufacturer: mfg,
+					Mpn:         mpn,
+					IsAlternate: isAlt,
+					Offer:       o,
+					Price:       *op,
+					UnitPrice:   price,
+					Currency:    NormalizeCurrency(currency)}
+			}
+		}
+	}
+	consider(li.Manufacturer, li.Mpn, false, li.Offers)
+	for i := range li.Alternates {
+		alt := &li.Alternates[i]
+		if alt.Status != AltApproved {
+			continue
+		}
+		consider(alt.Manufacturer, alt.Mpn, true, alt.Offers)
+	}
+	return best
 }
 
 // The main anchor of a BOM as a cohesive whole, with a name and permissions.
@@ -48,6 +191,14 @@ type BomMeta struct {
 	Homepage     Url    `json:"homepage_url"`
 	IsPublicView bool   `json:"is_publicview",omitempty`
 	IsPublicEdit bool   `json:"is_publicedit",omitempty`
+	// lint rule names (or "rule:line_id") to ignore for this BOM
+	LintSuppress []string `json:"lint_suppress,omitempty"`
+	// hidden from listings, but still readable
+	IsArchived bool `json:"is_archived,omitempty"`
+	// the BOM this one was forked or copied from, if any
+	ForkOf *BomRef `json:"fork_of,omitempty"`
+	// Bom.Hash() of each persisted version, by version name
+	Hashes map[string]string `json:"hashes,omitempty" xml:"-"`
 }
 
 // An actual list of parts/elements. Intended to be immutable once persisted. 
@@ -55,19 +206,41 @@ type Bom struct {
 	Version string `json:"version"`
 	// TODO: unix timestamp?
 	Created time.Time `json:"created_ts"`
-	// "where did this BOM come from?"
+	// "where did this BOM come from?" as free text for people: the file it was
+	// imported from, what was merged, and so on. Shown in the history and used
+	// as the git commit message, so it stays even though Parent now records
+	// forks and copies.
 	Progeny   string     `json:"progeny",omitifempty`
+	// the version this one was forked or copied from, if any; the structured
+	// link that code should follow
+	Parent    *BomRef    `json:"parent,omitempty"`
 	LineItems []LineItem `json:"line_items"`
+	Variants  []Variant  `json:"variants"`
+	// set on the fitted Bom for a single variant; see ForVariant()
+	Variant string `json:"variant,omitempty"`
 }
 
 func NewBom(version string) *Bom {
 	return &Bom{Version: version, Created: time.Now()}
 }
 
+// Returns a pointer into b.LineItems, so edits through it change the Bom. The
+// pointer is only good until LineItems is next appended to.
 func (b *Bom) GetLineItem(mfg, mpn string) *LineItem {
-	for _, li := range b.LineItems {
-		if li.Manufacturer == mfg && li.Mpn == mpn {
-			return &li
+	mfg = CanonicalManufacturer(mfg)
+	for i := range b.LineItems {
+		if CanonicalManufacturer(b.LineItems[i].Manufacturer) == mfg && b.LineItems[i].Mpn == mpn {
+			return &b.LineItems[i]
+		}
+	}
+	return nil
+}
+
+// Same as GetLineItem, but by LineItem.Id().
+func (b *Bom) FindLineItem(id string) *LineItem {
+	for i := range b.LineItems {
+		if b.LineItems[i].Id() == id {
+			return &b.LineItems[i]
 		}
 	}
 	return nil
@@ -88,6 +261,25 @@ func (b *Bom) Validate() error {
 	if b.Created.IsZero() {
 		return Error("created timestamp not defined")
 	}
+	uids := make(map[string]bool)
+	for i := range b.LineItems {
+		if uid := b.LineItems[i].Uid; uid != "" {
+			if uids[uid] {
+				return Error("duplicate line uid: \"" + uid + "\"")
+			}
+			uids[uid] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for i := range b.Variants {
+		if err := b.Variants[i].Validate(b); err != nil {
+			return err
+		}
+		if seen[b.Variants[i].Name] {
+			return Error("duplicate variant name: \"" + b.Variants[i].Name + "\"")
+		}
+		seen[b.Variants[i].Name] = true
+	}
 	return nil
 }
 
@@ -103,22 +295,28 @@ func (bm *BomMeta) Validate() error {
 
 // ---------- testing
 func makeTestBom() (*BomMeta, *Bom) {
-	op1 := OfferPrice{Currency: "usd", Price: 1.0, MinQty: 1}
-	op2 := OfferPrice{Currency: "usd", Price: 0.8, MinQty: 100}
-	o := Offer{Sku: "A123", Distributor: "Acme", Prices: []OfferPrice{op1, op2}}
+	op1 := OfferPrice{Currency: "usd", Price: MoneyUnit, MinQty: 1}
+	op2 := OfferPrice{Currency: "usd", Price: MoneyUnit * 8 / 10, MinQty: 100}
+	o := Offer{Sku: "A123", Distributor: "Acme", Available: 500, Prices: []OfferPrice{op1, op2}}
+	altOp := OfferPrice{Currency: "usd", Price: MoneyUnit * 9 / 10, MinQty: 1}
+	altO := Offer{Sku: "B456", Distributor: "Acme", Available: 50, Prices: []OfferPrice{altOp}}
 	//o.AddOfferPrice(op1)
 	//o.AddOfferPrice(op2)
 	li := LineItem{Manufacturer: "WidgetCo",
 		Mpn:      "WIDG0001",
-		Elements: []string{"W1", "W2"},
-		Offers:   []Offer{o}}
+		Elements: NewElements("W1", "W2"),
+		Offers:   []Offer{o},
+		Alternates: []Alternate{{Manufacturer: "GadgetInc",
+			Mpn:    "GDG-01",
+			Status: AltApproved,
+			Offers: []Offer{altO}}}}
 	li2 := LineItem{Manufacturer: "Texas Instruments",
 		Mpn:      "NE555",
-		Elements: []string{"W1", "W2"},
+		Elements: NewElements("W1", "W2"),
 		Offers:   []Offer{o}}
 	li3 := LineItem{Manufacturer: "STMicroelectronics",
 		Mpn:      "L7905CV",
-		Elements: []string{"W1", "W2"},
+		Elements: NewElements("W1", "W2"),
 		Offers:   []Offer{o}}
 	//li.AddOffer(o)
 	b := NewBom("test01")
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	//"fmt"
 	"os"
@@ -23,3 +24,72 @@ func TestBomJSONDump(t *testing.T) {
 		t.Errorf("Error encoding: " + err.Error())
 	}
 }
+
+func TestCheapestSource(t *testing.T) {
+	_, b := makeTestBom()
+	li := b.GetLineItem("WidgetCo", "WIDG0001")
+	if s := li.CheapestSource(2, "USD"); s == nil || !s.IsAlternate || s.Mpn != "GDG-01" {
+		t.Errorf("expected approved alternate to be cheapest for small qty")
+	}
+	if s := li.CheapestSource(100, "USD"); s == nil || s.IsAlternate || s.Price.Price != MoneyUnit*8/10 {
+		t.Errorf("expected primary price break for large qty")
+	}
+	li.Alternates[0].Status = AltRejected
+	if s := li.CheapestSource(2, "USD"); s == nil || s.IsAlternate {
+		t.Errorf("rejected alternate should not be considered")
+	}
+	li.Alternates[0].Status = AltPending
+	if s := li.CheapestSource(2, "USD"); s == nil || s.IsAlternate {
+		t.Errorf("pending alternate should not be considered")
+	}
+	if s := li.CheapestSource(1000, "USD"); s != nil {
+		t.Errorf("nothing should be available in that quantity")
+	}
+	if !li.HasStock(500) || li.HasStock(1000) {
+		t.Errorf("unexpected stock check")
+	}
+}
+
+func TestAlternatesCSVRoundTrip(t *testing.T) {
+	_, b := makeTestBom()
+	li := &b.LineItems[0]
+	li.Alternates[0].Comment = "checked by QA, rev B"
+	li.Alternates = append(li.Alternates, Alternate{Manufacturer: "Doohickey, Ltd.",
+		Mpn:     "DH-2",
+		Status:  AltPending,
+		Comment: `pin 1 at \ corner, not /`})
+	buf := &bytes.Buffer{}
+	DumpBomAsCSV(b, buf)
+	loaded, err := LoadBomFromCSV(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alts := loaded.GetLineItem("WidgetCo", "WIDG0001").Alternates
+	if len(alts) != 2 {
+		t.Fatalf("expected 2 alternates, got %+v", alts)
+	}
+	for i, alt := range alts {
+		orig := li.Alternates[i]
+		if alt.Manufacturer != orig.Manufacturer || alt.Mpn != orig.Mpn || alt.Status != orig.Status || alt.Comment != orig.Comment {
+			t.Errorf("alternate %d changed: %+v", i, alt)
+		}
+	}
+
+	// hand-written lists don't need escaping
+	if alts := parseAlternates("", "A-1, B-2", "approved", `see C:\notes,`); len(alts) != 2 || alts[0].Comment != `see C:\notes` || alts[1].Comment != "" {
+		t.Errorf("unexpected alternates: %+v", alts)
+	}
+}
+
+func TestLegacyTagJSON(t *testing.T) {
+	li := LineItem{}
+	if err := json.Unmarshal([]byte(`{"mpn": "NE555", "tag": "Power, timing,,power"}`), &li); err != nil {
+		t.Fatal(err)
+	}
+	if len(li.Tags) != 2 || li.Tags[0] != "power" || li.Tags[1] != "timing" {
+		t.Errorf("legacy tag string not parsed: %v", li.Tags)
+	}
+	if li.Mpn != "NE555" {
+		t.Errorf("other fields not decoded")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+// Cost of building N boards from a Bom: price breaks are applied for the
+// actual order quantity of each part, including extra parts to cover
+// attrition (parts lost or damaged during assembly).
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Overage to order for lines matching Category (a category path prefix) and
+// FormFactor (a substring); empty fields match anything. Rate is a fraction,
+// eg 0.02 for 2%.
+type AttritionRule struct {
+	Category   string  `json:"category"`
+	FormFactor string  `json:"form_factor"`
+	Rate       float64 `json:"rate"`
+}
+
+// First matching rule wins, so more specific rules go first.
+var attritionRules = []AttritionRule{
+	{Category: "Passive", FormFactor: "0201", Rate: 0.05},
+	{Category: "Passive", FormFactor: "0402", Rate: 0.02},
+	{Category: "Passive", Rate: 0.01},
+}
+
+func LoadAttritionRules(input io.Reader) ([]AttritionRule, error) {
+	rules := []AttritionRule{}
+	dec := json.NewDecoder(input)
+	if err := dec.Decode(&rules); err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if r.Rate < 0 {
+			return nil, Error("negative attrition rate for " + r.Category)
+		}
+	}
+	return rules, nil
+}
+
+func attritionRate(li *LineItem, rules []AttritionRule) float64 {
+	for _, r := range rules {
+		if !strings.HasPrefix(strings.ToLower(li.Category), strings.ToLower(r.Category)) {
+			continue
+		}
+		if !strings.Contains(strings.ToUpper(li.FormFactor), strings.ToUpper(r.FormFactor)) {
+			continue
+		}
+		return r.Rate
+	}
+	return 0
+}
+
+// Number of elements actually fitted on one board.
+func (li *LineItem) FittedQty() int {
+	n := 0
+	for _, el := range li.Elements {
+		if !el.Dnp {
+			n++
+		}
+	}
+	return n
+}
+
+type LineCost struct {
+	Id        string  `json:"id"`
+	PerBoard  int     `json:"per_board"`
+	Attrition float64 `json:"attrition"`
+	OrderQty  uint32  `json:"order_qty"`
+	Source    *Source `json:"source"` // nil if no offer can supply OrderQty
+	Cost      Money   `json:"cost"`
+}
+
+func (lc *LineCost) AttritionPercent() float64 {
+	return lc.Attrition * 100
+}
+
+type BomCost struct {
+	BuildQty uint32     `json:"build_qty"`
+	Currency string     `json:"currency"`
+	Lines    []LineCost `json:"lines"`
+	Total    Money      `json:"total"`
+	UnitCost Money      `json:"unit_cost"` // effective cost per board
+	Unpriced int        `json:"unpriced"`  // lines left out of the total
+}
+
+// Parts to order for buildQty boards with perBoard on each, plus attrition,
+// rounded up. The rate is taken as the decimal it was written as (0.07 rather
+// than the float just above it), so whole results aren't pushed up by one.
+func orderQty(perBoard int, buildQty uint32, rate float64) uint32 {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(rate, 'g', -1, 64))
+	if !ok {
+		// not a finite number; LoadAttritionRules would have refused it
+		r = new(big.Rat)
+	}
+	r.Add(r, big.NewRat(1, 1))
+	r.Mul(r, new(big.Rat).SetInt64(int64(perBoard)*int64(buildQty)))
+	q, m := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if m.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return uint32(q.Int64())
+}
+
+// Works out the cost of building buildQty boards, in the given currency. Lines
+// which can't be sourced in the required quantity (or priced in currency) are
+// counted in Unpriced and left out of the total.
+func CostRollup(b *Bom, buildQty uint32, rules []AttritionRule, currency string) *BomCost {
+	bc := &BomCost{BuildQty: buildQty, Currency: NormalizeCurrency(currency), Lines: []LineCost{}}
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		lc := LineCost{Id: li.Id(),
+			PerBoard:  li.FittedQty(),
+			Attrition: attritionRate(li, rules)}
+		if lc.PerBoard == 0 {
+			continue
+		}
+		lc.OrderQty = orderQty(lc.PerBoard, buildQty, lc.Attrition)
+		if lc.Source = li.CheapestSource(lc.OrderQty, currency); lc.Source != nil {
+			lc.Cost = lc.Source.UnitPrice.Mul(lc.OrderQty)
+			bc.Total += lc.Cost
+		} else {
+			bc.Unpriced++
+		}
+		bc.Lines = append(bc.Lines, lc)
+	}
+	bc.UnitCost = bc.Total.Div(buildQty)
+	return bc
+}
+
+// --------------------- output -----------------------
+
+func DumpCostAsText(bc *BomCost, out io.Writer) {
+	tabWriter := tabwriter.NewWriter(out, 2, 4, 1, ' ', 0)
+	fmt.Fprintf(tabWriter, "line\tper_board\tattrition\torder_qty\tsource\tunit_price\tcost\n")
+	for _, lc := range bc.Lines {
+		if lc.Source == nil {
+			fmt.Fprintf(tabWriter, "%s\t%d\t%.1f%%\t%d\t(unavailable)\t\t\n",
+				lc.Id,
+				lc.PerBoard,
+				lc.AttritionPercent(),
+				lc.OrderQty)
+			continue
+		}
+		fmt.Fprintf(tabWriter, "%s\t%d\t%.1f%%\t%d\t%s %s\t%s\t%s\n",
+			lc.Id,
+			lc.PerBoard,
+			lc.AttritionPercent(),
+			lc.OrderQty,
+			lc.Source.Offer.Distributor,
+			lc.Source.Offer.Sku,
+			lc.Source.UnitPrice,
+			lc.Cost)
+	}
+	tabWriter.Flush()
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Build quantity:\t%d\n", bc.BuildQty)
+	fmt.Fprintf(out, "Total:\t\t%s\n", FormatMoney(bc.Total, bc.Currency))
+	fmt.Fprintf(out, "Per board:\t%s\n", FormatMoney(bc.UnitCost, bc.Currency))
+	if bc.Unpriced > 0 {
+		fmt.Fprintf(out, "Unpriced lines:\t%d (not included)\n", bc.Unpriced)
+	}
+}
+
+func DumpCostAsJSON(bc *BomCost, out io.Writer) {
+	enc := json.NewEncoder(out)
+	if err := enc.Encode(bc); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCostRollup(t *testing.T) {
+	o := Offer{Distributor: "Acme", Available: 10000,
+		Prices: []OfferPrice{{Currency: "usd", MinQty: 1, Price: MoneyUnit / 10}, {Currency: "usd", MinQty: 1000, Price: MoneyUnit / 100}}}
+	b := NewBom("v001")
+	b.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0402-10K", Category: "Passive,Resistor,Chip", FormFactor: "0402",
+		Elements: NewElements("R1", "R2", "R3", "R4", "R5"), Offers: []Offer{o}})
+	b.AddLineItem(&LineItem{Manufacturer: "Atmel", Mpn: "ATTINY85", Category: "IC,MCU",
+		Elements: NewElements("U1"), Offers: []Offer{{Distributor: "Acme", Available: 150,
+			Prices: []OfferPrice{{Currency: "usd", MinQty: 1, Price: MoneyUnit}}}}})
+	b.LineItems[0].Elements[4].Dnp = true
+
+	bc := CostRollup(b, 100, attritionRules, "USD")
+	// 4 fitted resistors * 100 boards * 1.02 = 408, still below the 1000 break
+	if lc := bc.Lines[0]; lc.PerBoard != 4 || lc.OrderQty != 408 || lc.Source.Price.Price != MoneyUnit/10 {
+		t.Errorf("unexpected resistor line cost: %+v", lc)
+	}
+	if lc := bc.Lines[1]; lc.Attrition != 0 || lc.OrderQty != 100 {
+		t.Errorf("unexpected MCU line cost: %+v", lc)
+	}
+	if bc.Total.String() != "140.80" || bc.UnitCost.String() != "1.408" {
+		t.Errorf("unexpected totals: %v, %v", bc.Total, bc.UnitCost)
+	}
+
+	// 250 boards: resistors hit the 1000 price break, but only 150 MCUs exist
+	bc = CostRollup(b, 250, attritionRules, "USD")
+	if bc.Lines[0].Source.Price.Price != MoneyUnit/100 || bc.Unpriced != 1 || bc.Lines[1].Source != nil {
+		t.Errorf("expected price break and an unavailable line: %+v", bc)
+	}
+}
+
+func TestOrderQty(t *testing.T) {
+	tests := []struct {
+		perBoard int
+		buildQty uint32
+		rate     float64
+		want     uint32
+	}{
+		{1, 1900, 0.07, 2033},
+		{1, 100, 0.1, 110},
+		{3, 100, 0.015, 305},
+		{4, 100, 0.02, 408},
+		{1, 1, 0.001, 2},
+		{2, 50, 0, 100},
+	}
+	for _, test := range tests {
+		if got := orderQty(test.perBoard, test.buildQty, test.rate); got != test.want {
+			t.Errorf("%d x %d at %g: expected %d, got %d", test.perBoard, test.buildQty, test.rate, test.want, got)
+		}
+	}
+}
+
+func TestLoadAttritionRules(t *testing.T) {
+	rules, err := LoadAttritionRules(strings.NewReader(`[{"category": "IC", "rate": 0.005}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := attritionRate(&LineItem{Category: "IC,MCU"}, rules); r != 0.005 {
+		t.Errorf("expected rule to match, got %v", r)
+	}
+	if _, err := LoadAttritionRules(strings.NewReader(`[{"rate": -1}]`)); err == nil {
+		t.Errorf("expected error for negative rate")
+	}
+}
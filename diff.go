@@ -0,0 +1,248 @@
+package main
+
+// Comparison of two Boms, eg successive versions of the same design.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// A LineItem present in both Boms which differs between them. Id is the
+// line's identity in the newer Bom.
+type LineChange struct {
+	Uid       string        `json:"uid,omitempty"`
+	Id        string        `json:"id"`
+	QtyBefore int           `json:"qty_before"`
+	QtyAfter  int           `json:"qty_after"`
+	Fields    []FieldChange `json:"fields"`
+}
+
+// An element (designator) which is on a different LineItem in each Bom.
+type ElementMove struct {
+	Element string `json:"element"`
+	FromId  string `json:"from_id"`
+	ToId    string `json:"to_id"`
+}
+
+type BomDiff struct {
+	FromVersion string        `json:"from_version"`
+	ToVersion   string        `json:"to_version"`
+	Added       []LineItem    `json:"added"`
+	Removed     []LineItem    `json:"removed"`
+	Changed     []LineChange  `json:"changed"`
+	Moved       []ElementMove `json:"moved"`
+}
+
+func (d *BomDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && len(d.Moved) == 0
+}
+
+// Keys used to match up LineItems between several Boms, one list per Bom.
+// Lines are keyed by Uid. Lines without one (from versions saved before Uids
+// existed) take the Uid of a line with the same Id in one of the other Boms,
+// or else are keyed by Id. If a Bom has several lines with the same Id (eg,
+// from a CSV import), later ones get a "#n" suffix so they can still be told
+// apart.
+func lineKeys(boms ...*Bom) [][]string {
+	idUids := make(map[string]string)
+	for _, b := range boms {
+		for i := range b.LineItems {
+			if li := &b.LineItems[i]; li.Uid != "" {
+				idUids[li.Id()] = li.Uid
+			}
+		}
+	}
+	keys := make([][]string, len(boms))
+	for n, b := range boms {
+		keys[n] = make([]string, len(b.LineItems))
+		used := make(map[string]bool)
+		for i := range b.LineItems {
+			if uid := b.LineItems[i].Uid; uid != "" {
+				keys[n][i] = uid
+				used[uid] = true
+			}
+		}
+		seen := make(map[string]int)
+		for i := range b.LineItems {
+			if keys[n][i] != "" {
+				continue
+			}
+			id := b.LineItems[i].Id()
+			if uid, ok := idUids[id]; ok && !used[uid] {
+				keys[n][i] = uid
+				used[uid] = true
+				continue
+			}
+			if m := seen[id]; m > 0 {
+				keys[n][i] = fmt.Sprintf("%s#%d", id, m+1)
+			} else {
+				keys[n][i] = id
+			}
+			seen[id]++
+		}
+	}
+	return keys
+}
+
+// The fields compared between matched LineItems, rendered as strings.
+func lineFields(li *LineItem) [][2]string {
+	alts := make([]string, len(li.Alternates))
+	for i, alt := range li.Alternates {
+		alts[i] = alt.Manufacturer + " " + alt.Mpn
+		if alt.Status != "" {
+			alts[i] += " (" + alt.Status + ")"
+		}
+	}
+	elements := li.ElementIds()
+	sort.Strings(elements)
+	return [][2]string{
+		{"manufacturer", CanonicalManufacturer(li.Manufacturer)},
+		{"mpn", li.Mpn},
+		{"description", li.Description},
+		{"form_factor", li.FormFactor},
+		{"specs", li.Specs},
+		{"comment", li.Comment},
+		{"category", li.Category},
+		{"lifecycle", li.Lifecycle},
+		{"rohs", li.Rohs},
+		{"reach", li.Reach},
+		{"lead_weeks", fmt.Sprint(li.LeadWeeks)},
+		{"tags", strings.Join(li.Tags, ",")},
+		{"elements", strings.TrimSpace(strings.Join(elements, " "))},
+		{"alternates", strings.Join(alts, ", ")},
+	}
+}
+
+func diffLineItems(before, after *LineItem) []FieldChange {
+	changes := []FieldChange{}
+	bf, af := lineFields(before), lineFields(after)
+	for i := range bf {
+		if bf[i][1] != af[i][1] {
+			changes = append(changes, FieldChange{Field: bf[i][0], Before: bf[i][1], After: af[i][1]})
+		}
+	}
+	return changes
+}
+
+// Compares two Boms, matching LineItems by identity.
+func DiffBoms(before, after *Bom) *BomDiff {
+	d := &BomDiff{FromVersion: before.Version,
+		ToVersion: after.Version,
+		Added:     []LineItem{},
+		Removed:   []LineItem{},
+		Changed:   []LineChange{},
+		Moved:     []ElementMove{}}
+	keys := lineKeys(before, after)
+	beforeKeys, afterKeys := keys[0], keys[1]
+	beforeIndex := make(map[string]int)
+	for i, key := range beforeKeys {
+		beforeIndex[key] = i
+	}
+	afterIndex := make(map[string]int)
+	for i, key := range afterKeys {
+		afterIndex[key] = i
+	}
+
+	for i, key := range afterKeys {
+		ali := &after.LineItems[i]
+		j, ok := beforeIndex[key]
+		if !ok {
+			d.Added = append(d.Added, *ali)
+			continue
+		}
+		bli := &before.LineItems[j]
+		fields := diffLineItems(bli, ali)
+		if len(fields) > 0 {
+			d.Changed = append(d.Changed, LineChange{Uid: ali.Uid,
+				Id:        ali.Id(),
+				QtyBefore: len(bli.Elements),
+				QtyAfter:  len(ali.Elements),
+				Fields:    fields})
+		}
+	}
+	for i, key := range beforeKeys {
+		if _, ok := afterIndex[key]; !ok {
+			d.Removed = append(d.Removed, before.LineItems[i])
+		}
+	}
+
+	// designators which switched lines
+	elementLines := make(map[string]int)
+	for i := range before.LineItems {
+		for _, el := range before.LineItems[i].ElementIds() {
+			if el != "" {
+				elementLines[el] = i
+			}
+		}
+	}
+	for i, key := range afterKeys {
+		for _, el := range after.LineItems[i].ElementIds() {
+			if from, ok := elementLines[el]; ok && el != "" && beforeKeys[from] != key {
+				d.Moved = append(d.Moved, ElementMove{Element: el,
+					FromId: before.LineItems[from].Id(),
+					ToId:   after.LineItems[i].Id()})
+			}
+		}
+	}
+	return d
+}
+
+// --------------------- output -----------------------
+
+func DumpDiffAsText(d *BomDiff, out io.Writer) {
+	fmt.Fprintf(out, "Changes from %s to %s:\n", d.FromVersion, d.ToVersion)
+	if d.IsEmpty() {
+		fmt.Fprintln(out, "\t(no changes)")
+		return
+	}
+	tabWriter := tabwriter.NewWriter(out, 2, 4, 1, ' ', 0)
+	for _, li := range d.Removed {
+		fmt.Fprintf(tabWriter, "-\t%d\t%s\t%s\t%s\n",
+			len(li.Elements),
+			li.Manufacturer,
+			li.Mpn,
+			li.Description)
+	}
+	for _, li := range d.Added {
+		fmt.Fprintf(tabWriter, "+\t%d\t%s\t%s\t%s\n",
+			len(li.Elements),
+			li.Manufacturer,
+			li.Mpn,
+			li.Description)
+	}
+	tabWriter.Flush()
+	for _, lc := range d.Changed {
+		fmt.Fprintf(out, "~ %s", lc.Id)
+		if lc.Uid != "" {
+			fmt.Fprintf(out, " [%s]", lc.Uid)
+		}
+		if lc.QtyBefore != lc.QtyAfter {
+			fmt.Fprintf(out, " (qty %d -> %d)", lc.QtyBefore, lc.QtyAfter)
+		}
+		fmt.Fprintln(out)
+		for _, fc := range lc.Fields {
+			fmt.Fprintf(out, "\t%s: %q -> %q\n", fc.Field, fc.Before, fc.After)
+		}
+	}
+	for _, em := range d.Moved {
+		fmt.Fprintf(out, "> %s moved from %s to %s\n", em.Element, em.FromId, em.ToId)
+	}
+}
+
+func DumpDiffAsJSON(d *BomDiff, out io.Writer) {
+	enc := json.NewEncoder(out)
+	if err := enc.Encode(d); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDiffBoms(t *testing.T) {
+	before := NewBom("v001")
+	before.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-10K", Elements: NewElements("R1", "R2")})
+	before.AddLineItem(&LineItem{Manufacturer: "Atmel", Mpn: "ATTINY85", Elements: NewElements("U1")})
+	before.AddLineItem(&LineItem{Manufacturer: "TI", Mpn: "NE555", Elements: NewElements("U2")})
+
+	after := NewBom("v002")
+	after.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-10K", Elements: NewElements("R1")})
+	after.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-4K7", Elements: NewElements("R2")})
+	after.AddLineItem(&LineItem{Manufacturer: "Atmel", Mpn: "ATTINY85", Elements: NewElements("U1"), Comment: "socketed"})
+
+	d := DiffBoms(before, after)
+	if len(d.Added) != 1 || d.Added[0].Mpn != "RC0603-4K7" {
+		t.Errorf("expected one added line, got %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Mpn != "NE555" {
+		t.Errorf("expected one removed line, got %v", d.Removed)
+	}
+	if len(d.Changed) != 2 {
+		t.Fatalf("expected two changed lines, got %v", d.Changed)
+	}
+	if d.Changed[0].QtyBefore != 2 || d.Changed[0].QtyAfter != 1 {
+		t.Errorf("expected quantity change on resistor line")
+	}
+	if d.Changed[1].Fields[0].Field != "comment" {
+		t.Errorf("expected comment change, got %v", d.Changed[1].Fields)
+	}
+	if len(d.Moved) != 1 || d.Moved[0].Element != "R2" {
+		t.Errorf("expected R2 to move, got %v", d.Moved)
+	}
+	if !DiffBoms(before, before).IsEmpty() {
+		t.Errorf("a Bom should have no differences with itself")
+	}
+}
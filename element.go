@@ -0,0 +1,176 @@
+package main
+
+// Circuit elements (designators like "R12") and their assembly details, as
+// found in centroid/pick-and-place files.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A single placed instance of a LineItem's part. Only Id is required; the
+// placement fields are meaningful if Placed is set.
+type Element struct {
+	Id       string  `json:"id" xml:"id,attr"`
+	Placed   bool    `json:"placed,omitempty" xml:"placed,attr,omitempty"`
+	X        float64 `json:"x,omitempty" xml:"x,attr,omitempty"`               // mm
+	Y        float64 `json:"y,omitempty" xml:"y,attr,omitempty"`               // mm
+	Rotation float64 `json:"rotation,omitempty" xml:"rotation,attr,omitempty"` // degrees
+	Side     string  `json:"side,omitempty" xml:"side,attr,omitempty"`         // "top" or "bottom"
+	Dnp      bool    `json:"dnp,omitempty" xml:"dnp,attr,omitempty"`           // do not populate
+	Value    string  `json:"value,omitempty" xml:"value,attr,omitempty"`
+	Note     string  `json:"note,omitempty" xml:",chardata"`
+}
+
+const (
+	SideTop    = "top"
+	SideBottom = "bottom"
+)
+
+func NewElements(ids ...string) []Element {
+	elements := make([]Element, len(ids))
+	for i, id := range ids {
+		elements[i].Id = id
+	}
+	return elements
+}
+
+// Older JSON files list elements as plain designator strings.
+func (el *Element) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*el = Element{}
+		return json.Unmarshal(data, &el.Id)
+	}
+	type plainElement Element
+	return json.Unmarshal(data, (*plainElement)(el))
+}
+
+func elementIds(elements []Element) []string {
+	ids := make([]string, len(elements))
+	for i := range elements {
+		ids[i] = elements[i].Id
+	}
+	return ids
+}
+
+func (li *LineItem) ElementIds() []string {
+	return elementIds(li.Elements)
+}
+
+// Finds an element of the Bom by designator, or returns nil.
+func (b *Bom) GetElement(id string) *Element {
+	for i := range b.LineItems {
+		for j := range b.LineItems[i].Elements {
+			if b.LineItems[i].Elements[j].Id == id {
+				return &b.LineItems[i].Elements[j]
+			}
+		}
+	}
+	return nil
+}
+
+// --------------------- centroid files -----------------------
+
+func parseSide(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "t", "top", "front", "f.cu", "component":
+		return SideTop
+	case "b", "bottom", "back", "b.cu", "bot", "solder":
+		return SideBottom
+	}
+	return ""
+}
+
+// Parses numbers like "12.5", "12.5mm", or "-0.3 mm".
+func parseMillimeters(s string) (float64, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(s)), "mm"))
+	return strconv.ParseFloat(s, 64)
+}
+
+// Reads placement information from a centroid (pick-and-place) file and
+// attaches it to matching elements of the Bom. Both CSV files with a header
+// row and KiCad-style ".pos" files (whitespace seperated, "#" header) work.
+// Returns designators in the file which aren't in the Bom.
+func LoadCentroid(input io.Reader, b *Bom) ([]string, error) {
+	rows := [][]string{}
+	br := bufio.NewReader(input)
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == '#' {
+		scanner := bufio.NewScanner(br)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "# Ref") {
+				rows = append(rows, strings.Fields(strings.TrimPrefix(line, "#")))
+			} else if !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "" {
+				rows = append(rows, strings.Fields(line))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		reader := csv.NewReader(br)
+		reader.TrimLeadingSpace = true
+		reader.FieldsPerRecord = -1
+		if rows, err = reader.ReadAll(); err != nil {
+			return nil, err
+		}
+	}
+	if len(rows) < 1 {
+		return nil, Error("empty centroid file")
+	}
+
+	col := map[string]int{"ref": -1, "x": -1, "y": -1, "rot": -1, "side": -1}
+	for i, name := range rows[0] {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "ref", "refdes", "designator", "reference", "part":
+			col["ref"] = i
+		case "x", "posx", "mid x", "center-x(mm)", "pos x":
+			col["x"] = i
+		case "y", "posy", "mid y", "center-y(mm)", "pos y":
+			col["y"] = i
+		case "rot", "rotation", "angle":
+			col["rot"] = i
+		case "side", "layer", "tb":
+			col["side"] = i
+		}
+	}
+	if col["ref"] < 0 || col["x"] < 0 || col["y"] < 0 {
+		return nil, Error("centroid file needs designator, x, and y columns")
+	}
+
+	unmatched := []string{}
+	for _, row := range rows[1:] {
+		if len(row) <= col["ref"] || len(row) <= col["x"] || len(row) <= col["y"] {
+			continue
+		}
+		el := b.GetElement(strings.TrimSpace(row[col["ref"]]))
+		if el == nil {
+			unmatched = append(unmatched, row[col["ref"]])
+			continue
+		}
+		if el.X, err = parseMillimeters(row[col["x"]]); err != nil {
+			return nil, err
+		}
+		if el.Y, err = parseMillimeters(row[col["y"]]); err != nil {
+			return nil, err
+		}
+		if i := col["rot"]; i >= 0 && i < len(row) {
+			if el.Rotation, err = strconv.ParseFloat(strings.TrimSpace(row[i]), 64); err != nil {
+				return nil, err
+			}
+		}
+		if i := col["side"]; i >= 0 && i < len(row) {
+			el.Side = parseSide(row[i])
+		}
+		el.Placed = true
+	}
+	return unmatched, nil
+}
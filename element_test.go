@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLegacyElementJSON(t *testing.T) {
+	li := LineItem{}
+	if err := json.Unmarshal([]byte(`{"mpn": "NE555", "elements": ["U1", "", {"id": "U2", "dnp": true}]}`), &li); err != nil {
+		t.Fatal(err)
+	}
+	if len(li.Elements) != 3 || li.Elements[0].Id != "U1" || li.Elements[1].Id != "" {
+		t.Errorf("string elements not decoded: %v", li.Elements)
+	}
+	if li.Elements[2].Id != "U2" || !li.Elements[2].Dnp {
+		t.Errorf("object element not decoded: %v", li.Elements[2])
+	}
+}
+
+func TestLoadCentroid(t *testing.T) {
+	csvInput := "Designator,Mid X,Mid Y,Layer,Rotation\nR1,10.5mm,20mm,T,90\nR9,1,1,B,0\n"
+	posInput := "### Module positions\n# Ref Val Package PosX PosY Rot Side\nR1 10k R_0603 10.5 20.0 90.0 top\n## End\n"
+	for _, input := range []string{csvInput, posInput} {
+		b := NewBom("v001")
+		b.AddLineItem(&LineItem{Mpn: "RC0603-10K", Elements: NewElements("R1", "R2")})
+		unmatched, err := LoadCentroid(strings.NewReader(input), b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		el := b.GetElement("R1")
+		if !el.Placed || el.X != 10.5 || el.Y != 20 || el.Rotation != 90 || el.Side != SideTop {
+			t.Errorf("placement not loaded: %v", el)
+		}
+		if b.GetElement("R2").Placed {
+			t.Errorf("R2 should not have been placed")
+		}
+		if input == csvInput && (len(unmatched) != 1 || unmatched[0] != "R9") {
+			t.Errorf("expected R9 to be unmatched, got %v", unmatched)
+		}
+	}
+}
+
+func TestBomXMLDump(t *testing.T) {
+	bm, b := makeTestBom()
+	b.LineItems[0].Elements[0].Placed = true
+	b.LineItems[0].Elements[0].X = 1.5
+	out := new(bytes.Buffer)
+	DumpBomAsXML(bm, b, out)
+	bm2, b2, err := LoadBomFromXML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bm2.Name != bm.Name || len(b2.LineItems) != len(b.LineItems) {
+		t.Errorf("XML round trip lost data")
+	}
+	if el := b2.LineItems[0].Elements[0]; el.Id != "W1" || el.X != 1.5 {
+		t.Errorf("element details lost in XML: %v", el)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+// Forking and copying BOMs between owners, keeping track of where they came
+// from. The usual case is forking a design out of the "common" namespace to
+// modify it.
+
+// Points at a BOM, or one version of it.
+type BomRef struct {
+	Owner   string `json:"owner_name"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+func (ref BomRef) String() string {
+	if ref.Version == "" {
+		return ref.Owner + "/" + ref.Name
+	}
+	return ref.Owner + "/" + ref.Name + "/" + ref.Version
+}
+
+// Whether ref points at (any version of) the BOM user/name.
+func (ref *BomRef) Is(user, name ShortName) bool {
+	return ref != nil && ref.Owner == string(user) && ref.Name == string(name)
+}
+
+// Copies versions of user/name to newOwner/newName using only the generic
+// BomStore methods, so backends can share it. With withHistory every version
+// is copied and the head stays the same; otherwise only the given version (or
+// the head, if version is "") is. Each copied Bom gets a Parent link to the
+// version it was copied from, and the new BomMeta a ForkOf link to the source.
+func forkBom(bs BomStore, user, name, version, newOwner, newName ShortName, withHistory bool) error {
+	bm, err := bs.GetBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	if _, err := bs.GetBomMeta(newOwner, newName); err == nil {
+		return storeError(ErrExists, "bom already exists: "+string(newOwner)+"/"+string(newName), nil)
+	} else if StoreErrorKind(err) != ErrNotFound {
+		return err
+	}
+	if version == "" {
+		version = ShortName(bm.HeadVersion)
+	}
+	versions := []ShortName{version}
+	if withHistory {
+		bvs, err := bs.ListVersions(user, name)
+		if err != nil {
+			return err
+		}
+		versions = versions[:0]
+		for _, bv := range bvs {
+			versions = append(versions, ShortName(bv.Version))
+		}
+	}
+	nbm := &BomMeta{Name: string(newName),
+		Owner:        string(newOwner),
+		Description:  bm.Description,
+		Homepage:     bm.Homepage,
+		IsPublicView: bm.IsPublicView,
+		IsPublicEdit: bm.IsPublicEdit,
+		LintSuppress: bm.LintSuppress,
+		ForkOf:       &BomRef{Owner: bm.Owner, Name: bm.Name, Version: string(version)}}
+	for _, v := range versions {
+		b, err := bs.GetBom(user, name, v)
+		if err != nil {
+			return err
+		}
+		b.Parent = &BomRef{Owner: bm.Owner, Name: bm.Name, Version: string(v)}
+		if err := bs.Persist(nbm, b, v); err != nil {
+			return err
+		}
+	}
+	if withHistory {
+		return bs.SetHead(newOwner, newName, version)
+	}
+	return nil
+}
+
+// Backends which can find forks without reading every BOM.
+type forkLister interface {
+	ListForks(user, name ShortName) ([]BomMeta, error)
+}
+
+// BOMs which were forked or copied from user/name. Archived forks are left
+// out. This reads every BOM unless the backend has an index (see forkLister).
+func ListForks(bs BomStore, user, name ShortName) ([]BomMeta, error) {
+	if fl, ok := bs.(forkLister); ok {
+		return fl.ListForks(user, name)
+	}
+	all, err := bs.ListBoms("", false)
+	if err != nil {
+		return nil, err
+	}
+	forks := []BomMeta{}
+	for _, bm := range all {
+		if bm.ForkOf.Is(user, name) {
+			forks = append(forks, bm)
+		}
+	}
+	return forks, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+// Reverse index of forks for JSONFileBomStore, so the BOM page can list a
+// BOM's forks without reading every _meta.json in the store. Each fork is an
+// empty file, .forks/<owner>.<name>/<fork owner>.<fork name>, so adding and
+// removing entries needs no locking. The _meta.json files stay the truth:
+// entries are checked against them when read, and stale ones are ignored.
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const forkIndexDir = ".forks"
+
+func (jfbs *JSONFileBomStore) forkIndexPath(user, name ShortName) string {
+	// ShortNames can't contain '.', so this is unambiguous (as for .locks)
+	return jfbs.Rootfpath + "/" + forkIndexDir + "/" + string(user) + "." + string(name)
+}
+
+// Builds the index from every BOM in the store if it doesn't exist yet, eg
+// for a store written before there was one.
+func (jfbs *JSONFileBomStore) ensureForkIndex() error {
+	indexPath := jfbs.Rootfpath + "/" + forkIndexDir
+	if _, err := os.Stat(indexPath); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	tmpPath, err := ioutil.TempDir(jfbs.Rootfpath, forkIndexDir+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+	all, err := jfbs.ListBoms("", true)
+	if err != nil {
+		return err
+	}
+	for _, bm := range all {
+		if bm.ForkOf == nil {
+			continue
+		}
+		dir := tmpPath + "/" + bm.ForkOf.Owner + "." + bm.ForkOf.Name
+		if err := os.MkdirAll(dir, os.ModePerm|os.ModeDir); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dir+"/"+bm.Owner+"."+bm.Name, nil, 0666); err != nil {
+			return err
+		}
+	}
+	// if another process got there first, theirs is just as good
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		if _, statErr := os.Stat(indexPath); statErr != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (jfbs *JSONFileBomStore) addForkIndex(source *BomRef, user, name ShortName) error {
+	if err := jfbs.ensureForkIndex(); err != nil {
+		return err
+	}
+	dir := jfbs.forkIndexPath(ShortName(source.Owner), ShortName(source.Name))
+	if err := os.MkdirAll(dir, os.ModePerm|os.ModeDir); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dir+"/"+string(user)+"."+string(name), nil, 0666)
+}
+
+func (jfbs *JSONFileBomStore) removeForkIndex(source *BomRef, user, name ShortName) error {
+	err := os.Remove(jfbs.forkIndexPath(ShortName(source.Owner), ShortName(source.Name)) + "/" + string(user) + "." + string(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Every BOM with a ForkOf pointing at user/name, archived or not.
+func (jfbs *JSONFileBomStore) indexedForks(user, name ShortName) ([]BomMeta, error) {
+	if err := jfbs.ensureForkIndex(); err != nil {
+		return nil, err
+	}
+	entries, err := readDirNames(jfbs.forkIndexPath(user, name))
+	if os.IsNotExist(err) {
+		return []BomMeta{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	forks := []BomMeta{}
+	for _, entry := range entries {
+		parts := strings.Split(entry, ".")
+		if len(parts) != 2 || !isShortName(parts[0]) || !isShortName(parts[1]) {
+			continue
+		}
+		bm, err := jfbs.GetBomMeta(ShortName(parts[0]), ShortName(parts[1]))
+		if StoreErrorKind(err) == ErrNotFound || StoreErrorKind(err) == ErrCorrupt {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if bm.ForkOf.Is(user, name) {
+			forks = append(forks, *bm)
+		}
+	}
+	return forks, nil
+}
+
+func (jfbs *JSONFileBomStore) ListForks(user, name ShortName) ([]BomMeta, error) {
+	if err := checkNames(user, name); err != nil {
+		return nil, err
+	}
+	all, err := jfbs.indexedForks(user, name)
+	if err != nil {
+		return nil, err
+	}
+	forks := []BomMeta{}
+	for _, bm := range all {
+		if !bm.IsArchived {
+			forks = append(forks, bm)
+		}
+	}
+	return forks, nil
+}
@@ -26,14 +26,29 @@ func DumpBomAsText(bm *BomMeta, b *Bom, out io.Writer) {
 	fmt.Fprintln(out)
 	fmt.Fprintf(out, "Name:\t\t%s\n", bm.Name)
 	fmt.Fprintf(out, "Version:\t%s\n", b.Version)
+	if b.Variant != "" {
+		fmt.Fprintf(out, "Variant:\t%s\n", b.Variant)
+	} else if len(b.Variants) > 0 {
+		names := make([]string, len(b.Variants))
+		for i, v := range b.Variants {
+			names[i] = v.Name
+		}
+		fmt.Fprintf(out, "Variants:\t%s\n", strings.Join(names, ", "))
+	}
 	fmt.Fprintf(out, "Creator:\t%s\n", bm.Owner)
 	fmt.Fprintf(out, "Timestamp:\t%s\n", b.Created)
+	if hash := bm.Hashes[b.Version]; hash != "" {
+		fmt.Fprintf(out, "Hash:\t\t%s\n", hash)
+	}
 	if bm.Homepage != "" {
 		fmt.Fprintf(out, "Homepage:\t%s\n", bm.Homepage)
 	}
 	if b.Progeny != "" {
 		fmt.Fprintf(out, "Source:\t\t%s\n", b.Progeny)
 	}
+	if b.Parent != nil {
+		fmt.Fprintf(out, "Forked from:\t%s\n", b.Parent)
+	}
 	if bm.Description != "" {
 		fmt.Fprintf(out, "Description:\t%s\n", bm.Description)
 	}
@@ -44,13 +59,29 @@ func DumpBomAsText(bm *BomMeta, b *Bom, out io.Writer) {
 	for _, li := range b.LineItems {
 		fmt.Fprintf(tabWriter, "%d\t%s\t%s\t%s\t\t%s\t\t%s\n",
 			len(li.Elements),
-			li.Tag,
+			strings.Join(li.Tags, ","),
 			li.Manufacturer,
 			li.Mpn,
 			li.Description,
 			li.Comment)
 	}
 	tabWriter.Flush()
+
+	fmt.Fprintln(out)
+	tabWriter = tabwriter.NewWriter(out, 2, 4, 1, ' ', 0)
+	fmt.Fprintf(tabWriter, "category\tlines\tqty\tcost\tunpriced\n")
+	for _, st := range CategoryBreakdown(b, *currencyName) {
+		if st.Category == "" {
+			st.Category = "(uncategorized)"
+		}
+		fmt.Fprintf(tabWriter, "%s\t%d\t%d\t%s\t%d\n",
+			st.Category,
+			st.Lines,
+			st.Elements,
+			FormatMoney(st.Cost, *currencyName),
+			st.Unpriced)
+	}
+	tabWriter.Flush()
 }
 
 func DumpBomMarketInfo(bm *BomMeta, b *Bom, out io.Writer) {
@@ -75,7 +106,8 @@ func DumpBomAsCSV(b *Bom, out io.Writer) {
 	dumper := csv.NewWriter(out)
 	defer dumper.Flush()
 	// "by line item"
-	dumper.Write([]string{"qty",
+	dumper.Write([]string{"uid",
+		"qty",
 		"elements",
 		"manufacturer",
 		"mpn",
@@ -83,20 +115,51 @@ func DumpBomAsCSV(b *Bom, out io.Writer) {
 		"form_factor",
 		"specs",
 		"category",
-		"tag",
-		"comment"})
+		"tags",
+		"lifecycle",
+		"rohs",
+		"reach",
+		"lead_weeks",
+		"comment",
+		"alt manufacturer",
+		"alt mpn",
+		"alt status",
+		"alt notes"})
 	for _, li := range b.LineItems {
+		altMfgs := make([]string, len(li.Alternates))
+		altMpns := make([]string, len(li.Alternates))
+		altStatuses := make([]string, len(li.Alternates))
+		altNotes := make([]string, len(li.Alternates))
+		for i, alt := range li.Alternates {
+			altMfgs[i] = alt.Manufacturer
+			altMpns[i] = alt.Mpn
+			altStatuses[i] = alt.Status
+			altNotes[i] = alt.Comment
+		}
+		leadWeeks := ""
+		if li.LeadWeeks > 0 {
+			leadWeeks = fmt.Sprint(li.LeadWeeks)
+		}
 		dumper.Write([]string{
+			li.Uid,
 			fmt.Sprint(len(li.Elements)),
-			strings.Join(li.Elements, ","),
+			strings.Join(li.ElementIds(), ","),
 			li.Manufacturer,
 			li.Mpn,
 			li.Description,
 			li.FormFactor,
 			li.Specs,
 			li.Category,
-			li.Tag,
-			li.Comment})
+			strings.Join(li.Tags, ","),
+			li.Lifecycle,
+			li.Rohs,
+			li.Reach,
+			leadWeeks,
+			li.Comment,
+			joinAltList(altMfgs),
+			joinAltList(altMpns),
+			joinAltList(altStatuses),
+			joinAltList(altNotes)})
 	}
 }
 
@@ -108,6 +171,64 @@ func appendField(existing, next *string) {
 	}
 }
 
+// Entries in the "alt" columns are separated by commas, so commas (and
+// backslashes) within an entry are escaped with a backslash.
+func joinAltList(l []string) string {
+	escaped := make([]string, len(l))
+	for i, entry := range l {
+		escaped[i] = strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace(entry)
+	}
+	return strings.Join(escaped, ",")
+}
+
+// Inverse of joinAltList. A backslash before anything other than a comma or
+// backslash is kept as is, so hand-written lists don't need escaping.
+func splitAltList(s string) []string {
+	l := []string{}
+	if strings.TrimSpace(s) == "" {
+		return l
+	}
+	entry := []rune{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == ',' || runes[i+1] == '\\'):
+			i++
+			entry = append(entry, runes[i])
+		case runes[i] == ',':
+			l = append(l, strings.TrimSpace(string(entry)))
+			entry = entry[:0]
+		default:
+			entry = append(entry, runes[i])
+		}
+	}
+	return append(l, strings.TrimSpace(string(entry)))
+}
+
+// Alternates are given as parallel comma separated lists in the "alt"
+// columns; the n-th manufacturer goes with the n-th mpn, status, and note.
+func parseAlternates(mfgs, mpns, statuses, notes string) []Alternate {
+	mfgList, mpnList, statusList, noteList := splitAltList(mfgs), splitAltList(mpns), splitAltList(statuses), splitAltList(notes)
+	alts := []Alternate{}
+	for i, mpn := range mpnList {
+		if mpn == "" {
+			continue
+		}
+		alt := Alternate{Mpn: mpn}
+		if i < len(mfgList) {
+			alt.Manufacturer = mfgList[i]
+		}
+		if i < len(statusList) {
+			alt.Status = strings.ToLower(statusList[i])
+		}
+		if i < len(noteList) {
+			alt.Comment = noteList[i]
+		}
+		alts = append(alts, alt)
+	}
+	return alts
+}
+
 func LoadBomFromCSV(input io.Reader) (*Bom, error) {
 	b := Bom{LineItems: []LineItem{}}
 	reader := csv.NewReader(input)
@@ -123,15 +244,21 @@ func LoadBomFromCSV(input io.Reader) (*Bom, error) {
 	var el_count int
 	var records []string
 	var qty string
+	var altMfgs, altMpns, altStatuses, altNotes string
+	var leadWeeks string
 	for records, err = reader.Read(); err == nil; records, err = reader.Read() {
 		qty = ""
-		li = &LineItem{Elements: []string{}}
+		altMfgs, altMpns, altStatuses, altNotes = "", "", "", ""
+		leadWeeks = ""
+		li = &LineItem{Elements: []Element{}}
 		for i, col := range header {
 			switch strings.ToLower(col) {
 			case "qty", "quantity", "qnty":
 				// if a quantity is specified, use it; else interpret it
 				// from element id count
 				appendField(&qty, &records[i])
+			case "uid", "line uid", "line id":
+				appendField(&li.Uid, &records[i])
 			case "mpn", "manufacturer part number", "part number", "p/n":
 				appendField(&li.Mpn, &records[i])
 			case "mfg", "manufacturer", "mfg name", "manufacturer name":
@@ -140,7 +267,7 @@ func LoadBomFromCSV(input io.Reader) (*Bom, error) {
 				for _, symb := range strings.Split(records[i], ",") {
 					symb = strings.TrimSpace(symb)
 					if !isShortName(symb) {
-						li.Elements = append(li.Elements, symb)
+						li.Elements = append(li.Elements, Element{Id: symb})
 					} else if *verbose {
 						log.Println("element id not a ShortName, skipped: " + symb)
 					}
@@ -155,13 +282,41 @@ func LoadBomFromCSV(input io.Reader) (*Bom, error) {
 				appendField(&li.Comment, &records[i])
 			case "category":
 				appendField(&li.Category, &records[i])
-			case "tag":
-				appendField(&li.Tag, &records[i])
+			case "tag", "tags":
+				li.Tags = ParseTags(strings.Join(li.Tags, ",") + "," + records[i])
+			case "lifecycle", "lifecycle status":
+				appendField(&li.Lifecycle, &records[i])
+			case "rohs", "rohs status":
+				appendField(&li.Rohs, &records[i])
+			case "reach", "reach status":
+				appendField(&li.Reach, &records[i])
+			case "lead_weeks", "lead weeks", "lead time", "lead time (weeks)":
+				appendField(&leadWeeks, &records[i])
+			case "alt mpn", "alt mpns", "alternate mpn", "alt part number":
+				appendField(&altMpns, &records[i])
+			case "alt mfg", "alt manufacturer", "alternate manufacturer":
+				appendField(&altMfgs, &records[i])
+			case "alt status", "alternate status", "alt approval":
+				appendField(&altStatuses, &records[i])
+			case "alt notes", "alt note", "alt comment", "alternate notes":
+				appendField(&altNotes, &records[i])
 			default:
 				// pass, no assignment
 				// TODO: should warn on this first time around?
 			}
 		}
+		li.Lifecycle = NormalizeLifecycle(li.Lifecycle)
+		li.Rohs = NormalizeCompliance(li.Rohs)
+		li.Reach = NormalizeCompliance(li.Reach)
+		if leadWeeks != "" {
+			n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(leadWeeks), "w"), 10, 32)
+			if err != nil {
+				err = Error("lead time not a number of weeks: \"" + leadWeeks + "\"")
+				log.Printf("error parsing .csv: %s", err)
+				return nil, err
+			}
+			li.LeadWeeks = uint32(n)
+		}
 		if qty != "" {
 			if n, err := strconv.Atoi(qty); err == nil && n >= 0 {
 				el_count = len(li.Elements)
@@ -176,14 +331,15 @@ func LoadBomFromCSV(input io.Reader) (*Bom, error) {
 					}
 				} else if el_count < n {
 					for j := 0; j < (n - el_count); j++ {
-						li.Elements = append(li.Elements, "")
+						li.Elements = append(li.Elements, Element{})
 					}
 				}
 			}
 		}
 		if len(li.Elements) == 0 {
-			li.Elements = []string{""}
+			li.Elements = []Element{{}}
 		}
+		li.Alternates = parseAlternates(altMfgs, altMpns, altStatuses, altNotes)
 		b.LineItems = append(b.LineItems, *li)
 	}
 	if err.Error() != "EOF" {
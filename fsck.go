@@ -0,0 +1,319 @@
+package main
+
+// Consistency checking for JSONFileBomStore, which can be edited (and broken)
+// by hand. Anything ListBoms or GetHead would silently skip or choke on is
+// reported; with repair, whatever can be fixed without guessing at content is.
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// One problem found by Fsck. Path is relative to the store's root directory.
+type FsckProblem struct {
+	Path     string `json:"path"`
+	Problem  string `json:"problem"`
+	Repaired bool   `json:"repaired"`
+}
+
+// Files set aside by a repair get this suffix, which no reader looks at.
+const fsckBrokenSuffix = ".broken"
+
+type fsckRun struct {
+	jfbs     *JSONFileBomStore
+	repair   bool
+	problems []FsckProblem
+}
+
+func (f *fsckRun) report(fpath, problem string, repaired bool) {
+	f.problems = append(f.problems, FsckProblem{Path: fpath, Problem: problem, Repaired: repaired})
+}
+
+// Walks the whole store, returning every problem found. With repair:
+//
+//   - owner and name fields of a _meta.json are set to match its path
+//   - a missing or unreadable head is pointed at the newest good version
+//   - hashes recorded for versions which don't exist are dropped
+//   - the version field of a version file is set to match its file name
+//   - unparseable files are renamed aside (with a .broken suffix)
+//   - a missing or unparseable _meta.json is rebuilt from the version files
+//   - temporary files left by interrupted writes are removed
+//   - the fork index is brought in line with the ForkOf links
+//
+// Directory names which aren't ShortNames, failed validations and content
+// hash mismatches are only reported, since fixing them means guessing. Each
+// BOM is locked while it's checked, so running this alongside writers is safe.
+func (jfbs *JSONFileBomStore) Fsck(repair bool) ([]FsckProblem, error) {
+	f := &fsckRun{jfbs: jfbs, repair: repair, problems: []FsckProblem{}}
+	users, err := readDirNames(jfbs.Rootfpath)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user == ".locks" || user == forkIndexDir {
+			continue
+		}
+		if strings.HasPrefix(user, forkIndexDir+".tmp") {
+			f.report(user, "temporary directory left by an interrupted fork index build", f.repair && os.RemoveAll(jfbs.Rootfpath+"/"+user) == nil)
+			continue
+		}
+		if ok, err := f.checkDir(user); !ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		names, err := readDirNames(jfbs.Rootfpath + "/" + user)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if ok, err := f.checkDir(user + "/" + name); !ok {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := f.checkBom(ShortName(user), ShortName(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := f.checkForkIndex(); err != nil {
+		return nil, err
+	}
+	return f.problems, nil
+}
+
+// Compares the fork index (if there is one yet) with the ForkOf links in
+// every _meta.json, which are what it's rebuilt from.
+func (f *fsckRun) checkForkIndex() error {
+	if _, err := os.Stat(f.jfbs.Rootfpath + "/" + forkIndexDir); os.IsNotExist(err) {
+		return nil
+	}
+	all, err := f.jfbs.ListBoms("", true)
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]*BomMeta)
+	for i := range all {
+		if bm := &all[i]; bm.ForkOf != nil {
+			expected[bm.ForkOf.Owner+"."+bm.ForkOf.Name+"/"+bm.Owner+"."+bm.Name] = bm
+		}
+	}
+	sources, err := readDirNames(f.jfbs.Rootfpath + "/" + forkIndexDir)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		entries, err := readDirNames(f.jfbs.Rootfpath + "/" + forkIndexDir + "/" + source)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			fpath := forkIndexDir + "/" + source + "/" + entry
+			if expected[source+"/"+entry] != nil {
+				delete(expected, source+"/"+entry)
+				continue
+			}
+			f.report(fpath, "fork index entry for a BOM which isn't a fork of "+source, f.repair && os.Remove(f.jfbs.Rootfpath+"/"+fpath) == nil)
+		}
+	}
+	missing := []string{}
+	for key := range expected {
+		missing = append(missing, key)
+	}
+	sort.Strings(missing)
+	for _, key := range missing {
+		bm := expected[key]
+		repaired := f.repair && f.jfbs.addForkIndex(bm.ForkOf, ShortName(bm.Owner), ShortName(bm.Name)) == nil
+		f.report(forkIndexDir+"/"+key, "fork missing from the fork index", repaired)
+	}
+	return nil
+}
+
+// Whether dir is a directory with a ShortName, which the store would look in.
+func (f *fsckRun) checkDir(dir string) (bool, error) {
+	fi, err := os.Stat(f.jfbs.Rootfpath + "/" + dir)
+	if err != nil {
+		return false, err
+	}
+	if !fi.IsDir() {
+		f.report(dir, "unexpected file", false)
+		return false, nil
+	}
+	if !isShortName(path.Base(dir)) {
+		f.report(dir, "directory name is not a ShortName, so it is never listed", false)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (f *fsckRun) checkBom(user, name ShortName) error {
+	unlock, err := f.jfbs.lockBom(user, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	dir := string(user) + "/" + string(name)
+	dirPath := f.jfbs.bomPath(user, name)
+	fnames, err := readDirNames(dirPath)
+	if err != nil {
+		return err
+	}
+
+	hasMeta, hasFiles := false, false
+	good := make(map[string]*Bom)
+	goodNames := []string{}
+	for _, fname := range fnames {
+		fpath := dir + "/" + fname
+		version := strings.TrimSuffix(fname, ".json")
+		switch {
+		case fname == "_meta.json":
+			hasMeta = true
+		case strings.HasSuffix(fname, fsckBrokenSuffix):
+			// set aside by an earlier repair
+		case strings.HasPrefix(fname, ".") && strings.Contains(fname, ".tmp"):
+			repaired := f.repair && os.Remove(dirPath+"/"+fname) == nil
+			f.report(fpath, "temporary file left by an interrupted write", repaired)
+		case version != fname && isShortName(version):
+			hasFiles = true
+			if b := f.checkVersion(fpath, version); b != nil {
+				good[version] = b
+				goodNames = append(goodNames, version)
+			}
+		default:
+			f.report(fpath, "unexpected file", false)
+		}
+	}
+
+	bm := &BomMeta{}
+	metaPath := dir + "/_meta.json"
+	if !hasMeta {
+		if !hasFiles {
+			f.report(dir, "empty directory", f.repair && os.Remove(dirPath) == nil)
+			return nil
+		}
+		f.report(metaPath, "version files with no _meta.json", f.rebuildMeta(user, name, good))
+		return nil
+	}
+	if err := readJsonBomMeta(f.jfbs.Rootfpath+"/"+metaPath, bm); err != nil {
+		if StoreErrorKind(err) != ErrCorrupt {
+			return err
+		}
+		repaired := f.repair && len(good) > 0 && f.setAside(metaPath) && f.rebuildMeta(user, name, good)
+		f.report(metaPath, "unparseable JSON: "+err.Error(), repaired)
+		return nil
+	}
+
+	// problems fixed by rewriting the _meta.json, which are only repaired
+	// once that's done
+	fixed := []int{}
+	fix := func(problem string) {
+		fixed = append(fixed, len(f.problems))
+		f.report(metaPath, problem, false)
+	}
+	if bm.Owner != string(user) || bm.Name != string(name) {
+		fix("owner/name fields say " + bm.Owner + "/" + bm.Name)
+		bm.Owner, bm.Name = string(user), string(name)
+	}
+	hashed := []string{}
+	for v := range bm.Hashes {
+		hashed = append(hashed, v)
+	}
+	sort.Strings(hashed)
+	for _, v := range hashed {
+		if _, err := os.Stat(dirPath + "/" + v + ".json"); os.IsNotExist(err) {
+			fix("hash recorded for missing version " + v)
+			delete(bm.Hashes, v)
+		}
+	}
+	if _, ok := good[bm.HeadVersion]; !ok {
+		problem := "head version \"" + bm.HeadVersion + "\" has no usable file"
+		if head := newestVersion(bm, good); head != "" {
+			fix(problem + "; newest good version is " + head)
+			bm.HeadVersion = head
+		} else {
+			f.report(metaPath, problem+"; no good versions to use instead", false)
+		}
+	}
+	for _, v := range goodNames {
+		if err := VerifyBom(bm, good[v]); err != nil {
+			f.report(dir+"/"+v+".json", err.Error(), false)
+		}
+	}
+	if len(fixed) > 0 && f.repair && writeJsonBomMeta(f.jfbs.Rootfpath+"/"+metaPath, bm) == nil {
+		for _, i := range fixed {
+			f.problems[i].Repaired = true
+		}
+	}
+	return nil
+}
+
+// Reads one version file, reporting (and perhaps repairing) what's wrong with
+// it. Returns the Bom if it's readable, after any repair.
+func (f *fsckRun) checkVersion(fpath, version string) *Bom {
+	b := &Bom{}
+	if err := readJsonBom(f.jfbs.Rootfpath+"/"+fpath, b); err != nil {
+		f.report(fpath, "unparseable JSON: "+err.Error(), f.repair && f.setAside(fpath))
+		return nil
+	}
+	if b.Version != version {
+		b.Version = version
+		repaired := f.repair && writeJsonBom(f.jfbs.Rootfpath+"/"+fpath, b) == nil
+		f.report(fpath, "version field doesn't match the file name", repaired)
+		if !repaired {
+			return nil
+		}
+	}
+	if err := b.Validate(); err != nil {
+		// still readable, so it can stay the head
+		f.report(fpath, "fails validation: "+err.Error(), false)
+	}
+	return b
+}
+
+func (f *fsckRun) setAside(fpath string) bool {
+	full := f.jfbs.Rootfpath + "/" + fpath
+	return os.Rename(full, full+fsckBrokenSuffix) == nil
+}
+
+// Writes a new _meta.json from the usable versions, with their current
+// hashes and the newest as head. Returns whether it did.
+func (f *fsckRun) rebuildMeta(user, name ShortName, good map[string]*Bom) bool {
+	if !f.repair || len(good) == 0 {
+		return false
+	}
+	bm := &BomMeta{Owner: string(user), Name: string(name), Hashes: make(map[string]string)}
+	for v, b := range good {
+		bm.Hashes[v] = b.Hash()
+	}
+	bm.HeadVersion = newestVersion(bm, good)
+	return writeJsonBomMeta(f.jfbs.bomPath(user, name)+"/_meta.json", bm) == nil
+}
+
+// The most recently created of the given versions, or "" if there are none.
+func newestVersion(bm *BomMeta, boms map[string]*Bom) string {
+	versions := []BomVersion{}
+	for _, b := range boms {
+		versions = append(versions, newBomVersion(bm, b))
+	}
+	if len(versions) == 0 {
+		return ""
+	}
+	sortBomVersions(versions)
+	return versions[len(versions)-1].Version
+}
+
+func readDirNames(dirPath string) ([]string, error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(0)
+	sort.Strings(names)
+	return names, err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func findFsckProblem(problems []FsckProblem, fpath, contains string) *FsckProblem {
+	for i := range problems {
+		if problems[i].Path == fpath && strings.Contains(problems[i].Problem, contains) {
+			return &problems[i]
+		}
+	}
+	return nil
+}
+
+func TestFsck(t *testing.T) {
+	jfbs, dir, cleanup := openTestJSONStore(t)
+	defer cleanup()
+
+	for _, name := range []string{"good", "nometa", "badmeta", "moved", "headless", "wrongver", "tampered"} {
+		bm, b := makeTestBom()
+		bm.Owner, bm.Name = "tester", name
+		for _, v := range []string{"v1", "v2"} {
+			if err := jfbs.Persist(bm, b, ShortName(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write := func(fpath, content string) {
+		if err := ioutil.WriteFile(dir+"/"+fpath, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Remove(dir + "/tester/nometa/_meta.json")
+	write("tester/badmeta/_meta.json", "{not json")
+	meta, _ := ioutil.ReadFile(dir + "/tester/moved/_meta.json")
+	write("tester/moved/_meta.json", strings.Replace(string(meta), `"owner_name":"tester"`, `"owner_name":"someone"`, 1))
+	write("tester/headless/v2.json", "{oops")
+	v1, _ := ioutil.ReadFile(dir + "/tester/wrongver/v1.json")
+	write("tester/wrongver/v1.json", strings.Replace(string(v1), `"version":"v1"`, `"version":"v9"`, 1))
+	v2, _ := ioutil.ReadFile(dir + "/tester/tampered/v2.json")
+	write("tester/tampered/v2.json", strings.Replace(string(v2), "WIDG0001", "WIDG0002", 1))
+	write("tester/good/.v3.json.tmp123", `{"version": "v3", "line_it`)
+	os.MkdirAll(dir+"/tester/Not_Short", os.ModePerm)
+
+	if list, _ := jfbs.ListBoms("tester", true); len(list) != 5 {
+		t.Fatalf("expected the broken boms to be skipped: %d", len(list))
+	}
+
+	expected := []struct {
+		path, problem string
+		repairable    bool
+	}{
+		{"tester/Not_Short", "not a ShortName", false},
+		{"tester/nometa/_meta.json", "no _meta.json", true},
+		{"tester/badmeta/_meta.json", "unparseable", true},
+		{"tester/moved/_meta.json", "owner/name", true},
+		{"tester/headless/v2.json", "unparseable", true},
+		{"tester/headless/_meta.json", "head version \"v2\"", true},
+		{"tester/wrongver/v1.json", "version field", true},
+		{"tester/tampered/v2.json", "hash mismatch", false},
+		{"tester/good/.v3.json.tmp123", "temporary file", true},
+	}
+	problems, err := jfbs.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range expected {
+		if p := findFsckProblem(problems, e.path, e.problem); p == nil || p.Repaired {
+			t.Errorf("%s: expected an unrepaired %q problem in %+v", e.path, e.problem, problems)
+		}
+	}
+	if findFsckProblem(problems, "tester/good/_meta.json", "") != nil {
+		t.Errorf("good bom reported: %+v", problems)
+	}
+	if list, _ := jfbs.ListBoms("tester", true); len(list) != 5 {
+		t.Errorf("checking without -repair changed the store")
+	}
+
+	problems, err = jfbs.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range expected {
+		if p := findFsckProblem(problems, e.path, e.problem); p == nil || p.Repaired != e.repairable {
+			t.Errorf("%s: expected %q problem with repaired=%v in %+v", e.path, e.problem, e.repairable, problems)
+		}
+	}
+
+	// only what can't be fixed is left, and everything fixed is readable
+	problems, _ = jfbs.Fsck(false)
+	if len(problems) != 2 {
+		t.Errorf("expected 2 problems left after repair: %+v", problems)
+	}
+	if list, _ := jfbs.ListBoms("tester", true); len(list) != 7 {
+		t.Errorf("expected all boms listed after repair: %+v", list)
+	}
+	for _, name := range []ShortName{"good", "nometa", "badmeta", "moved", "headless", "wrongver"} {
+		bm, b, err := jfbs.GetHead("tester", name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if bm.Owner != "tester" || bm.Name != string(name) {
+			t.Errorf("%s: unexpected meta %+v", name, bm)
+		}
+		if name == "headless" && (b.Version != "v1" || len(bm.Hashes) != 1) {
+			t.Errorf("headless: expected head v1 and one hash: %s %v", b.Version, bm.Hashes)
+		}
+	}
+	if _, err := os.Stat(dir + "/tester/headless/v2.json" + fsckBrokenSuffix); err != nil {
+		t.Errorf("unparseable version should be set aside: %v", err)
+	}
+}
+
+// A repair is only reported once it's been written.
+func TestFsckFailedRepair(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("needs a directory which can't be written to")
+	}
+	jfbs, dir, cleanup := openTestJSONStore(t)
+	defer cleanup()
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	mustStore(t, jfbs.Persist(bm, b, "v1"))
+	bm.Owner = "someone"
+	mustStore(t, writeJsonBomMeta(dir+"/tester/widget/_meta.json", bm))
+	os.Chmod(dir+"/tester/widget", 0555)
+	defer os.Chmod(dir+"/tester/widget", 0755)
+
+	problems, err := jfbs.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := findFsckProblem(problems, "tester/widget/_meta.json", "owner/name"); p == nil || p.Repaired {
+		t.Errorf("expected an unrepaired owner/name problem: %+v", problems)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+// BomStore backend on a git repository, driven through the git command line
+// so it works with any local repository (bare or not) and never needs the
+// network. It can share a repository with the hardware sources, since it only
+// uses its own refs and never touches the working tree:
+//
+//	refs/bommom/<user>/<name>               one branch per BOM
+//	refs/tags/bommom/<user>/<name>/<ver>    the commit which added each version
+//
+// Every commit on a BOM's branch has a tree with _meta.json (the BomMeta) and
+// bom.json (the head version at that point). PersistAs adds a commit authored
+// by the user who uploaded the version (Persist: the BOM's owner) and tags
+// it; reverting, archiving and deleting versions are commits by the owner
+// too, so "git log" of the branch is the BOM's full history.
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Commits say which version they added in a trailer like this.
+const gitVersionTrailer = "Bommom-Version"
+
+type GitBomStore struct {
+	gitDir string
+}
+
+// Opens the git repository at fpath as a BomStore, or creates a bare one if
+// nothing exists there yet.
+func OpenGitBomStore(fpath string) (*GitBomStore, error) {
+	if _, err := os.Stat(fpath); os.IsNotExist(err) {
+		if out, err := exec.Command("git", "init", "--bare", "-q", fpath).CombinedOutput(); err != nil {
+			return nil, Error("git init failed: " + strings.TrimSpace(string(out)))
+		}
+	}
+	out, err := exec.Command("git", "-C", fpath, "rev-parse", "--absolute-git-dir").CombinedOutput()
+	if err != nil {
+		return nil, Error("not a git repository: " + fpath + ": " + strings.TrimSpace(string(out)))
+	}
+	return &GitBomStore{gitDir: strings.TrimSpace(string(out))}, nil
+}
+
+// Runs a git command against the repository, returning its standard output.
+func (g *GitBomStore) git(stdin []byte, env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"--git-dir", g.gitDir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, Error("git " + args[0] + " failed: " + strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func gitBranch(user, name ShortName) string {
+	return "refs/bommom/" + string(user) + "/" + string(name)
+}
+
+func gitTag(user, name, version ShortName) string {
+	return "refs/tags/bommom/" + string(user) + "/" + string(name) + "/" + string(version)
+}
+
+// The commit a ref points at, or "" if it doesn't exist.
+func (g *GitBomStore) resolve(ref string) (string, error) {
+	out, err := g.git(nil, nil, "for-each-ref", "--format=%(objectname)", ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (g *GitBomStore) readJson(commit, fname string, v interface{}) error {
+	out, err := g.git(nil, nil, "cat-file", "blob", commit+":"+fname)
+	if err != nil {
+		return storeError(ErrCorrupt, "couldn't read "+fname+" from commit "+commit, err)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return storeError(ErrCorrupt, "couldn't parse "+fname+" from commit "+commit, err)
+	}
+	return nil
+}
+
+// The tip of a BOM's branch and the BomMeta in it.
+func (g *GitBomStore) tip(user, name ShortName) (string, *BomMeta, error) {
+	if err := checkNames(user, name); err != nil {
+		return "", nil, err
+	}
+	commit, err := g.resolve(gitBranch(user, name))
+	if err != nil {
+		return "", nil, err
+	}
+	if commit == "" {
+		return "", nil, storeError(ErrNotFound, "no such bom: "+string(user)+"/"+string(name), nil)
+	}
+	bm := &BomMeta{}
+	if err := g.readJson(commit, "_meta.json", bm); err != nil {
+		return "", nil, err
+	}
+	return commit, bm, nil
+}
+
+// Makes a commit on top of parent (which may be "") with the given meta and
+// bom.json contents, authored by author (or if that's "", the BOM's owner) at
+// the given time.
+func (g *GitBomStore) commit(parent string, bm *BomMeta, bomJson []byte, author ShortName, when time.Time, msg string) (string, error) {
+	metaJson, err := json.Marshal(bm)
+	if err != nil {
+		return "", err
+	}
+	metaBlob, err := g.git(metaJson, nil, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	bomBlob, err := g.git(bomJson, nil, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	treeSpec := "100644 blob " + strings.TrimSpace(string(metaBlob)) + "\t_meta.json\n" +
+		"100644 blob " + strings.TrimSpace(string(bomBlob)) + "\tbom.json\n"
+	tree, err := g.git([]byte(treeSpec), nil, "mktree")
+	if err != nil {
+		return "", err
+	}
+	args := []string{"commit-tree", strings.TrimSpace(string(tree)), "-m", msg}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	if author == "" {
+		author = ShortName(bm.Owner)
+	}
+	date := when.UTC().Format(time.RFC3339)
+	env := []string{"GIT_AUTHOR_NAME=" + string(author),
+		"GIT_AUTHOR_EMAIL=" + string(author) + "@localhost",
+		"GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=bommom",
+		"GIT_COMMITTER_EMAIL=bommom@localhost",
+		"GIT_COMMITTER_DATE=" + date}
+	out, err := g.git(nil, env, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Applies "git update-ref --stdin" instructions as one atomic transaction,
+// provided branch is still at parent. Returns false (and no error) if
+// another writer moved it first, so the caller should start over.
+func (g *GitBomStore) updateRefs(branch, parent, instructions string) (bool, error) {
+	_, err := g.git([]byte("start\n"+instructions+"prepare\ncommit\n"), nil, "update-ref", "--stdin")
+	if err != nil {
+		if current, rerr := g.resolve(branch); rerr == nil && current != parent {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Rewrites the meta (and bom.json) of a BOM with a new commit, retrying if
+// somebody else commits in between. change gets the current meta, and returns
+// the bom.json to use (nil to keep the current one) and a commit message.
+// extra, if given, adds more ref updates to the same transaction.
+func (g *GitBomStore) changeMeta(user, name ShortName, extra func(commit string) string,
+	change func(bm *BomMeta) (bomJson []byte, msg string, err error)) error {
+	for {
+		parent, bm, err := g.tip(user, name)
+		if err != nil {
+			return err
+		}
+		bomJson, msg, err := change(bm)
+		if err != nil {
+			return err
+		}
+		if bomJson == nil {
+			if bomJson, err = g.git(nil, nil, "cat-file", "blob", parent+":bom.json"); err != nil {
+				return err
+			}
+		}
+		commit, err := g.commit(parent, bm, bomJson, "", time.Now(), msg)
+		if err != nil {
+			return err
+		}
+		instructions := "update " + gitBranch(user, name) + " " + commit + " " + parent + "\n"
+		if extra != nil {
+			instructions += extra(commit)
+		}
+		if ok, err := g.updateRefs(gitBranch(user, name), parent, instructions); err != nil || ok {
+			return err
+		}
+	}
+}
+
+func (g *GitBomStore) GetBomMeta(user, name ShortName) (*BomMeta, error) {
+	_, bm, err := g.tip(user, name)
+	return bm, err
+}
+
+func (g *GitBomStore) GetHead(user, name ShortName) (*BomMeta, *Bom, error) {
+	bm, err := g.GetBomMeta(user, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bm.HeadVersion == "" {
+		return nil, nil, storeError(ErrCorrupt, "no head version for "+string(user)+"/"+string(name), nil)
+	}
+	b, err := g.GetBom(user, name, ShortName(bm.HeadVersion))
+	return bm, b, err
+}
+
+func (g *GitBomStore) GetBom(user, name, version ShortName) (*Bom, error) {
+	if err := checkNames(user, name, version); err != nil {
+		return nil, err
+	}
+	_, bm, err := g.tip(user, name)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.resolve(gitTag(user, name, version))
+	if err != nil {
+		return nil, err
+	}
+	if commit == "" {
+		return nil, storeError(ErrNotFound, "no such version: "+string(version), nil)
+	}
+	b := Bom{}
+	if err := g.readJson(commit, "bom.json", &b); err != nil {
+		return nil, err
+	}
+	if b.Version != string(version) {
+		return nil, storeError(ErrCorrupt, "tag for "+string(version)+" has version "+b.Version, nil)
+	}
+	if err := VerifyBom(bm, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (g *GitBomStore) ListBoms(user ShortName, includeArchived bool) ([]BomMeta, error) {
+	prefix := "refs/bommom/"
+	if user != "" {
+		if err := checkNames(user); err != nil {
+			return nil, err
+		}
+		prefix += string(user) + "/"
+	}
+	out, err := g.git(nil, nil, "for-each-ref", "--format=%(refname)", prefix)
+	if err != nil {
+		return nil, err
+	}
+	bmList := []BomMeta{}
+	for _, ref := range strings.Fields(string(out)) {
+		parts := strings.Split(strings.TrimPrefix(ref, "refs/bommom/"), "/")
+		if len(parts) != 2 {
+			continue
+		}
+		_, bm, err := g.tip(ShortName(parts[0]), ShortName(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		if bm.IsArchived && !includeArchived {
+			continue
+		}
+		bmList = append(bmList, *bm)
+	}
+	return bmList, nil
+}
+
+// Walks the log of the BOM's branch for commits which added a version that is
+// still tagged there.
+func (g *GitBomStore) ListVersions(user, name ShortName) ([]BomVersion, error) {
+	_, bm, err := g.tip(user, name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.git(nil, nil, "log", "--format=%H %(trailers:key="+gitVersionTrailer+",valueonly,separator=)",
+		gitBranch(user, name))
+	if err != nil {
+		return nil, err
+	}
+	versions := []BomVersion{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		commit, version := fields[0], fields[1]
+		if tagged, err := g.resolve(gitTag(user, name, ShortName(version))); err != nil {
+			return nil, err
+		} else if tagged != commit {
+			// deleted since
+			continue
+		}
+		b := Bom{}
+		if err := g.readJson(commit, "bom.json", &b); err != nil {
+			return nil, err
+		}
+		b.Version = version
+		versions = append(versions, newBomVersion(bm, &b))
+	}
+	sortBomVersions(versions)
+	return versions, nil
+}
+
+func (g *GitBomStore) Persist(bm *BomMeta, b *Bom, version ShortName) error {
+	return g.PersistAs("", bm, b, version)
+}
+
+// Persist, with author as the commit's author.
+func (g *GitBomStore) PersistAs(author ShortName, bm *BomMeta, b *Bom, version ShortName) error {
+	if author != "" {
+		if err := checkNames(author); err != nil {
+			return err
+		}
+	}
+	b.Version = string(version)
+	bm.HeadVersion = string(version)
+	if err := bm.Validate(); err != nil {
+		return storeError(ErrInvalid, "invalid bom meta", err)
+	}
+	if err := b.Validate(); err != nil {
+		return storeError(ErrInvalid, "invalid bom", err)
+	}
+	user, name := ShortName(bm.Owner), ShortName(bm.Name)
+	bomJson, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	tag := gitTag(user, name, version)
+	for {
+		parent, old, err := g.tip(user, name)
+		if err != nil && StoreErrorKind(err) != ErrNotFound {
+			return err
+		}
+		if existing, err := g.resolve(tag); err != nil {
+			return err
+		} else if existing != "" {
+			return storeError(ErrExists, "bom with same owner, name, and version already exists", nil)
+		}
+		hashes := make(map[string]string)
+		if old != nil {
+			for v, h := range old.Hashes {
+				hashes[v] = h
+			}
+		}
+		for v, h := range bm.Hashes {
+			hashes[v] = h
+		}
+		hashes[string(version)] = b.Hash()
+		bm.Hashes = hashes
+		msg := "Add version " + string(version) + " of " + string(user) + "/" + string(name)
+		if b.Progeny != "" {
+			msg += "\n\n" + b.Progeny
+		}
+		msg += "\n\n" + gitVersionTrailer + ": " + string(version) + "\n"
+		commit, err := g.commit(parent, bm, bomJson, author, b.Created, msg)
+		if err != nil {
+			return err
+		}
+		// the tag must be new, and the branch where we left it
+		instructions := "create " + tag + " " + commit + "\n"
+		if parent == "" {
+			instructions += "create " + gitBranch(user, name) + " " + commit + "\n"
+		} else {
+			instructions += "update " + gitBranch(user, name) + " " + commit + " " + parent + "\n"
+		}
+		if ok, err := g.updateRefs(gitBranch(user, name), parent, instructions); err != nil || ok {
+			return err
+		}
+	}
+}
+
+func (g *GitBomStore) DeleteVersion(user, name, version ShortName) error {
+	if err := checkNames(user, name, version); err != nil {
+		return err
+	}
+	tag := gitTag(user, name, version)
+	var tagged string
+	return g.changeMeta(user, name, func(commit string) string {
+		return "delete " + tag + " " + tagged + "\n"
+	}, func(bm *BomMeta) ([]byte, string, error) {
+		if string(version) == bm.HeadVersion {
+			return nil, "", storeError(ErrInvalid, "can't delete the head version ("+bm.HeadVersion+"); set a different head first", nil)
+		}
+		var err error
+		if tagged, err = g.resolve(tag); err != nil {
+			return nil, "", err
+		} else if tagged == "" {
+			return nil, "", storeError(ErrNotFound, "no such version: "+string(version), nil)
+		}
+		delete(bm.Hashes, string(version))
+		return nil, "Delete version " + string(version), nil
+	})
+}
+
+func (g *GitBomStore) SetArchived(user, name ShortName, archived bool) error {
+	return g.changeMeta(user, name, nil, func(bm *BomMeta) ([]byte, string, error) {
+		bm.IsArchived = archived
+		if archived {
+			return nil, "Archive " + string(user) + "/" + string(name), nil
+		}
+		return nil, "Unarchive " + string(user) + "/" + string(name), nil
+	})
+}
+
+func (g *GitBomStore) SetLintSuppress(user, name ShortName, suppress []string) error {
+	return g.changeMeta(user, name, nil, func(bm *BomMeta) ([]byte, string, error) {
+		bm.LintSuppress = suppress
+		return nil, "Set lint suppressions of " + string(user) + "/" + string(name), nil
+	})
+}
+
+func (g *GitBomStore) SetHead(user, name, version ShortName) error {
+	return g.changeMeta(user, name, nil, func(bm *BomMeta) ([]byte, string, error) {
+		// also checks that the version exists and is intact
+		b, err := g.GetBom(user, name, version)
+		if err != nil {
+			return nil, "", err
+		}
+		bomJson, err := json.Marshal(b)
+		if err != nil {
+			return nil, "", err
+		}
+		bm.HeadVersion = string(version)
+		return bomJson, "Set head to " + string(version), nil
+	})
+}
+
+func (g *GitBomStore) Fork(user, name, version, newOwner, newName ShortName) error {
+	return forkBom(g, user, name, version, newOwner, newName, false)
+}
+
+func (g *GitBomStore) CopyWithHistory(user, name, newOwner, newName ShortName) error {
+	return forkBom(g, user, name, "", newOwner, newName, true)
+}
+
+// Moves the branch and tags to the new owner in one transaction, with a
+// commit recording the change.
+func (g *GitBomStore) TransferOwnership(user, name, newOwner ShortName) error {
+	if err := checkNames(user, name, newOwner); err != nil {
+		return err
+	}
+	for {
+		parent, bm, err := g.tip(user, name)
+		if err != nil {
+			return err
+		}
+		if _, _, err := g.tip(newOwner, name); err == nil {
+			return storeError(ErrExists, "bom already exists: "+string(newOwner)+"/"+string(name), nil)
+		} else if StoreErrorKind(err) != ErrNotFound {
+			return err
+		}
+		bomJson, err := g.git(nil, nil, "cat-file", "blob", parent+":bom.json")
+		if err != nil {
+			return err
+		}
+		bm.Owner = string(newOwner)
+		commit, err := g.commit(parent, bm, bomJson, "", time.Now(),
+			"Transfer "+string(user)+"/"+string(name)+" to "+string(newOwner))
+		if err != nil {
+			return err
+		}
+		instructions := "delete " + gitBranch(user, name) + " " + parent + "\n" +
+			"create " + gitBranch(newOwner, name) + " " + commit + "\n"
+		out, err := g.git(nil, nil, "for-each-ref", "--format=%(refname) %(objectname)",
+			"refs/tags/bommom/"+string(user)+"/"+string(name)+"/")
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			version := fields[0][strings.LastIndex(fields[0], "/")+1:]
+			instructions += "delete " + fields[0] + " " + fields[1] + "\n" +
+				"create " + gitTag(newOwner, name, ShortName(version)) + " " + fields[1] + "\n"
+		}
+		ok, err := g.updateRefs(gitBranch(user, name), parent, instructions)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+	}
+	// keep forks pointing at the right place
+	all, err := g.ListBoms("", true)
+	if err != nil {
+		return err
+	}
+	for _, fork := range all {
+		if !fork.ForkOf.Is(user, name) {
+			continue
+		}
+		err := g.changeMeta(ShortName(fork.Owner), ShortName(fork.Name), nil, func(bm *BomMeta) ([]byte, string, error) {
+			if bm.ForkOf != nil {
+				bm.ForkOf.Owner = string(newOwner)
+			}
+			return nil, "Follow transfer of " + string(user) + "/" + string(name) + " to " + string(newOwner), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestGitStore(t *testing.T) (*GitBomStore, string, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "bommom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := OpenGitBomStore(dir + "/boms.git")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return g, dir, func() { os.RemoveAll(dir) }
+}
+
+func TestGitStoreHistory(t *testing.T) {
+	g, _, cleanup := openTestGitStore(t)
+	defer cleanup()
+
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	start := time.Now().Truncate(time.Second)
+	for i, v := range []string{"v1", "v2", "v3"} {
+		b.Created = start.Add(time.Duration(i) * time.Minute)
+		b.Progeny = "step " + v
+		if err := g.Persist(bm, b, ShortName(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Persist(bm, b, "v2"); StoreErrorKind(err) != ErrExists {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+
+	// each version is a commit by the owner, tagged with the version
+	out, err := g.git(nil, nil, "log", "--format=%an %s", gitBranch("tester", "widget"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 3 || lines[0] != "tester Add version v3 of tester/widget" {
+		t.Errorf("unexpected log: %q", out)
+	}
+	// or by whoever uploaded it, when the caller says
+	if err := PersistAs(g, "alice", bm, b, "v4"); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := g.git(nil, nil, "log", "-1", "--format=%an <%ae>", gitBranch("tester", "widget")); strings.TrimSpace(string(out)) != "alice <alice@localhost>" {
+		t.Errorf("expected alice as the author: %q", out)
+	}
+	mustStore(t, g.SetHead("tester", "widget", "v3"))
+	mustStore(t, g.DeleteVersion("tester", "widget", "v4"))
+	if tagged, _ := g.resolve("refs/tags/bommom/tester/widget/v1"); tagged == "" {
+		t.Errorf("missing tag for v1")
+	}
+
+	versions, err := g.ListVersions("tester", "widget")
+	if err != nil || len(versions) != 3 || versions[0].Version != "v1" || versions[2].Progeny != "step v3" {
+		t.Fatalf("unexpected versions: %+v %v", versions, err)
+	}
+	if b1, err := g.GetBom("tester", "widget", "v1"); err != nil || !b1.Created.Equal(start) {
+		t.Errorf("unexpected v1: %v", err)
+	}
+
+	if err := g.DeleteVersion("tester", "widget", "v3"); StoreErrorKind(err) != ErrInvalid {
+		t.Errorf("expected ErrInvalid deleting head, got %v", err)
+	}
+	if err := g.SetHead("tester", "widget", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.DeleteVersion("tester", "widget", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := g.ListVersions("tester", "widget"); len(versions) != 2 {
+		t.Errorf("expected 2 versions after delete: %+v", versions)
+	}
+	if _, head, err := g.GetHead("tester", "widget"); err != nil || head.Version != "v1" {
+		t.Errorf("unexpected head after revert: %v", err)
+	}
+
+	if err := g.TransferOwnership("tester", "widget", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := g.ListVersions("other", "widget"); len(versions) != 2 {
+		t.Errorf("versions didn't move with the bom: %+v", versions)
+	}
+	if list, _ := g.ListBoms("", false); len(list) != 1 || list[0].Owner != "other" {
+		t.Errorf("unexpected listing after transfer: %+v", list)
+	}
+}
+
+// The store only uses its own refs, so it can live in a repository with a
+// checked out working tree without disturbing it.
+func TestGitStoreExistingRepo(t *testing.T) {
+	_, dir, cleanup := openTestGitStore(t)
+	defer cleanup()
+	repo := dir + "/hardware"
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Fatal(string(out))
+	}
+	g, err := OpenGitBomStore(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	if err := g.Persist(bm, b, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := exec.Command("git", "-C", repo, "status", "--porcelain").CombinedOutput(); len(out) != 0 {
+		t.Errorf("working tree was touched: %s", out)
+	}
+	if _, _, err := g.GetHead("tester", "widget"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGitStoreConcurrentPersist(t *testing.T) {
+	g, _, cleanup := openTestGitStore(t)
+	defer cleanup()
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bm, b := makeTestBom()
+			bm.Owner, bm.Name = "tester", "widget"
+			errs <- g.Persist(bm, b, ShortName("v"+string(rune('a'+i))))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	versions, err := g.ListVersions("tester", "widget")
+	if err != nil || len(versions) != writers {
+		t.Errorf("expected %d versions: %d %v", writers, len(versions), err)
+	}
+	bm, err := g.GetBomMeta("tester", "widget")
+	if err != nil || len(bm.Hashes) != writers {
+		t.Errorf("expected %d hashes: %v", writers, err)
+	}
+}
+
+func TestGitStoreConformance(t *testing.T) {
+	testBomStore(t, func(t *testing.T) (BomStore, func()) {
+		g, _, cleanup := openTestGitStore(t)
+		return g, cleanup
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+// Hashes of persisted Boms, to detect corruption or tampering, and of their
+// content, to detect identical re-uploads.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// SHA-256 of the canonical JSON encoding of the whole Bom, as
+// "sha256:<hex>". This is what's recorded in BomMeta.Hashes, so any edit to a
+// stored version (even just its timestamp or parent) is caught.
+func (b *Bom) Hash() string {
+	return sha256JSON(b)
+}
+
+// Like Hash, but the version name, timestamp, progeny and parent are left
+// out, so the same parts list uploaded twice (or forked) hashes the same.
+func (b *Bom) ContentHash() string {
+	content := *b
+	content.Version, content.Created, content.Progeny = "", time.Time{}, ""
+	content.Parent = nil
+	return sha256JSON(&content)
+}
+
+func sha256JSON(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sum := sha256.Sum256(buf)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Checks b against the hash recorded in bm for its version. Versions saved
+// before hashes were recorded pass.
+func VerifyBom(bm *BomMeta, b *Bom) error {
+	expected, ok := bm.Hashes[b.Version]
+	if !ok {
+		return nil
+	}
+	if actual := b.Hash(); actual != expected {
+		return storeError(ErrCorrupt, "content hash mismatch for "+bm.Owner+"/"+bm.Name+"/"+b.Version+
+			": expected "+expected+", got "+actual, nil)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBomHash(t *testing.T) {
+	_, b := makeTestBom()
+	h := b.Hash()
+	if !strings.HasPrefix(h, "sha256:") {
+		t.Errorf("unexpected hash format: %s", h)
+	}
+	// the same content under a different version and time has the same
+	// content hash, but not the same hash
+	c := *b
+	c.Version, c.Created, c.Progeny = "other", time.Now().Add(time.Hour), "elsewhere"
+	c.Parent = &BomRef{Owner: "someone", Name: "else", Version: "v1"}
+	if c.ContentHash() != b.ContentHash() {
+		t.Errorf("content hash should only cover content")
+	}
+	if c.Hash() == h {
+		t.Errorf("hash should cover the whole document")
+	}
+	c.LineItems = append([]LineItem{}, b.LineItems...)
+	c.LineItems[0].Comment = "changed"
+	if c.ContentHash() == b.ContentHash() {
+		t.Errorf("content hash should change with content")
+	}
+}
+
+func TestStoreVerifiesHash(t *testing.T) {
+	jfbs, dir, cleanup := openTestJSONStore(t)
+	defer cleanup()
+
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	if err := jfbs.Persist(bm, b, "v001"); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := jfbs.GetBom("tester", "widget", "v001")
+	if err != nil {
+		t.Fatalf("freshly persisted bom should verify: %s", err)
+	}
+	if stored.Hash() != bm.Hashes["v001"] {
+		t.Errorf("hash changed across a round trip")
+	}
+
+	// a second version, persisted with a fresh BomMeta, keeps the first hash
+	if err := jfbs.Persist(&BomMeta{Owner: "tester", Name: "widget"}, NewBom("v002"), "v002"); err != nil {
+		t.Fatal(err)
+	}
+	fpath := dir + "/tester/widget/v001.json"
+	raw, _ := ioutil.ReadFile(fpath)
+	ioutil.WriteFile(fpath, []byte(strings.Replace(string(raw), "WIDG0001", "WIDG0002", 1)), 0644)
+	if _, err := jfbs.GetBom("tester", "widget", "v001"); err == nil {
+		t.Errorf("expected tampered bom to fail verification")
+	}
+
+	// so does one with only its timestamp changed
+	fpath = dir + "/tester/widget/v002.json"
+	v2 := &Bom{}
+	readJsonBom(fpath, v2)
+	v2.Created = v2.Created.Add(-time.Hour)
+	writeJsonBom(fpath, v2)
+	if _, err := jfbs.GetBom("tester", "widget", "v002"); err == nil {
+		t.Errorf("expected a changed timestamp to fail verification")
+	}
+
+	// a content hash isn't enough
+	bm, _ = jfbs.GetBomMeta("tester", "widget")
+	bm.Hashes["v002"] = v2.ContentHash()
+	writeJsonBomMeta(dir+"/tester/widget/_meta.json", bm)
+	if _, err := jfbs.GetBom("tester", "widget", "v002"); StoreErrorKind(err) != ErrCorrupt {
+		t.Errorf("expected a content hash to fail verification, got %v", err)
+	}
+}
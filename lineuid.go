@@ -0,0 +1,207 @@
+package main
+
+// Persistent identity for LineItems across versions of a Bom. LineItem.Id()
+// changes whenever the part does (eg, a new MPN), but the Uid stays with the
+// position in the design, so history can report that a line changed parts
+// instead of one line being removed and another added.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+)
+
+func newLineUid() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Gives every LineItem of b a Uid, carrying them over from prev (usually the
+// current head; may be nil) where possible. Lines which already have a Uid
+// keep it. Otherwise a line gets the Uid of the prev line with the same Id(),
+// or failing that the prev line sharing the most element designators. Only
+// the Uid is carried over; see CarryLineComments.
+func AssignLineUids(b, prev *Bom) {
+	used := make(map[string]bool)
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		if used[li.Uid] {
+			// duplicated, eg by copy and paste in a spreadsheet
+			li.Uid = ""
+		}
+		if li.Uid != "" {
+			used[li.Uid] = true
+		}
+	}
+	if prev != nil {
+		for i := range b.LineItems {
+			li := &b.LineItems[i]
+			if li.Uid != "" {
+				continue
+			}
+			for j := range prev.LineItems {
+				if p := &prev.LineItems[j]; p.Uid != "" && !used[p.Uid] && p.Id() == li.Id() {
+					li.Uid = p.Uid
+					used[p.Uid] = true
+					break
+				}
+			}
+		}
+		for i := range b.LineItems {
+			li := &b.LineItems[i]
+			if li.Uid != "" {
+				continue
+			}
+			elements := stringSet(li.ElementIds())
+			delete(elements, "")
+			best, bestShared := "", 0
+			for j := range prev.LineItems {
+				p := &prev.LineItems[j]
+				if p.Uid == "" || used[p.Uid] {
+					continue
+				}
+				shared := 0
+				for _, el := range p.ElementIds() {
+					if elements[el] {
+						shared++
+					}
+				}
+				if shared > bestShared {
+					best, bestShared = p.Uid, shared
+				}
+			}
+			if best != "" {
+				li.Uid = best
+				used[best] = true
+			}
+		}
+	}
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		for li.Uid == "" {
+			if uid := newLineUid(); !used[uid] {
+				li.Uid = uid
+				used[uid] = true
+			}
+		}
+	}
+}
+
+// Gives lines of b without a Comment the one from the prev line with the same
+// Uid, for uploads from EDA exports which don't include comments. This is
+// opt-in, since it can't tell a missing comment from one deliberately removed.
+func CarryLineComments(b, prev *Bom) {
+	if prev == nil {
+		return
+	}
+	comments := make(map[string]string)
+	for i := range prev.LineItems {
+		if p := &prev.LineItems[i]; p.Uid != "" {
+			comments[p.Uid] = p.Comment
+		}
+	}
+	for i := range b.LineItems {
+		if li := &b.LineItems[i]; li.Comment == "" && li.Uid != "" {
+			li.Comment = comments[li.Uid]
+		}
+	}
+}
+
+// Finds a line by Uid, Id(), MPN, or one of its element designators.
+func (b *Bom) FindLine(ref string) *LineItem {
+	for i := range b.LineItems {
+		if b.LineItems[i].Uid == ref {
+			return &b.LineItems[i]
+		}
+	}
+	if li := b.FindLineItem(ref); li != nil {
+		return li
+	}
+	for i := range b.LineItems {
+		if b.LineItems[i].Mpn == ref {
+			return &b.LineItems[i]
+		}
+	}
+	for i := range b.LineItems {
+		for _, el := range b.LineItems[i].ElementIds() {
+			if el != "" && el == ref {
+				return &b.LineItems[i]
+			}
+		}
+	}
+	return nil
+}
+
+// One step in the history of a line.
+type LineEvent struct {
+	Version   string        `json:"version"`
+	Kind      string        `json:"kind"` // added, changed, or removed
+	Id        string        `json:"id"`
+	QtyBefore int           `json:"qty_before"`
+	QtyAfter  int           `json:"qty_after"`
+	Fields    []FieldChange `json:"fields,omitempty"`
+}
+
+// Follows the line with the given Uid (or Id, for versions from before lines
+// had Uids) through a series of versions, oldest first, returning each version
+// in which it appeared, changed, or went away.
+func LineHistory(versions []*Bom, uid string) []LineEvent {
+	events := []LineEvent{}
+	var prev *Bom
+	for _, b := range versions {
+		if prev == nil {
+			prev = &Bom{}
+		}
+		keys := lineKeys(prev, b)
+		var before, after *LineItem
+		for i, key := range keys[0] {
+			if key == uid {
+				before = &prev.LineItems[i]
+			}
+		}
+		for i, key := range keys[1] {
+			if key == uid {
+				after = &b.LineItems[i]
+			}
+		}
+		switch {
+		case before == nil && after != nil:
+			events = append(events, LineEvent{Version: b.Version, Kind: "added", Id: after.Id(),
+				QtyAfter: len(after.Elements)})
+		case before != nil && after == nil:
+			events = append(events, LineEvent{Version: b.Version, Kind: "removed", Id: before.Id(),
+				QtyBefore: len(before.Elements)})
+		case before != nil && after != nil:
+			if fields := diffLineItems(before, after); len(fields) > 0 {
+				events = append(events, LineEvent{Version: b.Version, Kind: "changed", Id: after.Id(),
+					QtyBefore: len(before.Elements),
+					QtyAfter:  len(after.Elements),
+					Fields:    fields})
+			}
+		}
+		prev = b
+	}
+	return events
+}
+
+func DumpLineHistoryAsText(events []LineEvent, out io.Writer) {
+	if len(events) == 0 {
+		fmt.Fprintln(out, "no history for that line")
+		return
+	}
+	for _, ev := range events {
+		fmt.Fprintf(out, "%s: %s %s", ev.Version, ev.Kind, ev.Id)
+		if ev.Kind == "changed" && ev.QtyBefore != ev.QtyAfter {
+			fmt.Fprintf(out, " (qty %d -> %d)", ev.QtyBefore, ev.QtyAfter)
+		}
+		fmt.Fprintln(out)
+		for _, fc := range ev.Fields {
+			fmt.Fprintf(out, "\t%s: %q -> %q\n", fc.Field, fc.Before, fc.After)
+		}
+	}
+}
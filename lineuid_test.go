@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAssignLineUids(t *testing.T) {
+	v1 := NewBom("v001")
+	v1.LineItems = []LineItem{
+		{Manufacturer: "Yageo", Mpn: "RC0402-10K", Elements: NewElements("R1", "R2", "R3"), Comment: "pullups"},
+		{Manufacturer: "TI", Mpn: "NE555", Elements: NewElements("U1")},
+	}
+	AssignLineUids(v1, nil)
+	if v1.LineItems[0].Uid == "" || v1.LineItems[0].Uid == v1.LineItems[1].Uid {
+		t.Fatalf("expected distinct uids: %+v", v1.LineItems)
+	}
+
+	// the resistor is swapped for a different part, and a line added
+	v2 := NewBom("v002")
+	v2.LineItems = []LineItem{
+		{Manufacturer: "Texas Instruments", Mpn: "NE555", Elements: NewElements("U1")},
+		{Manufacturer: "Vishay", Mpn: "CRCW0402-10K", Elements: NewElements("R1", "R2")},
+		{Manufacturer: "Yageo", Mpn: "RC0402-1K", Elements: NewElements("R9")},
+	}
+	AssignLineUids(v2, v1)
+	if v2.LineItems[0].Uid != v1.LineItems[1].Uid {
+		t.Errorf("expected uid to follow identical part")
+	}
+	if v2.LineItems[1].Uid != v1.LineItems[0].Uid || v2.LineItems[1].Comment != "" {
+		t.Errorf("expected only the uid to follow designators: %+v", v2.LineItems[1])
+	}
+	CarryLineComments(v2, v1)
+	if v2.LineItems[1].Comment != "pullups" || v2.LineItems[0].Comment != "" {
+		t.Errorf("expected the comment to follow the uid when asked: %+v", v2.LineItems)
+	}
+	if uid := v2.LineItems[2].Uid; uid == "" || uid == v1.LineItems[0].Uid || uid == v1.LineItems[1].Uid {
+		t.Errorf("expected a fresh uid for the new line")
+	}
+
+	d := DiffBoms(v1, v2)
+	if len(d.Added) != 1 || len(d.Removed) != 0 || len(d.Changed) != 1 {
+		t.Fatalf("expected one added and one changed line: %+v", d)
+	}
+	if lc := d.Changed[0]; lc.Uid != v1.LineItems[0].Uid || lc.QtyBefore != 3 || lc.QtyAfter != 2 {
+		t.Errorf("unexpected line change: %+v", lc)
+	}
+
+	events := LineHistory([]*Bom{v1, v2}, v1.LineItems[0].Uid)
+	if len(events) != 2 || events[0].Kind != "added" || events[1].Kind != "changed" || events[1].Version != "v002" {
+		t.Errorf("unexpected line history: %+v", events)
+	}
+}
+
+func TestLegacyLineKeys(t *testing.T) {
+	// lines saved before uids existed still match by identity
+	v1 := NewBom("v001")
+	v1.LineItems = []LineItem{{Manufacturer: "TI", Mpn: "NE555", Elements: NewElements("U1")}}
+	v2 := NewBom("v002")
+	v2.LineItems = []LineItem{{Manufacturer: "TI", Mpn: "NE555", Elements: NewElements("U1", "U2")}}
+	AssignLineUids(v2, v1)
+	d := DiffBoms(v1, v2)
+	if len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Changed) != 1 {
+		t.Errorf("expected legacy line to match: %+v", d)
+	}
+}
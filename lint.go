@@ -0,0 +1,366 @@
+package main
+
+// Rule-based checks for common BOM mistakes. Rules are registered in
+// lintRules; individual BOMs can suppress rules (or a rule for a single line)
+// with BomMeta.LintSuppress.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "unknown"
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+type LintResult struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Line     string   `json:"line,omitempty"` // LineItem Id, if specific to one line
+	Message  string   `json:"message"`
+}
+
+type LintRule struct {
+	Name        string
+	Description string
+	Severity    Severity
+	// Check only needs to fill in Line and Message of results
+	Check func(b *Bom) []LintResult
+	// instead of Check, for rules which look at prices in a currency
+	CheckPrices func(b *Bom, currency string) []LintResult
+}
+
+var lintRules = []*LintRule{}
+
+func RegisterLintRule(rule *LintRule) {
+	lintRules = append(lintRules, rule)
+}
+
+func GetLintRule(name string) *LintRule {
+	for _, rule := range lintRules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// A suppression is either a rule name, or "rule:line" for a single LineItem.
+// Line ids are compared with canonical manufacturer names, so suppressions
+// saved before (or without) canonicalization still match.
+func isSuppressed(bm *BomMeta, r *LintResult) bool {
+	if bm == nil {
+		return false
+	}
+	for _, s := range bm.LintSuppress {
+		if s == r.Rule {
+			return true
+		}
+		if rule, line := splitSuppression(s); r.Line != "" && rule == r.Rule && canonicalLineId(line) == canonicalLineId(r.Line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds and removes entries of a LintSuppress list, returning the new list.
+// Added entries must name a registered rule; removing one which isn't there
+// is an error too, so typos don't go unnoticed.
+func EditLintSuppress(current, add, remove []string) ([]string, error) {
+	same := func(a, b string) bool {
+		ar, al := splitSuppression(a)
+		br, bl := splitSuppression(b)
+		return ar == br && canonicalLineId(al) == canonicalLineId(bl)
+	}
+	suppress := []string{}
+	for _, s := range current {
+		removed := false
+		for _, r := range remove {
+			removed = removed || same(s, r)
+		}
+		if !removed {
+			suppress = append(suppress, s)
+		}
+	}
+	for _, r := range remove {
+		found := false
+		for _, s := range current {
+			found = found || same(s, r)
+		}
+		if !found {
+			return nil, Error("not suppressed: " + r)
+		}
+	}
+	for _, a := range add {
+		rule, _ := splitSuppression(a)
+		if GetLintRule(rule) == nil {
+			return nil, Error("no such lint rule: " + rule)
+		}
+		dup := false
+		for _, s := range suppress {
+			dup = dup || same(s, a)
+		}
+		if !dup {
+			suppress = append(suppress, a)
+		}
+	}
+	return suppress, nil
+}
+
+func splitSuppression(s string) (rule, line string) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// A LineItem Id ("manufacturer::mpn") with the manufacturer canonicalized.
+func canonicalLineId(id string) string {
+	if i := strings.Index(id, "::"); i >= 0 {
+		return CanonicalManufacturer(id[:i]) + id[i:]
+	}
+	return id
+}
+
+// Runs every registered rule against the Bom, most severe results first.
+// Prices are compared in the given currency.
+func LintBom(bm *BomMeta, b *Bom, currency string) []LintResult {
+	results := []LintResult{}
+	for _, rule := range lintRules {
+		var found []LintResult
+		if rule.CheckPrices != nil {
+			found = rule.CheckPrices(b, currency)
+		} else {
+			found = rule.Check(b)
+		}
+		for _, r := range found {
+			r.Rule = rule.Name
+			r.Severity = rule.Severity
+			if !isSuppressed(bm, &r) {
+				results = append(results, r)
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Severity > results[j].Severity
+	})
+	return results
+}
+
+// Counts results by severity name, eg for a summary banner.
+func LintSummary(results []LintResult) map[string]int {
+	summary := make(map[string]int)
+	for _, r := range results {
+		summary[r.Severity.String()]++
+	}
+	return summary
+}
+
+// ---------- built-in rules
+
+func init() {
+	RegisterLintRule(&LintRule{Name: "duplicate_designator",
+		Description: "the same element designator appears more than once",
+		Severity:    SeverityError,
+		Check:       lintDuplicateDesignators})
+	RegisterLintRule(&LintRule{Name: "missing_mpn",
+		Description: "line has no manufacturer part number",
+		Severity:    SeverityWarning,
+		Check: func(b *Bom) []LintResult {
+			return lintEachLine(b, func(li *LineItem) string {
+				if strings.TrimSpace(li.Mpn) == "" {
+					return "no manufacturer part number"
+				}
+				return ""
+			})
+		}})
+	RegisterLintRule(&LintRule{Name: "missing_manufacturer",
+		Description: "line has no manufacturer",
+		Severity:    SeverityWarning,
+		Check: func(b *Bom) []LintResult {
+			return lintEachLine(b, func(li *LineItem) string {
+				if strings.TrimSpace(li.Manufacturer) == "" && strings.TrimSpace(li.Mpn) != "" {
+					return "no manufacturer for " + li.Mpn
+				}
+				return ""
+			})
+		}})
+	RegisterLintRule(&LintRule{Name: "designator_category",
+		Description: "element designator prefix doesn't match the line's category",
+		Severity:    SeverityWarning,
+		Check:       lintDesignatorCategory})
+	RegisterLintRule(&LintRule{Name: "quantity_mismatch",
+		Description: "quantity doesn't match the number of designators",
+		Severity:    SeverityWarning,
+		Check: func(b *Bom) []LintResult {
+			return lintEachLine(b, func(li *LineItem) string {
+				named := 0
+				for _, el := range li.Elements {
+					if el.Id != "" {
+						named++
+					}
+				}
+				if named > 0 && named != len(li.Elements) {
+					return fmt.Sprintf("quantity is %d but only %d designators given", len(li.Elements), named)
+				}
+				return ""
+			})
+		}})
+	RegisterLintRule(&LintRule{Name: "case_duplicate",
+		Description: "lines are identical except for upper/lower case",
+		Severity:    SeverityWarning,
+		Check:       lintCaseDuplicates})
+	RegisterLintRule(&LintRule{Name: "unpriced",
+		Description: "line has no pricing offers",
+		Severity:    SeverityInfo,
+		Check: func(b *Bom) []LintResult {
+			return lintEachLine(b, func(li *LineItem) string {
+				if len(li.Offers) == 0 {
+					return "no pricing offers"
+				}
+				return ""
+			})
+		}})
+	RegisterLintRule(&LintRule{Name: "unavailable",
+		Description: "no offer (including alternates) has enough stock",
+		Severity:    SeverityWarning,
+		Check: func(b *Bom) []LintResult {
+			return lintEachLine(b, func(li *LineItem) string {
+				if len(li.Offers) > 0 && !li.HasStock(uint32(li.FittedQty())) {
+					return fmt.Sprintf("no source has %d in stock", li.FittedQty())
+				}
+				return ""
+			})
+		}})
+	RegisterLintRule(&LintRule{Name: "unconvertible",
+		Description: "parts are in stock, but no exchange rate to price them in the currency",
+		Severity:    SeverityWarning,
+		CheckPrices: func(b *Bom, currency string) []LintResult {
+			return lintEachLine(b, func(li *LineItem) string {
+				if li.HasStock(uint32(li.FittedQty())) && li.BestSource(currency) == nil {
+					return "no exchange rate to " + NormalizeCurrency(currency) + " for any source in stock"
+				}
+				return ""
+			})
+		}})
+}
+
+// Helper for simple rules: check returns a message for a bad line, or "".
+func lintEachLine(b *Bom, check func(li *LineItem) string) []LintResult {
+	results := []LintResult{}
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		if msg := check(li); msg != "" {
+			results = append(results, LintResult{Line: li.Id(), Message: msg})
+		}
+	}
+	return results
+}
+
+func lintDuplicateDesignators(b *Bom) []LintResult {
+	results := []LintResult{}
+	seen := make(map[string]string)
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		for _, el := range li.ElementIds() {
+			if el == "" {
+				continue
+			}
+			if other, ok := seen[el]; ok {
+				msg := el + " also appears on " + other
+				if other == li.Id() {
+					msg = el + " appears more than once on this line"
+				}
+				results = append(results, LintResult{Line: li.Id(), Message: msg})
+				continue
+			}
+			seen[el] = li.Id()
+		}
+	}
+	return results
+}
+
+func lintDesignatorCategory(b *Bom) []LintResult {
+	return lintEachLine(b, func(li *LineItem) string {
+		if strings.TrimSpace(li.Category) == "" {
+			return ""
+		}
+		for _, el := range li.ElementIds() {
+			byDesignator := ClassifyLineItem(&LineItem{Elements: NewElements(el)})
+			if byDesignator == "" {
+				continue
+			}
+			a, c := strings.ToLower(byDesignator), strings.ToLower(li.Category)
+			if !strings.HasPrefix(a, c) && !strings.HasPrefix(c, a) {
+				return el + " looks like " + byDesignator + " but line is " + li.Category
+			}
+		}
+		return ""
+	})
+}
+
+func lintCaseDuplicates(b *Bom) []LintResult {
+	results := []LintResult{}
+	seen := make(map[string]string)
+	for i := range b.LineItems {
+		id := b.LineItems[i].Id()
+		folded := strings.ToLower(id)
+		if other, ok := seen[folded]; ok && other != id {
+			results = append(results, LintResult{Line: id, Message: "same as " + other + " except for case"})
+			continue
+		}
+		seen[folded] = id
+	}
+	return results
+}
+
+// --------------------- output -----------------------
+
+func DumpLintAsText(results []LintResult, out io.Writer) {
+	if len(results) == 0 {
+		fmt.Fprintln(out, "no problems found")
+		return
+	}
+	tabWriter := tabwriter.NewWriter(out, 2, 4, 1, ' ', 0)
+	fmt.Fprintf(tabWriter, "severity\trule\tline\tmessage\n")
+	for _, r := range results {
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\n",
+			r.Severity,
+			r.Rule,
+			r.Line,
+			r.Message)
+	}
+	tabWriter.Flush()
+}
+
+func DumpLintAsJSON(results []LintResult, out io.Writer) {
+	enc := json.NewEncoder(out)
+	if err := enc.Encode(results); err != nil {
+		log.Fatal(err)
+	}
+}
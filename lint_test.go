@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func lintRuleCount(results []LintResult, rule string) int {
+	n := 0
+	for _, r := range results {
+		if r.Rule == rule {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLintBom(t *testing.T) {
+	b := NewBom("v001")
+	b.LineItems = []LineItem{
+		{Manufacturer: "Yageo", Mpn: "RC0603-10K", Category: "Passive,Resistor", Elements: NewElements("R1", "R2")},
+		{Manufacturer: "yageo", Mpn: "rc0603-10k", Elements: NewElements("R2", "")},
+		{Manufacturer: "Atmel", Mpn: "ATTINY85", Category: "Passive,Capacitor", Elements: NewElements("U1")},
+		{Mpn: "NE555", Elements: NewElements("U2")},
+	}
+	bm := &BomMeta{}
+	results := LintBom(bm, b, "USD")
+	expected := map[string]int{
+		"duplicate_designator": 1,
+		"case_duplicate":       1,
+		"designator_category":  1,
+		"missing_manufacturer": 1,
+		"missing_mpn":          0,
+		"quantity_mismatch":    1,
+		"unpriced":             4,
+	}
+	for rule, n := range expected {
+		if got := lintRuleCount(results, rule); got != n {
+			t.Errorf("expected %d %s results, got %d", n, rule, got)
+		}
+	}
+	if results[0].Severity != SeverityError {
+		t.Errorf("expected most severe results first")
+	}
+
+	bm.LintSuppress = []string{"unpriced", "case_duplicate:yageo::rc0603-10k"}
+	results = LintBom(bm, b, "USD")
+	if lintRuleCount(results, "unpriced") != 0 || lintRuleCount(results, "case_duplicate") != 0 {
+		t.Errorf("suppressions not applied")
+	}
+}
+
+func TestEditLintSuppress(t *testing.T) {
+	suppress, err := EditLintSuppress([]string{"unpriced", "case_duplicate:yageo::rc0603-10k"},
+		[]string{"missing_mpn", "unpriced"}, []string{"case_duplicate:Yageo::rc0603-10k"})
+	if err != nil || len(suppress) != 2 || suppress[0] != "unpriced" || suppress[1] != "missing_mpn" {
+		t.Errorf("unexpected suppressions: %v %v", suppress, err)
+	}
+	if _, err := EditLintSuppress(nil, []string{"no_such_rule:x::y"}, nil); err == nil {
+		t.Errorf("expected unknown rule to be refused")
+	}
+	if _, err := EditLintSuppress(nil, nil, []string{"unpriced"}); err == nil {
+		t.Errorf("expected removing a missing suppression to fail")
+	}
+}
+
+func TestLintUnconvertible(t *testing.T) {
+	b := NewBom("v001")
+	offer := Offer{Distributor: "Acme", Available: 10, Prices: []OfferPrice{{Currency: "XYZ", Price: MoneyUnit, MinQty: 1}}}
+	b.LineItems = []LineItem{{Manufacturer: "TI", Mpn: "NE555", Elements: NewElements("U1"), Offers: []Offer{offer}}}
+	results := LintBom(nil, b, "USD")
+	if lintRuleCount(results, "unconvertible") != 1 || lintRuleCount(results, "unavailable") != 0 {
+		t.Errorf("expected an unconvertible price, not an unavailable part: %+v", results)
+	}
+	b.LineItems[0].Offers[0].Available = 0
+	results = LintBom(nil, b, "USD")
+	if lintRuleCount(results, "unconvertible") != 0 || lintRuleCount(results, "unavailable") != 1 {
+		t.Errorf("expected an unavailable part: %+v", results)
+	}
+}
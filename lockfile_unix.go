@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive advisory lock on fpath (creating it if needed), blocking
+// until it's available. flock() locks belong to the open file, so this
+// excludes other goroutines as well as other processes, eg 'bommom serve' and
+// 'bommom load' running at the same time. Call the returned function to
+// release it.
+func lockFile(fpath string) (func(), error) {
+	f, err := os.OpenFile(fpath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The syscall package doesn't wrap these, and golang.org/x/sys isn't a
+// dependency, so call them from kernel32 directly.
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const lockfileExclusiveLock = 0x2
+
+// Takes an exclusive lock on fpath (creating it if needed), blocking until
+// it's available. Like flock() on unix, LockFileEx() locks belong to the open
+// file, so this excludes other goroutines as well as other processes. Call the
+// returned function to release it.
+func lockFile(fpath string) (func(), error) {
+	f, err := os.OpenFile(fpath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	h := syscall.Handle(f.Fd())
+	ol := new(syscall.Overlapped)
+	// the whole file, however long it gets
+	r1, _, e1 := procLockFileEx.Call(uintptr(h), lockfileExclusiveLock, 0,
+		0xffffffff, 0xffffffff, uintptr(unsafe.Pointer(ol)))
+	if r1 == 0 {
+		f.Close()
+		return nil, e1
+	}
+	return func() {
+		procUnlockFileEx.Call(uintptr(h), 0, 0xffffffff, 0xffffffff,
+			uintptr(unsafe.Pointer(ol)))
+		f.Close()
+	}, nil
+}
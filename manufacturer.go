@@ -0,0 +1,169 @@
+package main
+
+// Registry of manufacturer names and their aliases, so that "TI", "Texas
+// Instruments" and "texas instruments inc" are all recognized as the same
+// company. Names are compared by manufacturerKey(), which ignores case,
+// punctuation and corporate suffixes like "Inc."; aliases then map onto a
+// single canonical spelling.
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// manufacturerKey() of each canonical name and alias -> canonical name
+var manufacturerAliases = make(map[string]string)
+
+// Two letter abbreviations like "ST" or "ON" are left out, since they're too
+// easily a different company (or not a manufacturer at all).
+var defaultManufacturers = [][]string{
+	{"Texas Instruments", "TI", "Burr-Brown"},
+	{"Analog Devices", "ADI"},
+	{"Linear Technology", "LTC", "Linear Tech"},
+	{"Maxim Integrated", "Maxim", "Maxim Integrated Products", "Dallas Semiconductor"},
+	{"Microchip Technology", "Microchip"},
+	{"Atmel"},
+	{"STMicroelectronics", "STM", "ST Micro", "ST Microelectronics"},
+	{"NXP Semiconductors", "NXP", "Philips Semiconductors"},
+	{"ON Semiconductor", "ON Semi", "onsemi"},
+	{"Fairchild Semiconductor", "Fairchild"},
+	{"National Semiconductor", "National", "NatSemi"},
+	{"Toshiba", "Toshiba Semiconductor"},
+	{"Avago Technologies", "Avago"},
+	{"Infineon Technologies", "Infineon"},
+	{"Diodes Incorporated", "Diodes"},
+	{"Nexperia"},
+	{"Rohm Semiconductor", "Rohm"},
+	{"Renesas Electronics", "Renesas"},
+	{"Cypress Semiconductor", "Cypress"},
+	{"Silicon Labs", "Silicon Laboratories", "SiLabs"},
+	{"FTDI", "Future Technology Devices International"},
+	{"Vishay", "Vishay Intertechnology", "Vishay Dale", "Vishay Siliconix"},
+	{"Yageo"},
+	{"Murata", "Murata Manufacturing", "Murata Electronics"},
+	{"TDK"},
+	{"Kemet"},
+	{"AVX"},
+	{"Panasonic", "Panasonic Electronic Components"},
+	{"Samsung Electro-Mechanics", "Samsung", "SEMCO"},
+	{"Bourns"},
+	{"Molex"},
+	{"TE Connectivity", "Tyco Electronics", "Tyco", "AMP"},
+	{"Amphenol"},
+	{"Hirose Electric", "Hirose"},
+	{"Wurth Elektronik", "Würth Elektronik", "Wurth", "Würth"},
+}
+
+// Corporate suffixes which are ignored when comparing names.
+var manufacturerSuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "corp": true, "corporation": true,
+	"co": true, "company": true, "ltd": true, "limited": true, "llc": true,
+	"gmbh": true, "ag": true, "sa": true, "plc": true, "bv": true, "nv": true,
+}
+
+func init() {
+	for _, names := range defaultManufacturers {
+		RegisterManufacturer(names[0], names[1:]...)
+	}
+}
+
+// Lower case, without punctuation or trailing corporate suffixes.
+func manufacturerKey(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for len(words) > 1 && manufacturerSuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
+
+// Adds a canonical manufacturer name and any aliases for it. Later
+// registrations of the same alias win, so user files can override built-in
+// entries.
+func RegisterManufacturer(canonical string, aliases ...string) {
+	canonical = strings.TrimSpace(canonical)
+	manufacturerAliases[manufacturerKey(canonical)] = canonical
+	for _, alias := range aliases {
+		if key := manufacturerKey(alias); key != "" {
+			manufacturerAliases[key] = canonical
+		}
+	}
+}
+
+// The canonical form of a manufacturer name, and whether it was found in the
+// registry. Unknown names are returned with surrounding whitespace trimmed.
+func LookupManufacturer(name string) (string, bool) {
+	if canonical, ok := manufacturerAliases[manufacturerKey(name)]; ok {
+		return canonical, true
+	}
+	return strings.TrimSpace(name), false
+}
+
+func CanonicalManufacturer(name string) string {
+	canonical, _ := LookupManufacturer(name)
+	return canonical
+}
+
+// Sorted list of every registered canonical name.
+func ListManufacturers() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, canonical := range manufacturerAliases {
+		if !seen[canonical] {
+			seen[canonical] = true
+			names = append(names, canonical)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registers manufacturers from a CSV file with the canonical name first on
+// each line, followed by any aliases. Lines starting with '#' are ignored.
+func LoadManufacturers(input io.Reader) error {
+	reader := csv.NewReader(input)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, row := range records {
+		if len(row) == 0 || strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+		RegisterManufacturer(row[0], row[1:]...)
+	}
+	return nil
+}
+
+// Rewrites the manufacturer of every LineItem and Alternate to its canonical
+// form. Returns the names which aren't in the registry, each once.
+func CanonicalizeManufacturers(b *Bom) []string {
+	unresolved := []string{}
+	seen := make(map[string]bool)
+	fix := func(name *string) {
+		if *name == "" {
+			return
+		}
+		canonical, ok := LookupManufacturer(*name)
+		if !ok && !seen[canonical] {
+			seen[canonical] = true
+			unresolved = append(unresolved, canonical)
+		}
+		*name = canonical
+	}
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		fix(&li.Manufacturer)
+		for j := range li.Alternates {
+			fix(&li.Alternates[j].Manufacturer)
+		}
+	}
+	return unresolved
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalManufacturer(t *testing.T) {
+	for _, name := range []string{"TI", "Texas Instruments", "texas instruments inc", " Texas Instruments, Inc. "} {
+		if c, ok := LookupManufacturer(name); !ok || c != "Texas Instruments" {
+			t.Errorf("expected %q to resolve to Texas Instruments, got %q", name, c)
+		}
+	}
+	if c, ok := LookupManufacturer(" Acme Widgets "); ok || c != "Acme Widgets" {
+		t.Errorf("unknown manufacturer should be returned as-is: %q", c)
+	}
+	for _, name := range []string{"ST", "ON", "TE"} {
+		if _, ok := LookupManufacturer(name); ok {
+			t.Errorf("ambiguous abbreviation %q shouldn't resolve", name)
+		}
+	}
+
+	a := LineItem{Manufacturer: "TI", Mpn: "NE555"}
+	b := LineItem{Manufacturer: "texas instruments inc", Mpn: "NE555"}
+	if a.Id() != b.Id() {
+		t.Errorf("aliases should have the same identity: %s vs %s", a.Id(), b.Id())
+	}
+}
+
+func TestCanonicalizeManufacturers(t *testing.T) {
+	if err := LoadManufacturers(strings.NewReader("# test aliases\nAcme Widgets,ACMEW,Acme\n")); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBom("v001")
+	b.LineItems = []LineItem{
+		{Manufacturer: "acmew", Mpn: "W1", Alternates: []Alternate{{Manufacturer: "Gizmo Corp", Mpn: "G1"}}},
+		{Manufacturer: "Acme Widgets Ltd.", Mpn: "W1"},
+		{Manufacturer: "ST Micro", Mpn: "L7805"},
+	}
+	unresolved := CanonicalizeManufacturers(b)
+	if len(unresolved) != 1 || unresolved[0] != "Gizmo Corp" {
+		t.Errorf("unexpected unresolved names: %v", unresolved)
+	}
+	if b.LineItems[0].Manufacturer != "Acme Widgets" || b.LineItems[2].Manufacturer != "STMicroelectronics" {
+		t.Errorf("names not canonicalized: %+v", b.LineItems)
+	}
+	if removed, _ := ConsolidateBom(b); removed != 1 {
+		t.Errorf("expected aliased duplicate lines to consolidate, removed %d", removed)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// BomStore backend which keeps everything in memory, for tests and throwaway
+// servers. Safe for concurrent use; every operation takes the one lock, so
+// each is atomic.
+//
+// BOMs are kept as encoded JSON, so callers never share structures with the
+// store (or each other) and see the same round trip as the file store.
+type MemoryBomStore struct {
+	mu   sync.RWMutex
+	boms map[string]*memBom // by "owner/name"
+}
+
+type memBom struct {
+	meta     []byte
+	versions map[string][]byte
+}
+
+func NewMemoryBomStore() *MemoryBomStore {
+	return &MemoryBomStore{boms: make(map[string]*memBom)}
+}
+
+func memBomKey(user, name ShortName) string {
+	return string(user) + "/" + string(name)
+}
+
+// The following helpers expect the caller to hold the lock.
+
+func (ms *MemoryBomStore) getBomMeta(user, name ShortName) (*memBom, *BomMeta, error) {
+	if err := checkNames(user, name); err != nil {
+		return nil, nil, err
+	}
+	mb, ok := ms.boms[memBomKey(user, name)]
+	if !ok {
+		return nil, nil, storeError(ErrNotFound, "no such bom: "+string(user)+"/"+string(name), nil)
+	}
+	bm := &BomMeta{}
+	if err := json.Unmarshal(mb.meta, bm); err != nil {
+		return nil, nil, storeError(ErrCorrupt, "couldn't decode "+string(user)+"/"+string(name), err)
+	}
+	return mb, bm, nil
+}
+
+func (ms *MemoryBomStore) getBom(user, name, version ShortName) (*BomMeta, *Bom, error) {
+	if err := checkNames(version); err != nil {
+		return nil, nil, err
+	}
+	mb, bm, err := ms.getBomMeta(user, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, ok := mb.versions[string(version)]
+	if !ok {
+		return nil, nil, storeError(ErrNotFound, "no such version: "+string(version), nil)
+	}
+	b := &Bom{}
+	if err := json.Unmarshal(content, b); err != nil {
+		return nil, nil, storeError(ErrCorrupt, "couldn't decode version "+string(version), err)
+	}
+	if err := VerifyBom(bm, b); err != nil {
+		return nil, nil, err
+	}
+	return bm, b, nil
+}
+
+func (ms *MemoryBomStore) putBomMeta(mb *memBom, bm *BomMeta) error {
+	meta, err := json.Marshal(bm)
+	if err != nil {
+		return err
+	}
+	mb.meta = meta
+	return nil
+}
+
+func (ms *MemoryBomStore) GetBomMeta(user, name ShortName) (*BomMeta, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	_, bm, err := ms.getBomMeta(user, name)
+	return bm, err
+}
+
+func (ms *MemoryBomStore) GetHead(user, name ShortName) (*BomMeta, *Bom, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	_, bm, err := ms.getBomMeta(user, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bm.HeadVersion == "" {
+		return nil, nil, storeError(ErrCorrupt, "no head version for "+string(user)+"/"+string(name), nil)
+	}
+	return ms.getBom(user, name, ShortName(bm.HeadVersion))
+}
+
+func (ms *MemoryBomStore) GetBom(user, name, version ShortName) (*Bom, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	_, b, err := ms.getBom(user, name, version)
+	return b, err
+}
+
+func (ms *MemoryBomStore) Persist(bm *BomMeta, b *Bom, version ShortName) error {
+	b.Version = string(version)
+	bm.HeadVersion = string(version)
+	if err := bm.Validate(); err != nil {
+		return storeError(ErrInvalid, "invalid bom meta", err)
+	}
+	if err := b.Validate(); err != nil {
+		return storeError(ErrInvalid, "invalid bom", err)
+	}
+	content, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	key := memBomKey(ShortName(bm.Owner), ShortName(bm.Name))
+	mb, ok := ms.boms[key]
+	hashes := make(map[string]string)
+	if ok {
+		if _, exists := mb.versions[string(version)]; exists {
+			return storeError(ErrExists, "bom with same owner, name, and version already exists", nil)
+		}
+		_, old, err := ms.getBomMeta(ShortName(bm.Owner), ShortName(bm.Name))
+		if err != nil {
+			return err
+		}
+		for v, h := range old.Hashes {
+			hashes[v] = h
+		}
+	} else {
+		mb = &memBom{versions: make(map[string][]byte)}
+	}
+	for v, h := range bm.Hashes {
+		hashes[v] = h
+	}
+	hashes[string(version)] = b.Hash()
+	bm.Hashes = hashes
+	if err := ms.putBomMeta(mb, bm); err != nil {
+		return err
+	}
+	mb.versions[string(version)] = content
+	ms.boms[key] = mb
+	return nil
+}
+
+func (ms *MemoryBomStore) ListBoms(user ShortName, includeArchived bool) ([]BomMeta, error) {
+	if user != "" {
+		if err := checkNames(user); err != nil {
+			return nil, err
+		}
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	bmList := []BomMeta{}
+	for _, mb := range ms.boms {
+		bm := BomMeta{}
+		if err := json.Unmarshal(mb.meta, &bm); err != nil {
+			return nil, storeError(ErrCorrupt, "couldn't decode bom meta", err)
+		}
+		if (user != "" && bm.Owner != string(user)) || (bm.IsArchived && !includeArchived) {
+			continue
+		}
+		bmList = append(bmList, bm)
+	}
+	sort.Slice(bmList, func(i, j int) bool {
+		if bmList[i].Owner != bmList[j].Owner {
+			return bmList[i].Owner < bmList[j].Owner
+		}
+		return bmList[i].Name < bmList[j].Name
+	})
+	return bmList, nil
+}
+
+func (ms *MemoryBomStore) ListVersions(user, name ShortName) ([]BomVersion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	mb, bm, err := ms.getBomMeta(user, name)
+	if err != nil {
+		return nil, err
+	}
+	versions := []BomVersion{}
+	for v, content := range mb.versions {
+		b := Bom{}
+		if err := json.Unmarshal(content, &b); err != nil {
+			return nil, storeError(ErrCorrupt, "couldn't decode version "+v, err)
+		}
+		versions = append(versions, newBomVersion(bm, &b))
+	}
+	sortBomVersions(versions)
+	return versions, nil
+}
+
+func (ms *MemoryBomStore) DeleteVersion(user, name, version ShortName) error {
+	if err := checkNames(version); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	mb, bm, err := ms.getBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	if string(version) == bm.HeadVersion {
+		return storeError(ErrInvalid, "can't delete the head version ("+bm.HeadVersion+"); set a different head first", nil)
+	}
+	if _, ok := mb.versions[string(version)]; !ok {
+		return storeError(ErrNotFound, "no such version: "+string(version), nil)
+	}
+	delete(bm.Hashes, string(version))
+	if err := ms.putBomMeta(mb, bm); err != nil {
+		return err
+	}
+	delete(mb.versions, string(version))
+	return nil
+}
+
+func (ms *MemoryBomStore) SetArchived(user, name ShortName, archived bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	mb, bm, err := ms.getBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	bm.IsArchived = archived
+	return ms.putBomMeta(mb, bm)
+}
+
+func (ms *MemoryBomStore) SetLintSuppress(user, name ShortName, suppress []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	mb, bm, err := ms.getBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	bm.LintSuppress = suppress
+	return ms.putBomMeta(mb, bm)
+}
+
+func (ms *MemoryBomStore) SetHead(user, name, version ShortName) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	// also checks that the version exists and is intact
+	bm, _, err := ms.getBom(user, name, version)
+	if err != nil {
+		return err
+	}
+	bm.HeadVersion = string(version)
+	return ms.putBomMeta(ms.boms[memBomKey(user, name)], bm)
+}
+
+func (ms *MemoryBomStore) Fork(user, name, version, newOwner, newName ShortName) error {
+	return forkBom(ms, user, name, version, newOwner, newName, false)
+}
+
+func (ms *MemoryBomStore) CopyWithHistory(user, name, newOwner, newName ShortName) error {
+	return forkBom(ms, user, name, "", newOwner, newName, true)
+}
+
+func (ms *MemoryBomStore) TransferOwnership(user, name, newOwner ShortName) error {
+	if err := checkNames(newOwner); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	mb, bm, err := ms.getBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	newKey := memBomKey(newOwner, name)
+	if _, ok := ms.boms[newKey]; ok {
+		return storeError(ErrExists, "bom already exists: "+string(newOwner)+"/"+string(name), nil)
+	}
+	bm.Owner = string(newOwner)
+	if err := ms.putBomMeta(mb, bm); err != nil {
+		return err
+	}
+	delete(ms.boms, memBomKey(user, name))
+	ms.boms[newKey] = mb
+
+	// keep forks pointing at the right place, as the other stores do
+	for _, fork := range ms.boms {
+		fbm := &BomMeta{}
+		if err := json.Unmarshal(fork.meta, fbm); err != nil {
+			return storeError(ErrCorrupt, "couldn't decode bom meta", err)
+		}
+		if fbm.ForkOf.Is(user, name) {
+			fbm.ForkOf.Owner = string(newOwner)
+			if err := ms.putBomMeta(fork, fbm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	testBomStore(t, func(t *testing.T) (BomStore, func()) {
+		return NewMemoryBomStore(), func() {}
+	})
+}
+
+func TestMemoryStoreForks(t *testing.T) {
+	ms := NewMemoryBomStore()
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "common", "widget"
+	for _, v := range []string{"v1", "v2"} {
+		if err := ms.Persist(bm, b, ShortName(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ms.Fork("common", "widget", "v1", "alice", "gadget"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.CopyWithHistory("common", "widget", "bob", "widget"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := ms.ListVersions("bob", "widget"); len(versions) != 2 {
+		t.Errorf("copy should have both versions, got %d", len(versions))
+	}
+	if err := ms.TransferOwnership("common", "widget", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.GetBomMeta("common", "widget"); StoreErrorKind(err) != ErrNotFound {
+		t.Errorf("bom still under old owner: %v", err)
+	}
+	if tbm, _, err := ms.GetHead("carol", "widget"); err != nil || tbm.Owner != "carol" || len(tbm.Hashes) != 2 {
+		t.Errorf("transferred bom not readable: %+v %v", tbm, err)
+	}
+	if forks, _ := ListForks(ms, "carol", "widget"); len(forks) != 2 {
+		t.Errorf("forks should follow the transfer, got %+v", forks)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+// Three-way merge of Boms: two sets of changes ("ours" and "theirs") made
+// against a common "base" version are combined into a single Bom.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// An edit which couldn't be merged automatically because both sides changed
+// the same thing in different ways.
+type MergeConflict struct {
+	Id     string `json:"id"`
+	Field  string `json:"field"`
+	Base   string `json:"base"`
+	Ours   string `json:"ours"`
+	Theirs string `json:"theirs"`
+}
+
+// Takes whichever side changed a value; ok is false if both changed it
+// differently.
+func merge3(base, ours, theirs string) (merged string, ok bool) {
+	switch {
+	case ours == theirs:
+		return ours, true
+	case ours == base:
+		return theirs, true
+	case theirs == base:
+		return ours, true
+	}
+	return ours, false
+}
+
+// Set-wise merge of string lists (eg, tags): an entry is kept
+// unless one side removed it, and additions from both sides are included.
+// Ordering follows ours, then any additions from theirs.
+func mergeSet(base, ours, theirs []string) []string {
+	inBase, inOurs, inTheirs := stringSet(base), stringSet(ours), stringSet(theirs)
+	merged := []string{}
+	for _, s := range ours {
+		if inTheirs[s] || !inBase[s] {
+			merged = append(merged, s)
+		}
+	}
+	for _, s := range theirs {
+		if !inOurs[s] && !inBase[s] {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
+// Elements are merged by designator, each taken whole from whichever side
+// changed (or added, or removed) it. Where both sides changed the same
+// designator differently, ours is kept and a conflict is returned.
+func mergeElements(key string, base, ours, theirs []Element) ([]Element, []MergeConflict) {
+	lookup := func(l []Element) map[string]*Element {
+		m := make(map[string]*Element)
+		for i := range l {
+			m[l[i].Id] = &l[i]
+		}
+		return m
+	}
+	baseEls, ourEls, theirEls := lookup(base), lookup(ours), lookup(theirs)
+	encode := func(el *Element) string {
+		if el == nil {
+			return ""
+		}
+		return jsonString(el)
+	}
+	merged := []Element{}
+	conflicts := []MergeConflict{}
+	ids := elementIds(ours)
+	for _, id := range elementIds(theirs) {
+		if ourEls[id] == nil {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		if id == "" {
+			// handled below
+			continue
+		}
+		o, t := ourEls[id], theirEls[id]
+		b := encode(baseEls[id])
+		m, ok := merge3(b, encode(o), encode(t))
+		if !ok {
+			conflicts = append(conflicts, MergeConflict{Id: key, Field: "element " + id, Base: b, Ours: encode(o), Theirs: encode(t)})
+		}
+		switch {
+		case m == "":
+			// removed
+		case m == encode(o):
+			merged = append(merged, *o)
+		default:
+			merged = append(merged, *t)
+		}
+	}
+	// placeholder elements (unnamed) are just a quantity, which can't be
+	// merged by designator; take whichever side changed the count
+	count := func(l []Element) string {
+		n := 0
+		for _, el := range l {
+			if el.Id == "" {
+				n++
+			}
+		}
+		return fmt.Sprint(n)
+	}
+	b, o, t := count(base), count(ours), count(theirs)
+	n, ok := merge3(b, o, t)
+	if !ok {
+		conflicts = append(conflicts, MergeConflict{Id: key, Field: "unnamed elements", Base: b, Ours: o, Theirs: t})
+	}
+	blanks, _ := strconv.Atoi(n)
+	for i := 0; i < blanks; i++ {
+		merged = append(merged, Element{})
+	}
+	return merged, conflicts
+}
+
+func stringSet(l []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, s := range l {
+		set[s] = true
+	}
+	return set
+}
+
+// For values with no sensible field-level merge (eg, offers), compares JSON
+// encodings.
+func jsonString(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		log.Println("error encoding for merge: " + err.Error())
+	}
+	return string(buf)
+}
+
+func mergeLineItems(key string, base, ours, theirs *LineItem) (LineItem, []MergeConflict) {
+	conflicts := []MergeConflict{}
+	merged := *ours
+	strField := func(name string, dst *string, b, o, t string) {
+		var ok bool
+		if *dst, ok = merge3(b, o, t); !ok {
+			conflicts = append(conflicts, MergeConflict{Id: key, Field: name, Base: b, Ours: o, Theirs: t})
+		}
+	}
+	strField("manufacturer", &merged.Manufacturer, base.Manufacturer, ours.Manufacturer, theirs.Manufacturer)
+	strField("mpn", &merged.Mpn, base.Mpn, ours.Mpn, theirs.Mpn)
+	strField("description", &merged.Description, base.Description, ours.Description, theirs.Description)
+	strField("form_factor", &merged.FormFactor, base.FormFactor, ours.FormFactor, theirs.FormFactor)
+	strField("specs", &merged.Specs, base.Specs, ours.Specs, theirs.Specs)
+	strField("comment", &merged.Comment, base.Comment, ours.Comment, theirs.Comment)
+	strField("category", &merged.Category, base.Category, ours.Category, theirs.Category)
+	strField("lifecycle", &merged.Lifecycle, base.Lifecycle, ours.Lifecycle, theirs.Lifecycle)
+	strField("rohs", &merged.Rohs, base.Rohs, ours.Rohs, theirs.Rohs)
+	strField("reach", &merged.Reach, base.Reach, ours.Reach, theirs.Reach)
+	var leadWeeks string
+	strField("lead_weeks", &leadWeeks, fmt.Sprint(base.LeadWeeks), fmt.Sprint(ours.LeadWeeks), fmt.Sprint(theirs.LeadWeeks))
+	if leadWeeks != fmt.Sprint(ours.LeadWeeks) {
+		merged.LeadWeeks = theirs.LeadWeeks
+	}
+	merged.Tags = mergeSet(base.Tags, ours.Tags, theirs.Tags)
+	var elementConflicts []MergeConflict
+	merged.Elements, elementConflicts = mergeElements(key, base.Elements, ours.Elements, theirs.Elements)
+	conflicts = append(conflicts, elementConflicts...)
+
+	// offers and alternates are taken as a whole from whichever side changed
+	var offers, alternates string
+	strField("offers", &offers, jsonString(base.Offers), jsonString(ours.Offers), jsonString(theirs.Offers))
+	if offers != jsonString(ours.Offers) {
+		merged.Offers = theirs.Offers
+	}
+	strField("alternates", &alternates, jsonString(base.Alternates), jsonString(ours.Alternates), jsonString(theirs.Alternates))
+	if alternates != jsonString(ours.Alternates) {
+		merged.Alternates = theirs.Alternates
+	}
+	return merged, conflicts
+}
+
+// Merges the changes made in ours and theirs relative to base, matching
+// LineItems by identity. The merged Bom gets a fresh timestamp but no version.
+// Where both sides made incompatible edits, ours is kept in the merged Bom and
+// a MergeConflict is returned; the merge should only be used if there are none.
+func MergeBoms(base, ours, theirs *Bom) (*Bom, []MergeConflict) {
+	merged := &Bom{Created: time.Now(),
+		Progeny:   fmt.Sprintf("Merge of %s and %s (from %s)", ours.Version, theirs.Version, base.Version),
+		LineItems: []LineItem{}}
+	conflicts := []MergeConflict{}
+
+	keys := lineKeys(base, ours, theirs)
+	baseKeys, ourKeys, theirKeys := keys[0], keys[1], keys[2]
+	baseLines := make(map[string]*LineItem)
+	for i, key := range baseKeys {
+		baseLines[key] = &base.LineItems[i]
+	}
+	theirLines := make(map[string]*LineItem)
+	for i, key := range theirKeys {
+		theirLines[key] = &theirs.LineItems[i]
+	}
+	ourLines := make(map[string]*LineItem)
+	for i, key := range ourKeys {
+		ourLines[key] = &ours.LineItems[i]
+	}
+
+	mergeOne := func(key string, b, o, t *LineItem) {
+		empty := &LineItem{}
+		// conflicts are reported by the line's (current) Id, not its Uid
+		for _, li := range []*LineItem{b, t, o} {
+			if li != nil {
+				key = li.Id()
+			}
+		}
+		switch {
+		case o != nil && t != nil:
+			if b == nil {
+				// added on both sides
+				b = empty
+			}
+			li, lcs := mergeLineItems(key, b, o, t)
+			merged.LineItems = append(merged.LineItems, li)
+			conflicts = append(conflicts, lcs...)
+		case b == nil:
+			// added on only one side
+			if o != nil {
+				merged.LineItems = append(merged.LineItems, *o)
+			} else {
+				merged.LineItems = append(merged.LineItems, *t)
+			}
+		case o != nil:
+			// deleted by theirs
+			if jsonString(o) != jsonString(b) {
+				conflicts = append(conflicts, MergeConflict{Id: key, Field: "line", Base: "present", Ours: "modified", Theirs: "deleted"})
+				merged.LineItems = append(merged.LineItems, *o)
+			}
+		case t != nil:
+			// deleted by ours
+			if jsonString(t) != jsonString(b) {
+				conflicts = append(conflicts, MergeConflict{Id: key, Field: "line", Base: "present", Ours: "deleted", Theirs: "modified"})
+			}
+		}
+	}
+	for _, key := range ourKeys {
+		mergeOne(key, baseLines[key], ourLines[key], theirLines[key])
+	}
+	for _, key := range theirKeys {
+		if ourLines[key] == nil {
+			mergeOne(key, baseLines[key], nil, theirLines[key])
+		}
+	}
+	// (lines deleted on both sides are simply left out)
+
+	// an element might have been moved to different lines by each side
+	elementLines := make(map[string]string)
+	for i := range merged.LineItems {
+		key := merged.LineItems[i].Id()
+		for _, el := range merged.LineItems[i].ElementIds() {
+			if other, ok := elementLines[el]; ok && el != "" {
+				conflicts = append(conflicts, MergeConflict{Id: el, Field: "element", Ours: other, Theirs: key})
+			}
+			elementLines[el] = key
+		}
+	}
+
+	merged.Variants = mergeVariants(base.Variants, ours.Variants, theirs.Variants, &conflicts)
+	return merged, conflicts
+}
+
+// Variants are merged by name, each taken whole from whichever side changed it.
+func mergeVariants(base, ours, theirs []Variant, conflicts *[]MergeConflict) []Variant {
+	find := func(l []Variant, name string) string {
+		for i := range l {
+			if l[i].Name == name {
+				return jsonString(&l[i])
+			}
+		}
+		return ""
+	}
+	merged := []Variant{}
+	add := func(v *Variant) {
+		b, o, t := find(base, v.Name), find(ours, v.Name), find(theirs, v.Name)
+		m, ok := merge3(b, o, t)
+		if !ok {
+			*conflicts = append(*conflicts, MergeConflict{Id: v.Name, Field: "variant", Base: b, Ours: o, Theirs: t})
+		}
+		if m == "" {
+			// deleted
+			return
+		}
+		mv := Variant{}
+		if err := json.Unmarshal([]byte(m), &mv); err != nil {
+			log.Println("error decoding variant for merge: " + err.Error())
+			return
+		}
+		merged = append(merged, mv)
+	}
+	for i := range ours {
+		add(&ours[i])
+	}
+	for i := range theirs {
+		if find(ours, theirs[i].Name) == "" {
+			add(&theirs[i])
+		}
+	}
+	return merged
+}
+
+func DumpConflictsAsText(conflicts []MergeConflict, out io.Writer) {
+	tabWriter := tabwriter.NewWriter(out, 2, 4, 1, ' ', 0)
+	fmt.Fprintf(tabWriter, "line\tfield\tbase\tours\ttheirs\n")
+	for _, mc := range conflicts {
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%s\n",
+			mc.Id,
+			mc.Field,
+			mc.Base,
+			mc.Ours,
+			mc.Theirs)
+	}
+	tabWriter.Flush()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMergeBoms(t *testing.T) {
+	base := NewBom("v001")
+	base.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-10K", Elements: NewElements("R1", "R2")})
+	base.AddLineItem(&LineItem{Manufacturer: "Atmel", Mpn: "ATTINY85", Elements: NewElements("U1")})
+	base.AddLineItem(&LineItem{Manufacturer: "TI", Mpn: "NE555", Elements: NewElements("U2")})
+
+	// ours: adds R3, comments on the MCU
+	ours := NewBom("v002")
+	ours.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-10K", Elements: NewElements("R1", "R2", "R3")})
+	ours.AddLineItem(&LineItem{Manufacturer: "Atmel", Mpn: "ATTINY85", Elements: NewElements("U1"), Comment: "socketed"})
+	ours.AddLineItem(&LineItem{Manufacturer: "TI", Mpn: "NE555", Elements: NewElements("U2")})
+
+	// theirs: removes the timer, adds a cap, describes the MCU
+	theirs := NewBom("v003")
+	theirs.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-10K", Elements: NewElements("R1", "R2")})
+	theirs.AddLineItem(&LineItem{Manufacturer: "Atmel", Mpn: "ATTINY85", Elements: NewElements("U1"), Description: "MCU"})
+	theirs.AddLineItem(&LineItem{Manufacturer: "Murata", Mpn: "GRM188", Elements: NewElements("C1")})
+
+	merged, conflicts := MergeBoms(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected clean merge, got %v", conflicts)
+	}
+	if len(merged.LineItems) != 3 {
+		t.Fatalf("expected 3 merged lines, got %d", len(merged.LineItems))
+	}
+	if li := merged.GetLineItem("Yageo", "RC0603-10K"); li == nil || len(li.Elements) != 3 {
+		t.Errorf("expected our added element to survive")
+	}
+	if li := merged.GetLineItem("Atmel", "ATTINY85"); li == nil || li.Comment != "socketed" || li.Description != "MCU" {
+		t.Errorf("expected both edits to the MCU line")
+	}
+	if merged.GetLineItem("TI", "NE555") != nil {
+		t.Errorf("expected their deletion to be applied")
+	}
+	if merged.GetLineItem("Murata", "GRM188") == nil {
+		t.Errorf("expected their added line")
+	}
+
+	// now both sides edit the same comment
+	theirs.LineItems[1].Comment = "soldered down"
+	_, conflicts = MergeBoms(base, ours, theirs)
+	if len(conflicts) != 1 || conflicts[0].Field != "comment" || conflicts[0].Theirs != "soldered down" {
+		t.Errorf("expected a single comment conflict, got %v", conflicts)
+	}
+
+	// and ours modifies a line which theirs deleted
+	ours.LineItems[2].Comment = "keep this"
+	_, conflicts = MergeBoms(base, ours, theirs)
+	if len(conflicts) != 2 || conflicts[1].Field != "line" {
+		t.Errorf("expected modify/delete conflict, got %v", conflicts)
+	}
+}
+
+func TestMergeElements(t *testing.T) {
+	base := NewBom("v001")
+	base.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-10K", Elements: NewElements("R1", "R2", "", "")})
+	edit := func(version string, change func(els []Element) []Element) *Bom {
+		b := NewBom(version)
+		li := base.LineItems[0]
+		li.Elements = change(append([]Element{}, li.Elements...))
+		b.AddLineItem(&li)
+		return b
+	}
+
+	// different designators placed on each side merge cleanly
+	ours := edit("v002", func(els []Element) []Element {
+		els[0].Placed, els[0].X, els[0].Y = true, 1, 2
+		return els
+	})
+	theirs := edit("v003", func(els []Element) []Element {
+		els[1].Dnp = true
+		return els
+	})
+	merged, conflicts := MergeBoms(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected clean merge, got %v", conflicts)
+	}
+	if els := merged.LineItems[0].Elements; len(els) != 4 || els[0].X != 1 || !els[1].Dnp {
+		t.Errorf("expected both element edits: %+v", els)
+	}
+
+	// both sides change the same designator
+	theirs = edit("v003", func(els []Element) []Element {
+		els[0].Placed, els[0].X, els[0].Y = true, 5, 5
+		els[0].Dnp = true
+		return els
+	})
+	merged, conflicts = MergeBoms(base, ours, theirs)
+	if len(conflicts) != 1 || conflicts[0].Field != "element R1" {
+		t.Fatalf("expected an element conflict, got %v", conflicts)
+	}
+	if el := merged.LineItems[0].Elements[0]; el.X != 1 || el.Dnp {
+		t.Errorf("expected ours kept on conflict: %+v", el)
+	}
+
+	// both sides change the number of placeholders
+	ours = edit("v002", func(els []Element) []Element { return els[:3] })
+	theirs = edit("v003", func(els []Element) []Element { return append(els, Element{}) })
+	_, conflicts = MergeBoms(base, ours, theirs)
+	if len(conflicts) != 1 || conflicts[0].Field != "unnamed elements" || conflicts[0].Ours != "1" || conflicts[0].Theirs != "3" {
+		t.Errorf("expected a placeholder count conflict, got %v", conflicts)
+	}
+}
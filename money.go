@@ -0,0 +1,297 @@
+package main
+
+// Exact decimal money amounts and currency conversion.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An amount of money in millionths of a currency unit. Prices like 0.1 can't
+// be represented exactly as floats, and the rounding errors add up over large
+// orders. The currency itself is kept seperately (eg, OfferPrice.Currency).
+type Money int64
+
+const MoneyUnit Money = 1000000
+
+// Parses a decimal string like "12", "0.0125" or "-3.5". More than six
+// decimal places is an error rather than being silently rounded.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		// exponent form, as written for tiny floats
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return 0, Error("not a money amount: \"" + s + "\"")
+		}
+		return moneyFromRat(r), nil
+	}
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return 0, Error("not a money amount: \"" + s + "\"")
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > 6 {
+		return 0, Error("too many decimal places: \"" + s + "\"")
+	}
+	frac += strings.Repeat("0", 6-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+	w, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, Error("not a money amount: \"" + s + "\"")
+	}
+	f, err := strconv.ParseUint(frac, 10, 63)
+	if err != nil {
+		return 0, Error("not a money amount: \"" + s + "\"")
+	}
+	m := Money(w)*MoneyUnit + Money(f)
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+// Nearest Money to a float, eg from a JSON API response.
+func MoneyFromFloat(f float64) Money {
+	m, err := ParseMoney(strconv.FormatFloat(f, 'f', 6, 64))
+	if err != nil {
+		return 0
+	}
+	return m
+}
+
+// Decimal form with at least two decimal places, eg "0.80" or "0.0125".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	frac := strconv.FormatInt(int64(m%MoneyUnit)+int64(MoneyUnit), 10)[1:]
+	frac = strings.TrimRight(frac, "0")
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	return sign + strconv.FormatInt(int64(m/MoneyUnit), 10) + "." + frac
+}
+
+func (m Money) Float() float64 {
+	return float64(m) / float64(MoneyUnit)
+}
+
+func (m Money) Mul(qty uint32) Money {
+	return m * Money(qty)
+}
+
+// Divides, rounding half away from zero.
+func (m Money) Div(n uint32) Money {
+	if n == 0 {
+		return 0
+	}
+	q, r := m/Money(n), m%Money(n)
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= Money(n) {
+		if m < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+func (m Money) rat() *big.Rat {
+	return new(big.Rat).SetFrac64(int64(m), int64(MoneyUnit))
+}
+
+func moneyFromRat(r *big.Rat) Money {
+	scaled := new(big.Rat).Mul(r, big.NewRat(int64(MoneyUnit), 1))
+	num, den := scaled.Num(), scaled.Denom()
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	// round half away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return Money(q.Int64())
+}
+
+// JSON numbers, so that older files with float prices still load. Those may
+// have more than six decimal places (eg, 0.0012345 from a distributor API),
+// which are rounded to the nearest Money as in MoneyFromFloat.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	// null leaves the value alone, as it did for float prices
+	if string(data) == "null" {
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok {
+		return Error("not a money amount: \"" + string(n) + "\"")
+	}
+	*m = moneyFromRat(r)
+	return nil
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalText(text []byte) error {
+	var err error
+	*m, err = ParseMoney(string(text))
+	return err
+}
+
+// Upper case ISO 4217 code, eg "USD".
+func NormalizeCurrency(c string) string {
+	return strings.ToUpper(strings.TrimSpace(c))
+}
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+// Human readable amount, eg "$0.80" or "CHF 0.80".
+func FormatMoney(m Money, currency string) string {
+	currency = NormalizeCurrency(currency)
+	if sym, ok := currencySymbols[currency]; ok {
+		return sym + m.String()
+	}
+	if currency == "" {
+		return m.String()
+	}
+	return currency + " " + m.String()
+}
+
+// --------------------- exchange rates -----------------------
+
+// One unit of From is worth Rate units of To, starting at Effective.
+type ExchangeRate struct {
+	From      string
+	To        string
+	Effective time.Time
+	Rate      *big.Rat
+}
+
+type ExchangeTable struct {
+	rates []ExchangeRate // sorted by Effective
+}
+
+// Global table used for pricing; see the "rates" flag.
+var exchangeRates = &ExchangeTable{}
+
+// Reads CSV rows of "effective_date,from,to,rate", eg
+// "2026-01-01,EUR,USD,1.0925". A header row and '#' comments are allowed.
+func LoadExchangeTable(input io.Reader) (*ExchangeTable, error) {
+	reader := csv.NewReader(input)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	et := &ExchangeTable{}
+	for i, row := range records {
+		if len(row) != 4 {
+			return nil, Error("exchange rates: expected 4 columns on line " + strconv.Itoa(i+1))
+		}
+		effective, err := time.Parse("2006-01-02", strings.TrimSpace(row[0]))
+		if err != nil {
+			if i == 0 {
+				// header row
+				continue
+			}
+			return nil, err
+		}
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(row[3]))
+		if !ok || rate.Sign() <= 0 {
+			return nil, Error("exchange rates: bad rate on line " + strconv.Itoa(i+1))
+		}
+		et.rates = append(et.rates, ExchangeRate{From: NormalizeCurrency(row[1]),
+			To:        NormalizeCurrency(row[2]),
+			Effective: effective,
+			Rate:      rate})
+	}
+	sort.SliceStable(et.rates, func(i, j int) bool {
+		return et.rates[i].Effective.Before(et.rates[j].Effective)
+	})
+	return et, nil
+}
+
+// The most recent rate in effect at the given time for a direct (or inverse)
+// pair, or nil.
+func (et *ExchangeTable) directRate(from, to string, at time.Time) *big.Rat {
+	var rate *big.Rat
+	for _, er := range et.rates {
+		if er.Effective.After(at) {
+			break
+		}
+		switch {
+		case er.From == from && er.To == to:
+			rate = er.Rate
+		case er.From == to && er.To == from:
+			rate = new(big.Rat).Inv(er.Rate)
+		}
+	}
+	return rate
+}
+
+// Conversion rate at the given time, going through a third currency if there
+// is no direct rate.
+func (et *ExchangeTable) RateAt(from, to string, at time.Time) (*big.Rat, error) {
+	from, to = NormalizeCurrency(from), NormalizeCurrency(to)
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	if rate := et.directRate(from, to, at); rate != nil {
+		return rate, nil
+	}
+	for _, er := range et.rates {
+		for _, via := range []string{er.From, er.To} {
+			if via == from || via == to {
+				continue
+			}
+			r1, r2 := et.directRate(from, via, at), et.directRate(via, to, at)
+			if r1 != nil && r2 != nil {
+				return new(big.Rat).Mul(r1, r2), nil
+			}
+		}
+	}
+	return nil, Error("no exchange rate from " + from + " to " + to)
+}
+
+func (et *ExchangeTable) Convert(m Money, from, to string, at time.Time) (Money, error) {
+	rate, err := et.RateAt(from, to, at)
+	if err != nil {
+		return 0, err
+	}
+	return moneyFromRat(new(big.Rat).Mul(m.rat(), rate)), nil
+}
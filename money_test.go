@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMoney(t *testing.T) {
+	for s, want := range map[string]string{"12": "12.00", "0.0125": "0.0125", "-3.5": "-3.50", ".1": "0.10", "1e-2": "0.01"} {
+		m, err := ParseMoney(s)
+		if err != nil || m.String() != want {
+			t.Errorf("ParseMoney(%q) = %v, %v; expected %s", s, m, err, want)
+		}
+	}
+	if _, err := ParseMoney("0.0000001"); err == nil {
+		t.Errorf("expected error for too many decimal places")
+	}
+	if m := (MoneyUnit / 10).Mul(3); m.String() != "0.30" {
+		t.Errorf("expected exact 0.30, got %v", m)
+	}
+	if m := MoneyUnit.Div(3); m != 333333 {
+		t.Errorf("unexpected rounding: %v", m)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var op OfferPrice
+	if err := json.Unmarshal([]byte(`{"currency": "usd", "min_qty": 10, "price": 0.1}`), &op); err != nil {
+		t.Fatal(err)
+	}
+	if op.Price != MoneyUnit/10 {
+		t.Errorf("legacy float price not loaded exactly: %v", op.Price)
+	}
+	out, _ := json.Marshal(op.Price)
+	if string(out) != "0.10" {
+		t.Errorf("unexpected JSON: %s", out)
+	}
+	var m Money
+	for in, expected := range map[string]Money{"0.0012345": 1235, "-0.0000004": 0, "2.5e-7": 0, "1.99999999": 2 * MoneyUnit} {
+		if err := json.Unmarshal([]byte(in), &m); err != nil || m != expected {
+			t.Errorf("%s: expected %d, got %d %v", in, expected, m, err)
+		}
+	}
+	op = OfferPrice{}
+	if err := json.Unmarshal([]byte(`{"currency": "usd", "min_qty": 10, "price": null}`), &op); err != nil || op.Price != 0 {
+		t.Errorf("null price: expected 0, got %d %v", op.Price, err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	et, err := LoadExchangeTable(strings.NewReader("effective,from,to,rate\n" +
+		"2012-01-01,EUR,USD,1.30\n2012-06-01,EUR,USD,1.25\n2012-01-01,GBP,USD,1.60\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jul := time.Date(2012, 7, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2012, 2, 1, 0, 0, 0, 0, time.UTC)
+	if m, err := et.Convert(10*MoneyUnit, "eur", "USD", jul); err != nil || m.String() != "12.50" {
+		t.Errorf("expected newest rate: %v, %v", m, err)
+	}
+	if m, err := et.Convert(10*MoneyUnit, "EUR", "USD", feb); err != nil || m.String() != "13.00" {
+		t.Errorf("expected older rate: %v, %v", m, err)
+	}
+	if m, err := et.Convert(13*MoneyUnit, "USD", "EUR", feb); err != nil || m.String() != "10.00" {
+		t.Errorf("expected inverse rate: %v, %v", m, err)
+	}
+	// EUR -> USD -> GBP
+	if m, err := et.Convert(16*MoneyUnit, "EUR", "GBP", jul); err != nil || m.String() != "12.50" {
+		t.Errorf("expected pivot conversion: %v, %v", m, err)
+	}
+	if _, err := et.Convert(MoneyUnit, "EUR", "JPY", jul); err == nil {
+		t.Errorf("expected error for unknown currency")
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-    "strconv"
 	//"io/ioutil"
 )
 
@@ -119,6 +118,12 @@ func (oc *OctopartClient) GetMarketInfoList(manufacturers, mpns []string) ([]map
 	if len(mpns) > 100 {
 		return nil, Error("can't handle more than 100 queries at a time (yet)")
 	}
+	// cache and query by canonical name, so aliases share results
+	canonical := make([]string, len(manufacturers))
+	for i := range manufacturers {
+		canonical[i] = CanonicalManufacturer(manufacturers[i])
+	}
+	manufacturers = canonical
 	mpnToQuery := make([]string, 0)
 	manufacturersToQuery := make([]string, 0)
 	queryHash := ""
@@ -177,7 +182,9 @@ func (oc *OctopartClient) GetExtraInfo(manufacturer, mpn string) (map[string]str
     ret := make(map[string]string)
     if marketInfo != nil {
         if marketInfo["avg_price"].([]interface{})[0] != nil {
-            ret["MarketPrice"] = "$" + strconv.FormatFloat(marketInfo["avg_price"].([]interface{})[0].(float64), 'f', 2, 64)
+            avgPrice := marketInfo["avg_price"].([]interface{})
+            currency, _ := avgPrice[1].(string)
+            ret["MarketPrice"] = FormatMoney(MoneyFromFloat(avgPrice[0].(float64)), currency)
         } else {
             ret["MarketPrice"] = ""
         }
@@ -187,28 +194,151 @@ func (oc *OctopartClient) GetExtraInfo(manufacturer, mpn string) (map[string]str
 	return ret, nil
 }
 
-func (oc *OctopartClient) AttachMarketInfo(li *LineItem) error {
-	if li.AggregateInfo == nil {
-		li.AggregateInfo = make(map[string]string)
+// Pulls distributor offers (with price breaks) out of Octopart market info.
+// Entries which don't look as expected are skipped.
+func offersFromMarketInfo(marketInfo map[string]interface{}) []Offer {
+	offers := []Offer{}
+	rawOffers, ok := marketInfo["offers"].([]interface{})
+	if !ok {
+		return offers
+	}
+	for _, rawOffer := range rawOffers {
+		info, ok := rawOffer.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		o := Offer{Prices: []OfferPrice{}}
+		if supplier, ok := info["supplier"].(map[string]interface{}); ok {
+			o.Distributor, _ = supplier["displayname"].(string)
+		}
+		o.Sku, _ = info["sku"].(string)
+		o.Url, _ = info["clickthrough_url"].(string)
+		if avail, ok := info["in_stock_quantity"].(float64); ok && avail > 0 {
+			o.Available = uint32(avail)
+		}
+		if days, ok := info["factory_lead_days"].(float64); ok && days > 0 {
+			o.LeadWeeks = uint32((days + 6) / 7)
+		}
+		rawPrices, _ := info["prices"].([]interface{})
+		for _, rawPrice := range rawPrices {
+			// each price break is a [min_qty, price, currency] triple
+			triple, ok := rawPrice.([]interface{})
+			if !ok || len(triple) < 3 {
+				continue
+			}
+			minQty, ok1 := triple[0].(float64)
+			price, ok2 := triple[1].(float64)
+			currency, ok3 := triple[2].(string)
+			if !(ok1 && ok2 && ok3) {
+				continue
+			}
+			o.Prices = append(o.Prices, OfferPrice{Currency: NormalizeCurrency(currency), MinQty: uint32(minQty), Price: MoneyFromFloat(price)})
+		}
+		offers = append(offers, o)
+	}
+	return offers
+}
+
+// Looks up a part attribute which may be given either directly or in the
+// "specs" map (as a string, or a {"value": [...]} entry).
+func marketInfoString(marketInfo map[string]interface{}, key string) string {
+	if s, ok := marketInfo[key].(string); ok {
+		return s
+	}
+	specs, _ := marketInfo["specs"].(map[string]interface{})
+	switch spec := specs[key].(type) {
+	case string:
+		return spec
+	case map[string]interface{}:
+		if values, ok := spec["value"].([]interface{}); ok && len(values) > 0 {
+			s, _ := values[0].(string)
+			return s
+		}
+		s, _ := spec["display_value"].(string)
+		return s
+	}
+	return ""
+}
+
+// Fills in lifecycle and compliance status from Octopart, unless they have
+// already been set by hand.
+func (oc *OctopartClient) attachStatusInfo(li *LineItem) error {
+	marketInfo, err := oc.GetMarketInfo(li.Manufacturer, li.Mpn)
+	if err != nil || marketInfo == nil {
+		return err
+	}
+	if li.Lifecycle == "" {
+		li.Lifecycle = NormalizeLifecycle(marketInfoString(marketInfo, "lifecycle_status"))
+	}
+	if li.Rohs == "" {
+		li.Rohs = NormalizeCompliance(marketInfoString(marketInfo, "rohs_status"))
+	}
+	if li.Reach == "" {
+		li.Reach = NormalizeCompliance(marketInfoString(marketInfo, "reach_status"))
+	}
+	return nil
+}
+
+func (oc *OctopartClient) GetOffers(manufacturer, mpn string) ([]Offer, error) {
+	marketInfo, err := oc.GetMarketInfo(manufacturer, mpn)
+	if err != nil {
+		return nil, err
+	}
+	if marketInfo == nil {
+		return []Offer{}, nil
 	}
-	extraInfo, err := oc.GetExtraInfo(li.Manufacturer, li.Mpn)
+	return offersFromMarketInfo(marketInfo), nil
+}
+
+// Fills in AggregateInfo (and Offers, if there weren't any already) for a
+// single part. Used for both primary parts and Alternates.
+func (oc *OctopartClient) attachPartInfo(manufacturer, mpn string, aggInfo *map[string]string, offers *[]Offer) error {
+	if *aggInfo == nil {
+		*aggInfo = make(map[string]string)
+	}
+	extraInfo, err := oc.GetExtraInfo(manufacturer, mpn)
 	if err != nil {
 		log.Println(err.Error())
 		return err
 	}
 	for key := range extraInfo {
-		li.AggregateInfo[key] = string(extraInfo[key])
+		(*aggInfo)[key] = string(extraInfo[key])
+	}
+	if len(*offers) == 0 {
+		if *offers, err = oc.GetOffers(manufacturer, mpn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (oc *OctopartClient) AttachMarketInfo(li *LineItem) error {
+	if err := oc.attachPartInfo(li.Manufacturer, li.Mpn, &li.AggregateInfo, &li.Offers); err != nil {
+		return err
+	}
+	if err := oc.attachStatusInfo(li); err != nil {
+		return err
+	}
+	for i := range li.Alternates {
+		alt := &li.Alternates[i]
+		if err := oc.attachPartInfo(alt.Manufacturer, alt.Mpn, &alt.AggregateInfo, &alt.Offers); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (oc *OctopartClient) AttachMarketInfoBom(b *Bom) error {
     // first ensure the cache is primed
-    manufacturers := make([]string, len(b.LineItems))
-    mpns := make([]string, len(b.LineItems))
-    for i, li := range b.LineItems {
-        manufacturers[i] = li.Manufacturer
-        mpns[i] = li.Mpn
+    manufacturers := make([]string, 0, len(b.LineItems))
+    mpns := make([]string, 0, len(b.LineItems))
+    for _, li := range b.LineItems {
+        manufacturers = append(manufacturers, li.Manufacturer)
+        mpns = append(mpns, li.Mpn)
+        for _, alt := range li.Alternates {
+            manufacturers = append(manufacturers, alt.Manufacturer)
+            mpns = append(mpns, alt.Mpn)
+        }
     }
     _, err := oc.GetMarketInfoList(manufacturers, mpns)
     if err != nil {
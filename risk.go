@@ -0,0 +1,182 @@
+package main
+
+// Part lifecycle and regulatory compliance status, and a per-BOM report of
+// sourcing risks: single-sourced, obsolete, long lead time, and non-compliant
+// lines.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Lifecycle states, from least to most worrying.
+const (
+	LifecycleActive   = "active"
+	LifecycleNRND     = "nrnd" // not recommended for new designs
+	LifecycleEOL      = "eol"  // end of life; last time buy
+	LifecycleObsolete = "obsolete"
+)
+
+// RoHS/REACH compliance states. The empty string means unknown.
+const (
+	Compliant    = "compliant"
+	Exempt       = "exempt"
+	NonCompliant = "non-compliant"
+)
+
+// Maps the various ways distributors and spreadsheets write a lifecycle
+// status onto the constants above. Unrecognized values are returned lower
+// cased.
+func NormalizeLifecycle(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "active", "production", "in production", "new product":
+		return LifecycleActive
+	case "nrnd", "not recommended", "not recommended for new designs":
+		return LifecycleNRND
+	case "eol", "end of life", "last time buy", "ltb":
+		return LifecycleEOL
+	case "obsolete", "discontinued", "inactive":
+		return LifecycleObsolete
+	}
+	return s
+}
+
+// Like NormalizeLifecycle, for RoHS and REACH flags.
+func NormalizeCompliance(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "compliant", "yes", "y", "true", "rohs", "rohs3", "lead free", "lead-free":
+		return Compliant
+	case "exempt", "compliant by exemption", "compliant with exemption":
+		return Exempt
+	case "non-compliant", "not compliant", "noncompliant", "no", "n", "false":
+		return NonCompliant
+	}
+	return s
+}
+
+// Weeks to get enough parts for one board. An explicit LeadWeeks on the line
+// wins; otherwise it's zero if any source has stock, else the shortest
+// factory lead time of any offer. Zero with nothing in stock means unknown.
+func (li *LineItem) LeadTime() uint32 {
+	if li.LeadWeeks > 0 {
+		return li.LeadWeeks
+	}
+	if li.HasStock(uint32(li.FittedQty())) {
+		return 0
+	}
+	best := uint32(0)
+	check := func(offers []Offer) {
+		for _, o := range offers {
+			if o.LeadWeeks > 0 && (best == 0 || o.LeadWeeks < best) {
+				best = o.LeadWeeks
+			}
+		}
+	}
+	check(li.Offers)
+	for _, alt := range li.Alternates {
+		if alt.Status == AltApproved {
+			check(alt.Offers)
+		}
+	}
+	return best
+}
+
+type Risk struct {
+	Kind     string   `json:"kind"` // single_source, obsolete, long_lead, or non_compliant
+	Severity Severity `json:"severity"`
+	Line     string   `json:"line"`
+	Message  string   `json:"message"`
+}
+
+// Flags risky lines in the Bom, most severe first. Lines with a lead time
+// longer than maxLeadWeeks count as long-lead.
+func RiskReport(b *Bom, maxLeadWeeks uint32) []Risk {
+	risks := []Risk{}
+	add := func(li *LineItem, kind string, sev Severity, msg string) {
+		risks = append(risks, Risk{Kind: kind, Severity: sev, Line: li.Id(), Message: msg})
+	}
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+
+		approved := 0
+		for _, alt := range li.Alternates {
+			if alt.Status == AltApproved {
+				approved++
+			}
+		}
+		// most lines have no alternates, so that alone isn't worth a
+		// mention; only when there's also at most one distributor
+		if approved == 0 {
+			distributors := make(map[string]bool)
+			for _, o := range li.Offers {
+				if o.Available > 0 {
+					distributors[o.Distributor] = true
+				}
+			}
+			if len(li.Offers) > 0 && len(distributors) <= 1 {
+				add(li, "single_source", SeverityWarning,
+					fmt.Sprintf("no approved alternates, and stocked by %d distributors", len(distributors)))
+			}
+		}
+
+		switch li.Lifecycle {
+		case LifecycleObsolete:
+			add(li, "obsolete", SeverityError, "part is obsolete")
+		case LifecycleEOL:
+			add(li, "obsolete", SeverityWarning, "part is end of life")
+		case LifecycleNRND:
+			add(li, "obsolete", SeverityInfo, "part is not recommended for new designs")
+		}
+
+		if lead := li.LeadTime(); lead > maxLeadWeeks {
+			add(li, "long_lead", SeverityWarning, fmt.Sprintf("lead time is %d weeks", lead))
+		}
+
+		if li.Rohs == NonCompliant {
+			add(li, "non_compliant", SeverityError, "not RoHS compliant")
+		}
+		if li.Reach == NonCompliant {
+			add(li, "non_compliant", SeverityError, "not REACH compliant")
+		}
+	}
+	sort.SliceStable(risks, func(i, j int) bool {
+		return risks[i].Severity > risks[j].Severity
+	})
+	return risks
+}
+
+// Counts risks by kind, eg for a summary banner.
+func RiskSummary(risks []Risk) map[string]int {
+	summary := make(map[string]int)
+	for _, r := range risks {
+		summary[r.Kind]++
+	}
+	return summary
+}
+
+func DumpRisksAsText(risks []Risk, out io.Writer) {
+	if len(risks) == 0 {
+		fmt.Fprintln(out, "no sourcing risks found")
+		return
+	}
+	tabWriter := tabwriter.NewWriter(out, 2, 4, 1, ' ', 0)
+	fmt.Fprintf(tabWriter, "severity\trisk\tline\tmessage\n")
+	for _, r := range risks {
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\n", r.Severity, r.Kind, r.Line, r.Message)
+	}
+	tabWriter.Flush()
+}
+
+func DumpRisksAsJSON(risks []Risk, out io.Writer) {
+	enc := json.NewEncoder(out)
+	if err := enc.Encode(risks); err != nil {
+		log.Fatal(err)
+	}
+}
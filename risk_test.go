@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func riskCount(risks []Risk, kind string, sev Severity) int {
+	n := 0
+	for _, r := range risks {
+		if r.Kind == kind && r.Severity == sev {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRiskReport(t *testing.T) {
+	_, b := makeTestBom()
+	b.LineItems[0].Lifecycle = LifecycleEOL
+	b.LineItems[1].Rohs = NonCompliant
+	b.LineItems[1].Offers[0].Available = 0
+	b.LineItems[1].Offers[0].LeadWeeks = 20
+	risks := RiskReport(b, 12)
+
+	if riskCount(risks, "obsolete", SeverityWarning) != 1 {
+		t.Errorf("expected EOL line to be flagged: %+v", risks)
+	}
+	if riskCount(risks, "non_compliant", SeverityError) != 1 {
+		t.Errorf("expected non-RoHS line to be flagged: %+v", risks)
+	}
+	if riskCount(risks, "long_lead", SeverityWarning) != 1 {
+		t.Errorf("expected out of stock line to be long-lead: %+v", risks)
+	}
+	// the first line has an approved alternate
+	for _, r := range risks {
+		if r.Kind == "single_source" && r.Line == b.LineItems[0].Id() {
+			t.Errorf("line with approved alternate flagged as single source")
+		}
+	}
+	if riskCount(risks, "single_source", SeverityWarning) != 2 {
+		t.Errorf("expected lines with one distributor to be single source: %+v", risks)
+	}
+	if risks[0].Severity != SeverityError {
+		t.Errorf("expected most severe risks first")
+	}
+
+	b.LineItems[1].LeadWeeks = 4
+	if riskCount(RiskReport(b, 12), "long_lead", SeverityWarning) != 0 {
+		t.Errorf("explicit lead time should override offers")
+	}
+
+	// several distributors is fine without alternates, and isn't mentioned
+	b.LineItems[2].Offers = append(b.LineItems[2].Offers, Offer{Distributor: "Widgets R Us", Available: 10})
+	for _, r := range RiskReport(b, 12) {
+		if r.Kind == "single_source" && r.Line == b.LineItems[2].Id() {
+			t.Errorf("line with two distributors flagged: %+v", r)
+		}
+	}
+}
+
+func TestLoadStatusFromCSV(t *testing.T) {
+	b, err := LoadBomFromCSV(strings.NewReader("qty,mpn,lifecycle,rohs,lead time\n" +
+		"1,NE555,Not Recommended for New Designs,Yes,8\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	li := b.LineItems[0]
+	if li.Lifecycle != LifecycleNRND || li.Rohs != Compliant || li.LeadWeeks != 8 {
+		t.Errorf("unexpected status fields: %+v", li)
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"net/http"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var (
-	tmplHome, tmplView, tmplAccount, tmplUser, tmplBomView, tmplBomUpload *template.Template
+	tmplHome, tmplView, tmplAccount, tmplUser, tmplBomView, tmplBomUpload, tmplBomDiff, tmplBomHistory *template.Template
 )
 
 var store = sessions.NewCookieStore([]byte(*sessionSecret))
@@ -23,6 +25,11 @@ func baseHandler(w http.ResponseWriter, r *http.Request) {
 
 	bomUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/$")
 	bomUploadUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/_upload/$")
+	bomHistoryUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/_history/$")
+	bomManageUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/_manage/$")
+	bomForkUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/_fork/$")
+	bomVersionUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/$")
+	bomDiffUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/_diff/([a-zA-Z][a-zA-Z0-9_]*)/([a-zA-Z][a-zA-Z0-9_]*)/$")
 	userUrlPattern := regexp.MustCompile("^/([a-zA-Z][a-zA-Z0-9_]*)/$")
 
 	switch {
@@ -37,9 +44,24 @@ func baseHandler(w http.ResponseWriter, r *http.Request) {
 	case bomUploadUrlPattern.MatchString(r.URL.Path):
 		match := bomUploadUrlPattern.FindStringSubmatch(r.URL.Path)
 		err = bomUploadController(w, r, match[1], match[2])
+	case bomDiffUrlPattern.MatchString(r.URL.Path):
+		match := bomDiffUrlPattern.FindStringSubmatch(r.URL.Path)
+		err = bomDiffController(w, r, match[1], match[2], match[3], match[4])
+	case bomHistoryUrlPattern.MatchString(r.URL.Path):
+		match := bomHistoryUrlPattern.FindStringSubmatch(r.URL.Path)
+		err = bomHistoryController(w, r, match[1], match[2])
+	case bomManageUrlPattern.MatchString(r.URL.Path):
+		match := bomManageUrlPattern.FindStringSubmatch(r.URL.Path)
+		err = bomManageController(w, r, match[1], match[2])
+	case bomForkUrlPattern.MatchString(r.URL.Path):
+		match := bomForkUrlPattern.FindStringSubmatch(r.URL.Path)
+		err = bomForkController(w, r, match[1], match[2])
+	case bomVersionUrlPattern.MatchString(r.URL.Path):
+		match := bomVersionUrlPattern.FindStringSubmatch(r.URL.Path)
+		err = bomController(w, r, match[1], match[2], match[3])
 	case bomUrlPattern.MatchString(r.URL.Path):
 		match := bomUrlPattern.FindStringSubmatch(r.URL.Path)
-		err = bomController(w, r, match[1], match[2])
+		err = bomController(w, r, match[1], match[2], "")
 	case userUrlPattern.MatchString(r.URL.Path):
 		match := userUrlPattern.FindStringSubmatch(r.URL.Path)
 		err = userController(w, r, match[1], "")
@@ -56,12 +78,34 @@ func baseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HTTP status for an error from the BomStore.
+func storeErrorStatus(err error) int {
+	switch StoreErrorKind(err) {
+	case ErrNotFound:
+		return 404
+	case ErrExists:
+		return 409
+	case ErrInvalid:
+		return 400
+	}
+	// corrupt files, I/O errors
+	return 500
+}
+
+func httpStoreError(w http.ResponseWriter, msg string, err error) {
+	status := storeErrorStatus(err)
+	if status == 500 {
+		log.Println("store error, 500: " + msg + ": " + err.Error())
+	}
+	http.Error(w, strconv.Itoa(status)+" "+msg+": "+err.Error(), status)
+}
+
 func homeController(w http.ResponseWriter, r *http.Request) (err error) {
 	session, _ := store.Get(r, "bommom")
 	context := make(map[string]interface{})
 	context["Session"] = session.Values
 	log.Printf("%s\n", session.Values["UserName"])
-	context["BomList"], err = bomstore.ListBoms("")
+	context["BomList"], err = bomstore.ListBoms("", false)
 	if err != nil {
 		return
 	}
@@ -123,7 +167,7 @@ func userController(w http.ResponseWriter, r *http.Request, user, extra string)
 		return
 	}
 	context := make(map[string]interface{})
-	context["BomList"], err = bomstore.ListBoms(ShortName(user))
+	context["BomList"], err = bomstore.ListBoms(ShortName(user), r.FormValue("archived") != "")
 	if user == "common" {
 		context["IsCommon"] = true
 	}
@@ -136,7 +180,8 @@ func userController(w http.ResponseWriter, r *http.Request, user, extra string)
 	return
 }
 
-func bomController(w http.ResponseWriter, r *http.Request, user, name string) (err error) {
+// Shows the head of a BOM, or a specific version if one is given.
+func bomController(w http.ResponseWriter, r *http.Request, user, name, version string) (err error) {
 	session, _ := store.Get(r, "bommom")
 	if !isShortName(user) {
 		http.Error(w, "invalid username: "+user, 400)
@@ -146,22 +191,331 @@ func bomController(w http.ResponseWriter, r *http.Request, user, name string) (e
 		http.Error(w, "invalid bom name: "+name, 400)
 		return
 	}
+	if version != "" && !isShortName(version) {
+		http.Error(w, "invalid version: "+version, 400)
+		return
+	}
 
 	context := make(map[string]interface{})
 	context["BomMeta"], context["Bom"], err = bomstore.GetHead(ShortName(user), ShortName(name))
 	context["Session"] = session.Values
 	if err != nil {
-		http.Error(w, "404 couldn't open bom: "+user+"/"+name, 404)
+		httpStoreError(w, "couldn't open bom "+user+"/"+name, err)
 		return nil
 	}
+	if version != "" {
+		if context["Bom"], err = bomstore.GetBom(ShortName(user), ShortName(name), ShortName(version)); err != nil {
+			httpStoreError(w, "couldn't open version "+version, err)
+			return nil
+		}
+	}
+	b := context["Bom"].(*Bom)
+	context["Variants"] = b.Variants
+	if variant := r.FormValue("variant"); variant != "" {
+		if b, err = b.ForVariant(variant); err != nil {
+			http.Error(w, "404 "+err.Error(), 404)
+			return nil
+		}
+		context["Bom"] = b
+		context["Variant"] = variant
+	}
+	context["TagCloud"] = TagCloud(b)
+	if tag := r.FormValue("tag"); tag != "" {
+		context["Bom"] = b.FilterByTag(tag)
+		context["Tag"] = tag
+	}
     err = pricingSource.AttachMarketInfoBom(context["Bom"].(*Bom))
     if err != nil {
         log.Println("error attaching market info: " + err.Error())
     }
+	currency := requestCurrency(w, r, session)
+	context["Currency"] = currency
+	bestSources := []*Source{}
+	for i := range context["Bom"].(*Bom).LineItems {
+		li := &context["Bom"].(*Bom).LineItems[i]
+		bestSources = append(bestSources, li.BestSource(currency))
+	}
+	context["BestSources"] = bestSources
+	context["Categories"] = CategoryBreakdown(context["Bom"].(*Bom), currency)
+	buildQty := uint64(1)
+	if qtyStr := r.FormValue("qty"); qtyStr != "" {
+		if buildQty, err = strconv.ParseUint(qtyStr, 10, 32); err != nil || buildQty == 0 {
+			http.Error(w, "invalid build quantity: "+qtyStr, 400)
+			return nil
+		}
+	}
+	context["BuildQty"] = buildQty
+	context["Cost"] = CostRollup(context["Bom"].(*Bom), uint32(buildQty), attritionRules, currency)
+	context["Lint"] = LintBom(context["BomMeta"].(*BomMeta), context["Bom"].(*Bom), currency)
+	context["LintSummary"] = LintSummary(context["Lint"].([]LintResult))
+	context["CanEdit"] = canEdit(session, context["BomMeta"].(*BomMeta))
+	context["Risks"] = RiskReport(context["Bom"].(*Bom), uint32(*maxLeadWeeks))
+	if context["Forks"], err = ListForks(bomstore, ShortName(user), ShortName(name)); err != nil {
+		return err
+	}
 	err = tmplBomView.Execute(w, context)
 	return
 }
 
+// Currency to show prices in: a "currency" query parameter (which is then
+// remembered in the session), else the session's, else the server default.
+func requestCurrency(w http.ResponseWriter, r *http.Request, session *sessions.Session) string {
+	if c := NormalizeCurrency(r.FormValue("currency")); len(c) == 3 && strings.Trim(c, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == "" {
+		session.Values["Currency"] = c
+		session.Save(r, w)
+		return c
+	}
+	if c, ok := session.Values["Currency"].(string); ok && c != "" {
+		return c
+	}
+	return NormalizeCurrency(*currencyName)
+}
+
+// One row of the history page: a version, and the one before it (if any).
+type historyRow struct {
+	BomVersion
+	Previous string
+}
+
+// Most versions the history page will load to follow a line through them.
+const lineHistoryVersions = 20
+
+func bomHistoryController(w http.ResponseWriter, r *http.Request, user, name string) (err error) {
+	session, _ := store.Get(r, "bommom")
+	if !isShortName(user) || !isShortName(name) {
+		http.Error(w, "invalid name: "+user+"/"+name, 400)
+		return
+	}
+
+	context := make(map[string]interface{})
+	context["Session"] = session.Values
+	bm, err := bomstore.GetBomMeta(ShortName(user), ShortName(name))
+	if err != nil {
+		httpStoreError(w, "couldn't open bom "+user+"/"+name, err)
+		return nil
+	}
+	versions, err := bomstore.ListVersions(ShortName(user), ShortName(name))
+	if err != nil {
+		httpStoreError(w, "couldn't list versions of "+user+"/"+name, err)
+		return nil
+	}
+	// newest first
+	rows := make([]historyRow, len(versions))
+	for i, bv := range versions {
+		rows[len(versions)-1-i].BomVersion = bv
+		if i > 0 {
+			rows[len(versions)-1-i].Previous = versions[i-1].Version
+		}
+	}
+	context["BomMeta"] = bm
+	context["History"] = rows
+	context["CanEdit"] = canEdit(session, bm)
+	context["IsOwner"] = session.Values["UserName"] == bm.Owner
+
+	// history of a single line, by uid, id, mpn, or designator. Every version
+	// has to be loaded for this, so only the latest few are.
+	if ref := r.FormValue("line"); ref != "" && len(versions) > 0 {
+		recent := versions
+		if len(recent) > lineHistoryVersions {
+			// one more, so the first shown is compared with what came before
+			recent = recent[len(recent)-lineHistoryVersions-1:]
+			context["LineOlder"] = len(versions) - lineHistoryVersions
+		}
+		boms := make([]*Bom, len(recent))
+		for i, bv := range recent {
+			if boms[i], err = bomstore.GetBom(ShortName(user), ShortName(name), ShortName(bv.Version)); err != nil {
+				httpStoreError(w, "couldn't open version "+bv.Version, err)
+				return nil
+			}
+		}
+		context["Line"] = ref
+		context["LineEvents"] = []LineEvent{}
+		if li := boms[len(boms)-1].FindLine(ref); li != nil {
+			key := li.Uid
+			if key == "" {
+				key = li.Id()
+			}
+			events := LineHistory(boms, key)
+			if len(recent) < len(versions) && len(events) > 0 && events[0].Version == recent[0].Version {
+				events = events[1:]
+			}
+			context["LineEvents"] = events
+		}
+	}
+	err = tmplBomHistory.Execute(w, context)
+	return
+}
+
+// Whether the logged in user (if any) may change bm: its owner can, and so can
+// anybody logged in if it's publicly editable.
+func canEdit(session *sessions.Session, bm *BomMeta) bool {
+	user, ok := session.Values["UserName"].(string)
+	if !ok || user == "" {
+		return false
+	}
+	return user == bm.Owner || bm.IsPublicEdit
+}
+
+// Public editors can add and revert versions and suppress lint rules, but only
+// the owner can throw versions away, hide the BOM or give it away.
+func canManage(session *sessions.Session, bm *BomMeta, action string) bool {
+	switch action {
+	case "delete", "archive", "unarchive", "transfer":
+		user, ok := session.Values["UserName"].(string)
+		return ok && user != "" && user == bm.Owner
+	}
+	return canEdit(session, bm)
+}
+
+// Handles the delete/archive/unarchive/revert buttons on the history page.
+func bomManageController(w http.ResponseWriter, r *http.Request, user, name string) (err error) {
+	session, _ := store.Get(r, "bommom")
+	if r.Method != "POST" {
+		http.Error(w, "405 POST only", 405)
+		return
+	}
+	if !isShortName(user) || !isShortName(name) {
+		http.Error(w, "invalid name: "+user+"/"+name, 400)
+		return
+	}
+	bm, err := bomstore.GetBomMeta(ShortName(user), ShortName(name))
+	if err != nil {
+		httpStoreError(w, "couldn't open bom "+user+"/"+name, err)
+		return nil
+	}
+	if !canEdit(session, bm) {
+		http.Error(w, "403 not allowed to change "+user+"/"+name, 403)
+		return nil
+	}
+	version := r.FormValue("version")
+	action := r.FormValue("action")
+	if !canManage(session, bm, action) {
+		http.Error(w, "403 only the owner can "+action+" "+user+"/"+name, 403)
+		return nil
+	}
+	switch action {
+	case "delete", "revert":
+		if !isShortName(version) {
+			http.Error(w, "invalid version: "+version, 400)
+			return nil
+		}
+		if action == "delete" {
+			err = bomstore.DeleteVersion(ShortName(user), ShortName(name), ShortName(version))
+		} else {
+			err = bomstore.SetHead(ShortName(user), ShortName(name), ShortName(version))
+		}
+	case "archive", "unarchive":
+		err = bomstore.SetArchived(ShortName(user), ShortName(name), action == "archive")
+	case "suppress", "unsuppress":
+		var suppress []string
+		entry := []string{r.FormValue("suppression")}
+		if action == "suppress" {
+			suppress, err = EditLintSuppress(bm.LintSuppress, entry, nil)
+		} else {
+			suppress, err = EditLintSuppress(bm.LintSuppress, nil, entry)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return nil
+		}
+		if err = bomstore.SetLintSuppress(ShortName(user), ShortName(name), suppress); err == nil {
+			http.Redirect(w, r, "/"+user+"/"+name+"/", 302)
+			return nil
+		}
+	case "transfer":
+		newOwner := r.FormValue("new_owner")
+		if !isShortName(newOwner) {
+			http.Error(w, "invalid username: "+newOwner, 400)
+			return nil
+		}
+		if err = bomstore.TransferOwnership(ShortName(user), ShortName(name), ShortName(newOwner)); err == nil {
+			http.Redirect(w, r, "/"+newOwner+"/"+name+"/", 302)
+			return nil
+		}
+	default:
+		http.Error(w, "unknown action: "+action, 400)
+		return nil
+	}
+	if err != nil {
+		httpStoreError(w, "couldn't "+r.FormValue("action")+" "+user+"/"+name, err)
+		return nil
+	}
+	http.Redirect(w, r, "/"+user+"/"+name+"/_history/", 302)
+	return nil
+}
+
+// Forks a BOM (or copies it, with history) into the logged in user's own
+// namespace.
+func bomForkController(w http.ResponseWriter, r *http.Request, user, name string) (err error) {
+	session, _ := store.Get(r, "bommom")
+	if r.Method != "POST" {
+		http.Error(w, "405 POST only", 405)
+		return
+	}
+	newOwner, ok := session.Values["UserName"].(string)
+	if !ok || newOwner == "" {
+		http.Error(w, "403 log in to fork", 403)
+		return
+	}
+	newName, version := r.FormValue("new_name"), r.FormValue("version")
+	if newName == "" {
+		newName = name
+	}
+	for _, s := range []string{user, name, newOwner, newName} {
+		if !isShortName(s) {
+			http.Error(w, "invalid name: "+s, 400)
+			return
+		}
+	}
+	if version != "" && !isShortName(version) {
+		http.Error(w, "invalid version: "+version, 400)
+		return
+	}
+	if r.FormValue("history") != "" {
+		err = bomstore.CopyWithHistory(ShortName(user), ShortName(name), ShortName(newOwner), ShortName(newName))
+	} else {
+		err = bomstore.Fork(ShortName(user), ShortName(name), ShortName(version), ShortName(newOwner), ShortName(newName))
+	}
+	if err != nil {
+		httpStoreError(w, "couldn't fork "+user+"/"+name, err)
+		return nil
+	}
+	http.Redirect(w, r, "/"+newOwner+"/"+newName+"/", 302)
+	return nil
+}
+
+func bomDiffController(w http.ResponseWriter, r *http.Request, user, name, v1, v2 string) (err error) {
+	session, _ := store.Get(r, "bommom")
+	for _, s := range []string{user, name, v1, v2} {
+		if !isShortName(s) {
+			http.Error(w, "invalid name: "+s, 400)
+			return
+		}
+	}
+
+	context := make(map[string]interface{})
+	context["Session"] = session.Values
+	bm, err := bomstore.GetBomMeta(ShortName(user), ShortName(name))
+	if err != nil {
+		httpStoreError(w, "couldn't open bom "+user+"/"+name, err)
+		return nil
+	}
+	before, err := bomstore.GetBom(ShortName(user), ShortName(name), ShortName(v1))
+	if err != nil {
+		httpStoreError(w, "couldn't open version "+v1, err)
+		return nil
+	}
+	after, err := bomstore.GetBom(ShortName(user), ShortName(name), ShortName(v2))
+	if err != nil {
+		httpStoreError(w, "couldn't open version "+v2, err)
+		return nil
+	}
+	context["BomMeta"] = bm
+	context["Diff"] = DiffBoms(before, after)
+	err = tmplBomDiff.Execute(w, context)
+	return
+}
+
 func bomUploadController(w http.ResponseWriter, r *http.Request, user, name string) (err error) {
 	session, _ := store.Get(r, "bommom")
 
@@ -178,6 +532,11 @@ func bomUploadController(w http.ResponseWriter, r *http.Request, user, name stri
 	context["user"] = ShortName(user)
 	context["name"] = ShortName(name)
 	context["BomMeta"], context["Bom"], err = bomstore.GetHead(ShortName(user), ShortName(name))
+	if err != nil && StoreErrorKind(err) != ErrNotFound {
+		// a new BOM is fine, but don't paper over a broken one
+		httpStoreError(w, "couldn't open bom "+user+"/"+name, err)
+		return nil
+	}
 
 	switch r.Method {
 	case "POST":
@@ -210,11 +569,11 @@ func bomUploadController(w http.ResponseWriter, r *http.Request, user, name stri
 
 		//contentType := fileheader.Header["Content-Type"][0]
 		var b *Bom
-		var bm *BomMeta
+		var uploaded *BomMeta
 
 		switch filepath.Ext(fileheader.Filename) {
 		case ".json":
-			bm, b, err = LoadBomFromJSON(file)
+			uploaded, b, err = LoadBomFromJSON(file)
 			if err != nil {
 				context["error"] = "Problem loading JSON file"
 				err = tmplBomUpload.Execute(w, context)
@@ -222,14 +581,13 @@ func bomUploadController(w http.ResponseWriter, r *http.Request, user, name stri
 			}
 		case ".csv":
 			b, err = LoadBomFromCSV(file)
-			bm = &BomMeta{}
 			if err != nil {
 				context["error"] = "Problem loading CSV file: " + err.Error()
 				err = tmplBomUpload.Execute(w, context)
 				return err
 			}
 		case ".xml":
-			bm, b, err = LoadBomFromXML(file)
+			uploaded, b, err = LoadBomFromXML(file)
 			if err != nil {
 				context["error"] = "Problem loading XML file"
 				err = tmplBomUpload.Execute(w, context)
@@ -237,21 +595,91 @@ func bomUploadController(w http.ResponseWriter, r *http.Request, user, name stri
 			}
 		default:
 			context["error"] = "Unknown file type: " + string(fileheader.Filename)
-			log.Fatal(context["error"])
 			err = tmplBomUpload.Execute(w, context)
 			return err
 		}
-		bm.Owner = user
-		bm.Name = name
+		CanonicalizeManufacturers(b)
+		carryComments := r.FormValue("keep_comments") != ""
+		consolidated, consolidationNotes := 0, []ConsolidationNote{}
+		if r.FormValue("consolidate") != "" {
+			consolidated, consolidationNotes = ConsolidateBom(b)
+		}
+		ClassifyBom(b)
+		bm, metaChanged, err := metaForUpload(bomstore, uploaded, ShortName(user), ShortName(name))
+		if err != nil {
+			httpStoreError(w, "couldn't open bom "+user+"/"+name, err)
+			return nil
+		}
 		b.Progeny = "File uploaded from " + fileheader.Filename
+		// the form records which version the upload was based on; if the head
+		// has moved on since then, merge instead of clobbering those changes
+		baseVersion := r.FormValue("base_version")
+		head, _ := context["Bom"].(*Bom)
+		if head != nil && baseVersion != "" && baseVersion != head.Version {
+			if !isShortName(baseVersion) {
+				http.Error(w, "invalid base version: "+baseVersion, 400)
+				return nil
+			}
+			base, err := bomstore.GetBom(ShortName(user), ShortName(name), ShortName(baseVersion))
+			if err != nil {
+				context["error"] = "Problem loading base version " + baseVersion + ": " + err.Error()
+				err = tmplBomUpload.Execute(w, context)
+				return err
+			}
+			AssignLineUids(b, base)
+			if carryComments {
+				CarryLineComments(b, base)
+			}
+			merged, conflicts := MergeBoms(base, head, b)
+			if len(conflicts) > 0 {
+				context["error"] = "This upload was based on " + baseVersion + ", but the head is now " + head.Version + " and the changes conflict"
+				context["Conflicts"] = conflicts
+				context["version"] = versionStr
+				err = tmplBomUpload.Execute(w, context)
+				return err
+			}
+			merged.Progeny = b.Progeny + ", merged with " + head.Version + " (based on " + baseVersion + ")"
+			b = merged
+		}
+		AssignLineUids(b, head)
+		if carryComments {
+			CarryLineComments(b, head)
+		}
+		if head != nil && b.ContentHash() == head.ContentHash() {
+			log.Println("upload identical to head " + head.Version + ", not saving a new version")
+			if metaChanged || len(consolidationNotes) > 0 {
+				context["notice"] = "The parts list is identical to " + head.Version + ", so no new version was saved."
+				if metaChanged {
+					// there's no version to record them with
+					context["notice"] = context["notice"].(string) + " The description and homepage in the file were not saved either."
+				}
+				context["ConsolidationNotes"] = consolidationNotes
+				err = tmplBomUpload.Execute(w, context)
+				return err
+			}
+			http.Redirect(w, r, "/"+user+"/"+name+"/", 302)
+			return nil
+		}
 		b.Created = time.Now()
 		b.Version = string(versionStr)
-		if err := bomstore.Persist(bm, b, ShortName(versionStr)); err != nil {
+		author, _ := session.Values["UserName"].(string)
+		if err := PersistAs(bomstore, ShortName(author), bm, b, ShortName(versionStr)); err != nil {
 			context["error"] = "Problem saving to datastore: " + err.Error()
+			w.WriteHeader(storeErrorStatus(err))
 			err = tmplBomUpload.Execute(w, context)
+			return err
 		}
-		http.Redirect(w, r, "//"+user+"/"+name+"/", 302)
-		return err
+		if len(consolidationNotes) > 0 {
+			// the BOM page has nowhere to show what consolidating dropped
+			context["notice"] = fmt.Sprintf("Saved as %s, with %d duplicate lines merged.", versionStr, consolidated)
+			context["ConsolidationNotes"] = consolidationNotes
+			context["BomMeta"], context["Bom"] = bm, b
+			context["version"] = ""
+			err = tmplBomUpload.Execute(w, context)
+			return err
+		}
+		http.Redirect(w, r, "/"+user+"/"+name+"/", 302)
+		return nil
 	case "GET":
 		err = tmplBomUpload.Execute(w, context)
 		return err
@@ -274,6 +702,8 @@ func serveCmd() {
 	tmplUser = template.Must(template.ParseFiles(*templatePath+"/user.html", baseTmplPath))
 	tmplBomView = template.Must(template.ParseFiles(*templatePath+"/bom_view.html", baseTmplPath))
 	tmplBomUpload = template.Must(template.ParseFiles(*templatePath+"/bom_upload.html", baseTmplPath))
+	tmplBomDiff = template.Must(template.ParseFiles(*templatePath+"/bom_diff.html", baseTmplPath))
+	tmplBomHistory = template.Must(template.ParseFiles(*templatePath+"/bom_history.html", baseTmplPath))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// Points the handlers at a fresh MemoryBomStore, with tester/widget (v1, v2)
+// and an archived tester/gadget in it. Call the returned function to put the
+// old store back.
+func useTestStore(t *testing.T) func() {
+	old := bomstore
+	ms := NewMemoryBomStore()
+	for _, name := range []string{"widget", "gadget"} {
+		bm, b := makeTestBom()
+		bm.Owner, bm.Name = "tester", name
+		for _, v := range []string{"v1", "v2"} {
+			if err := ms.Persist(bm, b, ShortName(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ms.SetArchived("tester", "gadget", true)
+	bomstore = ms
+	tmplUser = template.Must(template.ParseFiles("templates/user.html", "templates/base.html"))
+	tmplBomHistory = template.Must(template.ParseFiles("templates/bom_history.html", "templates/base.html"))
+	tmplBomUpload = template.Must(template.ParseFiles("templates/bom_upload.html", "templates/base.html"))
+	return func() { bomstore = old }
+}
+
+func serveTestRequest(method, path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if method == "POST" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	baseHandler(w, r)
+	return w
+}
+
+func TestHandlerStatus(t *testing.T) {
+	defer useTestStore(t)()
+
+	tests := []struct {
+		method, path string
+		form         url.Values
+		status       int
+	}{
+		{"GET", "/tester/", nil, 200},
+		{"GET", "/tester/widget/_history/", nil, 200},
+		{"GET", "/tester/nothing/_history/", nil, 404},
+		{"GET", "/tester/nothing/", nil, 404},
+		{"GET", "/tester/widget/v9/", nil, 404},
+		{"GET", "/tester/widget/_manage/", nil, 405},
+		{"POST", "/tester/nothing/_manage/", url.Values{"action": {"archive"}}, 404},
+		// not logged in
+		{"POST", "/tester/widget/_manage/", url.Values{"action": {"delete"}, "version": {"v1"}}, 403},
+		{"POST", "/tester/widget/_fork/", nil, 403},
+	}
+	for _, test := range tests {
+		w := serveTestRequest(test.method, test.path, test.form)
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d: %s", test.method, test.path, test.status, w.Code, w.Body.String())
+		}
+	}
+	if versions, _ := bomstore.ListVersions("tester", "widget"); len(versions) != 2 {
+		t.Errorf("refused requests changed the store: %+v", versions)
+	}
+}
+
+func TestHandlerPages(t *testing.T) {
+	defer useTestStore(t)()
+
+	body := serveTestRequest("GET", "/tester/widget/_history/", nil).Body.String()
+	if !strings.Contains(body, "v1") || !strings.Contains(body, "v2") {
+		t.Errorf("history page missing versions")
+	}
+	if body := serveTestRequest("GET", "/tester/", nil).Body.String(); !strings.Contains(body, "widget") || strings.Contains(body, "gadget") {
+		t.Errorf("user page should list only the unarchived bom")
+	}
+	if body := serveTestRequest("GET", "/tester/?archived=1", nil).Body.String(); !strings.Contains(body, "gadget") {
+		t.Errorf("user page should list the archived bom when asked")
+	}
+}
+
+func TestHandlerLineHistory(t *testing.T) {
+	defer useTestStore(t)()
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	start := time.Now()
+	for i := 3; i <= lineHistoryVersions+5; i++ {
+		b.Created = start.Add(time.Duration(i) * time.Minute)
+		b.LineItems[0].Comment = fmt.Sprintf("take %d", i)
+		mustStore(t, bomstore.Persist(bm, b, ShortName(fmt.Sprintf("v%d", i))))
+	}
+
+	body := serveTestRequest("GET", "/tester/widget/_history/?line=W1", nil).Body.String()
+	if !strings.Contains(body, "5 older versions not shown") {
+		t.Errorf("expected a note about the versions left out")
+	}
+	// the first version shown is compared with the one before, rather than
+	// everything in it being "added"
+	if strings.Contains(body, ">added") || !strings.Contains(body, "take 5 &rarr; take 6") {
+		t.Errorf("unexpected line history: %s", body)
+	}
+	if strings.Contains(body, "take 4 &rarr; take 5") {
+		t.Errorf("older versions shouldn't be shown")
+	}
+}
+
+func TestCanManage(t *testing.T) {
+	bm := &BomMeta{Owner: "tester", Name: "widget", IsPublicEdit: true}
+	login := func(user string) *sessions.Session {
+		return &sessions.Session{Values: map[interface{}]interface{}{"UserName": user}}
+	}
+	for _, action := range []string{"revert", "suppress", "unsuppress"} {
+		if !canManage(login("alice"), bm, action) {
+			t.Errorf("public editor should be able to %s", action)
+		}
+	}
+	for _, action := range []string{"delete", "archive", "unarchive", "transfer"} {
+		if canManage(login("alice"), bm, action) || canManage(login(""), bm, action) {
+			t.Errorf("only the owner should be able to %s", action)
+		}
+		if !canManage(login("tester"), bm, action) {
+			t.Errorf("owner should be able to %s", action)
+		}
+	}
+}
+
+// Uploading a new version keeps what the store knows about the BOM, rather
+// than replacing it with whatever meta the file had (for CSV, none).
+func TestHandlerUploadToFork(t *testing.T) {
+	defer useTestStore(t)()
+	if err := bomstore.Fork("tester", "widget", "", "alice", "widget"); err != nil {
+		t.Fatal(err)
+	}
+	bomstore.SetArchived("alice", "widget", true)
+
+	_, b := makeTestBom()
+	b.LineItems = b.LineItems[:1]
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("version", "v3")
+	fw, _ := mw.CreateFormFile("bomfile", "widget.csv")
+	DumpBomAsCSV(b, fw)
+	mw.Close()
+	r := httptest.NewRequest("POST", "/alice/widget/_upload/", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	baseHandler(w, r)
+	if w.Code != 302 {
+		t.Fatalf("upload failed: %d %s", w.Code, w.Body.String())
+	}
+
+	bm, err := bomstore.GetBomMeta("alice", "widget")
+	if err != nil || bm.HeadVersion != "v3" {
+		t.Fatalf("upload not saved: %+v %v", bm, err)
+	}
+	if !bm.ForkOf.Is("tester", "widget") || !bm.IsArchived || len(bm.Hashes) != 2 {
+		t.Errorf("upload lost stored meta: %+v", bm)
+	}
+	// archived forks aren't listed
+	bomstore.SetArchived("alice", "widget", false)
+	if forks, _ := ListForks(bomstore, "tester", "widget"); len(forks) != 1 {
+		t.Errorf("fork missing from the source's forks after upload: %+v", forks)
+	}
+}
+
+// What consolidating couldn't keep is shown after the upload, since the BOM
+// page has nowhere to show it.
+func TestHandlerUploadConsolidationNotes(t *testing.T) {
+	defer useTestStore(t)()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("version", "v3")
+	mw.WriteField("consolidate", "yes")
+	fw, _ := mw.CreateFormFile("bomfile", "widget.csv")
+	fw.Write([]byte("qty,symbols,manufacturer,mpn,description,comment\n" +
+		"1,R1,Yageo,RC0603-10K,10k resistor,\n" +
+		"1,R2,Yageo,RC0603-10K,10k 1% resistor,\n"))
+	mw.Close()
+	r := httptest.NewRequest("POST", "/tester/widget/_upload/", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	baseHandler(w, r)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "10k 1% resistor") {
+		t.Errorf("expected the dropped description to be shown: %d %s", w.Code, w.Body.String())
+	}
+	if _, b, err := bomstore.GetHead("tester", "widget"); err != nil || b.Version != "v3" || len(b.LineItems) != 1 {
+		t.Errorf("consolidated upload not saved: %v", err)
+	}
+}
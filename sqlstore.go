@@ -0,0 +1,822 @@
+package main
+
+// BomStore backend on database/sql, using SQLite by default. Each version's
+// full JSON document is kept (it's what gets hashed and handed back), and its
+// line items, elements, alternates and offers are also broken out into their
+// own tables so they can be queried across every BOM; see WhereUsed(),
+// PartStats() and PartOffers().
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Schema changes, applied in order; the number applied so far is kept in
+// schema_version. Only ever append to this list.
+var sqlMigrations = []string{
+	`CREATE TABLE boms (
+		id INTEGER PRIMARY KEY,
+		owner TEXT NOT NULL,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		homepage TEXT NOT NULL DEFAULT '',
+		head_version TEXT NOT NULL DEFAULT '',
+		is_public_view BOOLEAN NOT NULL DEFAULT 0,
+		is_public_edit BOOLEAN NOT NULL DEFAULT 0,
+		is_archived BOOLEAN NOT NULL DEFAULT 0,
+		lint_suppress TEXT NOT NULL DEFAULT '',
+		fork_of_owner TEXT NOT NULL DEFAULT '',
+		fork_of_name TEXT NOT NULL DEFAULT '',
+		fork_of_version TEXT NOT NULL DEFAULT '',
+		UNIQUE (owner, name)
+	);
+	CREATE TABLE versions (
+		id INTEGER PRIMARY KEY,
+		bom_id INTEGER NOT NULL REFERENCES boms(id) ON DELETE CASCADE,
+		version TEXT NOT NULL,
+		created TEXT NOT NULL,
+		progeny TEXT NOT NULL DEFAULT '',
+		hash TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL,
+		UNIQUE (bom_id, version)
+	);
+	CREATE TABLE line_items (
+		id INTEGER PRIMARY KEY,
+		version_id INTEGER NOT NULL REFERENCES versions(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		uid TEXT NOT NULL DEFAULT '',
+		manufacturer TEXT NOT NULL DEFAULT '',
+		mpn TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		form_factor TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		lifecycle TEXT NOT NULL DEFAULT '',
+		rohs TEXT NOT NULL DEFAULT '',
+		reach TEXT NOT NULL DEFAULT '',
+		lead_weeks INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX line_items_version ON line_items (version_id);
+	CREATE INDEX line_items_part ON line_items (manufacturer, mpn);
+	CREATE TABLE elements (
+		id INTEGER PRIMARY KEY,
+		line_item_id INTEGER NOT NULL REFERENCES line_items(id) ON DELETE CASCADE,
+		designator TEXT NOT NULL DEFAULT '',
+		value TEXT NOT NULL DEFAULT '',
+		dnp BOOLEAN NOT NULL DEFAULT 0
+	);
+	CREATE INDEX elements_line_item ON elements (line_item_id);
+	CREATE TABLE offers (
+		id INTEGER PRIMARY KEY,
+		line_item_id INTEGER NOT NULL REFERENCES line_items(id) ON DELETE CASCADE,
+		distributor TEXT NOT NULL DEFAULT '',
+		sku TEXT NOT NULL DEFAULT '',
+		available INTEGER NOT NULL DEFAULT 0,
+		lead_weeks INTEGER NOT NULL DEFAULT 0,
+		prices TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX offers_line_item ON offers (line_item_id);`,
+	// for ListForks
+	`CREATE INDEX boms_fork_of ON boms (fork_of_owner, fork_of_name);`,
+	// alternates (with their own offers), and price breaks in rows rather
+	// than JSON; the line tables are rebuilt from each version's content
+	`DELETE FROM elements;
+	DELETE FROM offers;
+	DELETE FROM line_items;
+	CREATE TABLE alternates (
+		id INTEGER PRIMARY KEY,
+		line_item_id INTEGER NOT NULL REFERENCES line_items(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		manufacturer TEXT NOT NULL DEFAULT '',
+		mpn TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT '',
+		comment TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX alternates_line_item ON alternates (line_item_id);
+	CREATE INDEX alternates_part ON alternates (manufacturer, mpn);
+	ALTER TABLE offers DROP COLUMN prices;
+	ALTER TABLE offers ADD COLUMN alternate_id INTEGER REFERENCES alternates(id) ON DELETE CASCADE;
+	CREATE INDEX offers_alternate ON offers (alternate_id);
+	CREATE TABLE offer_prices (
+		id INTEGER PRIMARY KEY,
+		offer_id INTEGER NOT NULL REFERENCES offers(id) ON DELETE CASCADE,
+		currency TEXT NOT NULL DEFAULT '',
+		min_qty INTEGER NOT NULL DEFAULT 0,
+		price INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX offer_prices_offer ON offer_prices (offer_id);`,
+}
+
+// Migrations up to this one changed how lines are broken out, so databases
+// from before it have their line tables rebuilt; see relineVersions().
+const sqlLinesMigration = 3
+
+type SQLBomStore struct {
+	db *sql.DB
+}
+
+// Opens (creating if necessary) an SQLite database file as a BomStore.
+func OpenSQLiteBomStore(fpath string) (*SQLBomStore, error) {
+	// immediate transactions and a busy timeout let 'serve' and the CLI
+	// share a database file
+	return OpenSQLBomStore("sqlite3", "file:"+fpath+"?_foreign_keys=on&_busy_timeout=10000&_txlock=immediate")
+}
+
+func OpenSQLBomStore(driver, dsn string) (*SQLBomStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLBomStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLBomStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLBomStore) migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	applied := 0
+	if err := tx.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&applied); err != nil {
+		return err
+	}
+	if applied == 0 {
+		if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES (0)"); err != nil {
+			return err
+		}
+	} else if err := tx.QueryRow("SELECT version FROM schema_version").Scan(&applied); err != nil {
+		return err
+	}
+	if applied > len(sqlMigrations) {
+		return Error("database schema is newer than this version of bommom")
+	}
+	for i := applied; i < len(sqlMigrations); i++ {
+		if _, err := tx.Exec(sqlMigrations[i]); err != nil {
+			return Error("schema migration " + strconv.Itoa(i+1) + " failed: " + err.Error())
+		}
+	}
+	if applied > 0 && applied < sqlLinesMigration {
+		if err := relineVersions(tx); err != nil {
+			return Error("rebuilding line tables failed: " + err.Error())
+		}
+	}
+	if _, err := tx.Exec("UPDATE schema_version SET version = ?", len(sqlMigrations)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Breaks out the lines of every version again, from its content. Versions
+// which can't be parsed are left without lines; GetBom() will report them.
+func relineVersions(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, content FROM versions")
+	if err != nil {
+		return err
+	}
+	ids := []int64{}
+	contents := []string{}
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		contents = append(contents, content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		b := Bom{}
+		if json.Unmarshal([]byte(contents[i]), &b) != nil {
+			continue
+		}
+		if err := insertLineItems(tx, id, &b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Either the database or a transaction.
+type sqlQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+const sqlBomMetaColumns = `id, owner, name, description, homepage, head_version, is_public_view,
+	is_public_edit, is_archived, lint_suppress, fork_of_owner, fork_of_name, fork_of_version`
+
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBomMeta(row sqlScanner) (int64, *BomMeta, error) {
+	var id int64
+	var homepage, lintSuppress, forkOwner, forkName, forkVersion string
+	bm := &BomMeta{}
+	err := row.Scan(&id, &bm.Owner, &bm.Name, &bm.Description, &homepage, &bm.HeadVersion,
+		&bm.IsPublicView, &bm.IsPublicEdit, &bm.IsArchived, &lintSuppress, &forkOwner, &forkName, &forkVersion)
+	if err != nil {
+		return 0, nil, err
+	}
+	bm.Homepage = Url(homepage)
+	if lintSuppress != "" {
+		bm.LintSuppress = strings.Split(lintSuppress, "\n")
+	}
+	if forkOwner != "" {
+		bm.ForkOf = &BomRef{Owner: forkOwner, Name: forkName, Version: forkVersion}
+	}
+	return id, bm, nil
+}
+
+func (s *SQLBomStore) getBomMeta(q sqlQueryer, user, name ShortName) (int64, *BomMeta, error) {
+	if err := checkNames(user, name); err != nil {
+		return 0, nil, err
+	}
+	row := q.QueryRow("SELECT "+sqlBomMetaColumns+" FROM boms WHERE owner = ? AND name = ?", user, name)
+	id, bm, err := scanBomMeta(row)
+	if err == sql.ErrNoRows {
+		return 0, nil, storeError(ErrNotFound, "no such bom: "+string(user)+"/"+string(name), nil)
+	} else if err != nil {
+		return 0, nil, err
+	}
+	if bm.Hashes, err = s.bomHashes(q, id); err != nil {
+		return 0, nil, err
+	}
+	return id, bm, nil
+}
+
+func (s *SQLBomStore) bomHashes(q sqlQueryer, id int64) (map[string]string, error) {
+	rows, err := q.Query("SELECT version, hash FROM versions WHERE bom_id = ? AND hash != ''", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var version, hash string
+		if err := rows.Scan(&version, &hash); err != nil {
+			return nil, err
+		}
+		hashes[version] = hash
+	}
+	return hashes, rows.Err()
+}
+
+func (s *SQLBomStore) GetBomMeta(user, name ShortName) (*BomMeta, error) {
+	_, bm, err := s.getBomMeta(s.db, user, name)
+	return bm, err
+}
+
+func (s *SQLBomStore) GetHead(user, name ShortName) (*BomMeta, *Bom, error) {
+	bm, err := s.GetBomMeta(user, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bm.HeadVersion == "" {
+		return nil, nil, storeError(ErrCorrupt, "no head version for "+string(user)+"/"+string(name), nil)
+	}
+	b, err := s.GetBom(user, name, ShortName(bm.HeadVersion))
+	return bm, b, err
+}
+
+func (s *SQLBomStore) GetBom(user, name, version ShortName) (*Bom, error) {
+	return s.getBom(s.db, user, name, version)
+}
+
+func (s *SQLBomStore) getBom(q sqlQueryer, user, name, version ShortName) (*Bom, error) {
+	if err := checkNames(user, name, version); err != nil {
+		return nil, err
+	}
+	var content, hash string
+	err := q.QueryRow(`SELECT v.content, v.hash FROM versions v JOIN boms b ON v.bom_id = b.id
+		WHERE b.owner = ? AND b.name = ? AND v.version = ?`, user, name, version).Scan(&content, &hash)
+	if err == sql.ErrNoRows {
+		return nil, storeError(ErrNotFound, "no such version: "+string(version), nil)
+	} else if err != nil {
+		return nil, err
+	}
+	b := Bom{}
+	if err := json.Unmarshal([]byte(content), &b); err != nil {
+		return nil, storeError(ErrCorrupt, "couldn't parse "+string(user)+"/"+string(name)+"/"+string(version), err)
+	}
+	if b.Version != string(version) {
+		return nil, storeError(ErrCorrupt, "bom row for "+string(version)+" has version "+b.Version, nil)
+	}
+	bm := &BomMeta{Owner: string(user), Name: string(name), Hashes: map[string]string{}}
+	if hash != "" {
+		bm.Hashes[string(version)] = hash
+	}
+	if err := VerifyBom(bm, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (s *SQLBomStore) ListBoms(user ShortName, includeArchived bool) ([]BomMeta, error) {
+	if user != "" {
+		if err := checkNames(user); err != nil {
+			return nil, err
+		}
+	}
+	return s.listBoms(`(? = '' OR owner = ?) AND (? OR NOT is_archived)`, user, user, includeArchived)
+}
+
+// Unarchived BOMs forked or copied from user/name, found by the fork_of index
+// rather than reading every BOM.
+func (s *SQLBomStore) ListForks(user, name ShortName) ([]BomMeta, error) {
+	if err := checkNames(user, name); err != nil {
+		return nil, err
+	}
+	return s.listBoms(`fork_of_owner = ? AND fork_of_name = ? AND NOT is_archived`, user, name)
+}
+
+func (s *SQLBomStore) listBoms(where string, args ...interface{}) ([]BomMeta, error) {
+	rows, err := s.db.Query("SELECT "+sqlBomMetaColumns+" FROM boms WHERE "+where+" ORDER BY owner, name", args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	bmList := []BomMeta{}
+	for rows.Next() {
+		id, bm, err := scanBomMeta(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		bmList = append(bmList, *bm)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if bmList[i].Hashes, err = s.bomHashes(s.db, id); err != nil {
+			return nil, err
+		}
+	}
+	return bmList, nil
+}
+
+func (s *SQLBomStore) ListVersions(user, name ShortName) ([]BomVersion, error) {
+	id, _, err := s.getBomMeta(s.db, user, name)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT v.version, v.created, v.progeny, v.hash,
+		(SELECT COUNT(*) FROM line_items l WHERE l.version_id = v.id),
+		(SELECT COUNT(*) FROM elements e JOIN line_items l ON e.line_item_id = l.id WHERE l.version_id = v.id)
+		FROM versions v WHERE v.bom_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []BomVersion{}
+	for rows.Next() {
+		var bv BomVersion
+		var created string
+		if err := rows.Scan(&bv.Version, &created, &bv.Progeny, &bv.Hash, &bv.Lines, &bv.Elements); err != nil {
+			return nil, err
+		}
+		if bv.Created, err = time.Parse(time.RFC3339Nano, created); err != nil {
+			return nil, storeError(ErrCorrupt, "bad timestamp for version "+bv.Version, err)
+		}
+		versions = append(versions, bv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortBomVersions(versions)
+	return versions, nil
+}
+
+func (s *SQLBomStore) Persist(bm *BomMeta, b *Bom, version ShortName) error {
+	b.Version = string(version)
+	bm.HeadVersion = string(version)
+	if err := bm.Validate(); err != nil {
+		return storeError(ErrInvalid, "invalid bom meta", err)
+	}
+	if err := b.Validate(); err != nil {
+		return storeError(ErrInvalid, "invalid bom", err)
+	}
+	content, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	hash := b.Hash()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var forkOwner, forkName, forkVersion string
+	if bm.ForkOf != nil {
+		forkOwner, forkName, forkVersion = bm.ForkOf.Owner, bm.ForkOf.Name, bm.ForkOf.Version
+	}
+	_, err = tx.Exec(`INSERT INTO boms (owner, name, description, homepage, head_version, is_public_view,
+			is_public_edit, is_archived, lint_suppress, fork_of_owner, fork_of_name, fork_of_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (owner, name) DO UPDATE SET description = excluded.description,
+			homepage = excluded.homepage, head_version = excluded.head_version,
+			is_public_view = excluded.is_public_view, is_public_edit = excluded.is_public_edit,
+			is_archived = excluded.is_archived, lint_suppress = excluded.lint_suppress,
+			fork_of_owner = excluded.fork_of_owner, fork_of_name = excluded.fork_of_name,
+			fork_of_version = excluded.fork_of_version`,
+		bm.Owner, bm.Name, bm.Description, string(bm.Homepage), bm.HeadVersion, bm.IsPublicView,
+		bm.IsPublicEdit, bm.IsArchived, strings.Join(bm.LintSuppress, "\n"), forkOwner, forkName, forkVersion)
+	if err != nil {
+		return err
+	}
+	var bomId int64
+	if err := tx.QueryRow("SELECT id FROM boms WHERE owner = ? AND name = ?", bm.Owner, bm.Name).Scan(&bomId); err != nil {
+		return err
+	}
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM versions WHERE bom_id = ? AND version = ?", bomId, version).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return storeError(ErrExists, "bom with same owner, name, and version already exists", nil)
+	}
+	res, err := tx.Exec("INSERT INTO versions (bom_id, version, created, progeny, hash, content) VALUES (?, ?, ?, ?, ?, ?)",
+		bomId, version, b.Created.UTC().Format(time.RFC3339Nano), b.Progeny, hash, string(content))
+	if err != nil {
+		return err
+	}
+	versionId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := insertLineItems(tx, versionId, b); err != nil {
+		return err
+	}
+	if bm.Hashes, err = s.bomHashes(tx, bomId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Breaks the lines of b out into the line_items, elements, alternates and
+// offers tables.
+func insertLineItems(tx *sql.Tx, versionId int64, b *Bom) error {
+	for i := range b.LineItems {
+		li := &b.LineItems[i]
+		res, err := tx.Exec(`INSERT INTO line_items (version_id, position, uid, manufacturer, mpn,
+				description, form_factor, category, lifecycle, rohs, reach, lead_weeks)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			versionId, i, li.Uid, CanonicalManufacturer(li.Manufacturer), li.Mpn, li.Description,
+			li.FormFactor, li.Category, li.Lifecycle, li.Rohs, li.Reach, li.LeadWeeks)
+		if err != nil {
+			return err
+		}
+		lineId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, el := range li.Elements {
+			if _, err := tx.Exec("INSERT INTO elements (line_item_id, designator, value, dnp) VALUES (?, ?, ?, ?)",
+				lineId, el.Id, el.Value, el.Dnp); err != nil {
+				return err
+			}
+		}
+		if err := insertOffers(tx, lineId, nil, li.Offers); err != nil {
+			return err
+		}
+		for j, alt := range li.Alternates {
+			res, err := tx.Exec(`INSERT INTO alternates (line_item_id, position, manufacturer, mpn, status, comment)
+				VALUES (?, ?, ?, ?, ?, ?)`, lineId, j, CanonicalManufacturer(alt.Manufacturer), alt.Mpn, alt.Status, alt.Comment)
+			if err != nil {
+				return err
+			}
+			altId, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			if err := insertOffers(tx, lineId, altId, alt.Offers); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// altId is nil for offers on the primary part of the line.
+func insertOffers(tx *sql.Tx, lineId int64, altId interface{}, offers []Offer) error {
+	for _, o := range offers {
+		res, err := tx.Exec(`INSERT INTO offers (line_item_id, alternate_id, distributor, sku, available, lead_weeks)
+			VALUES (?, ?, ?, ?, ?, ?)`, lineId, altId, o.Distributor, o.Sku, o.Available, o.LeadWeeks)
+		if err != nil {
+			return err
+		}
+		offerId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, op := range o.Prices {
+			if _, err := tx.Exec("INSERT INTO offer_prices (offer_id, currency, min_qty, price) VALUES (?, ?, ?, ?)",
+				offerId, op.Currency, op.MinQty, int64(op.Price)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQLBomStore) DeleteVersion(user, name, version ShortName) error {
+	if err := checkNames(user, name, version); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	id, bm, err := s.getBomMeta(tx, user, name)
+	if err != nil {
+		return err
+	}
+	if string(version) == bm.HeadVersion {
+		return storeError(ErrInvalid, "can't delete the head version ("+bm.HeadVersion+"); set a different head first", nil)
+	}
+	res, err := tx.Exec("DELETE FROM versions WHERE bom_id = ? AND version = ?", id, version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return storeError(ErrNotFound, "no such version: "+string(version), nil)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLBomStore) SetArchived(user, name ShortName, archived bool) error {
+	if err := checkNames(user, name); err != nil {
+		return err
+	}
+	res, err := s.db.Exec("UPDATE boms SET is_archived = ? WHERE owner = ? AND name = ?", archived, user, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return storeError(ErrNotFound, "no such bom: "+string(user)+"/"+string(name), nil)
+	}
+	return nil
+}
+
+func (s *SQLBomStore) SetLintSuppress(user, name ShortName, suppress []string) error {
+	if err := checkNames(user, name); err != nil {
+		return err
+	}
+	res, err := s.db.Exec("UPDATE boms SET lint_suppress = ? WHERE owner = ? AND name = ?", strings.Join(suppress, "\n"), user, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return storeError(ErrNotFound, "no such bom: "+string(user)+"/"+string(name), nil)
+	}
+	return nil
+}
+
+func (s *SQLBomStore) SetHead(user, name, version ShortName) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// also checks that the version exists and is intact
+	if _, err := s.getBom(tx, user, name, version); err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE boms SET head_version = ? WHERE owner = ? AND name = ?", version, user, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return storeError(ErrNotFound, "no such bom: "+string(user)+"/"+string(name), nil)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLBomStore) Fork(user, name, version, newOwner, newName ShortName) error {
+	return forkBom(s, user, name, version, newOwner, newName, false)
+}
+
+func (s *SQLBomStore) CopyWithHistory(user, name, newOwner, newName ShortName) error {
+	return forkBom(s, user, name, "", newOwner, newName, true)
+}
+
+func (s *SQLBomStore) TransferOwnership(user, name, newOwner ShortName) error {
+	if err := checkNames(user, name, newOwner); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, _, err := s.getBomMeta(tx, user, name); err != nil {
+		return err
+	}
+	if _, _, err := s.getBomMeta(tx, newOwner, name); err == nil {
+		return storeError(ErrExists, "bom already exists: "+string(newOwner)+"/"+string(name), nil)
+	} else if StoreErrorKind(err) != ErrNotFound {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE boms SET owner = ? WHERE owner = ? AND name = ?", newOwner, user, name); err != nil {
+		return err
+	}
+	// keep forks pointing at the right place
+	if _, err := tx.Exec("UPDATE boms SET fork_of_owner = ? WHERE fork_of_owner = ? AND fork_of_name = ?",
+		newOwner, user, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ---------------------- queries across BOMs ----------------------
+
+// One BOM whose head version uses a part.
+type PartUse struct {
+	Owner   string `json:"owner_name"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Qty     int    `json:"qty"`
+	// set when the part is an alternate on the line, rather than its primary
+	// part; Qty is then how many it could be fitted in place of
+	AltStatus string `json:"alternate_status,omitempty"`
+}
+
+// The head versions of unarchived BOMs, for the queries below.
+const sqlHeadVersions = `SELECT v.id AS version_id, b.id AS bom_id, b.owner, b.name, v.version
+	FROM versions v JOIN boms b ON v.bom_id = b.id AND v.version = b.head_version
+	WHERE NOT b.is_archived`
+
+// Every BOM whose head version has a line for the given part, either as its
+// primary part or as an alternate which hasn't been rejected.
+func (s *SQLBomStore) WhereUsed(manufacturer, mpn string) ([]PartUse, error) {
+	manufacturer = CanonicalManufacturer(manufacturer)
+	rows, err := s.db.Query(`WITH heads AS (`+sqlHeadVersions+`)
+		SELECT h.owner, h.name, h.version, '',
+			(SELECT COUNT(*) FROM elements e WHERE e.line_item_id = l.id)
+		FROM line_items l JOIN heads h ON l.version_id = h.version_id
+		WHERE l.manufacturer = ? AND l.mpn = ?
+		UNION ALL
+		SELECT h.owner, h.name, h.version, a.status,
+			(SELECT COUNT(*) FROM elements e WHERE e.line_item_id = l.id)
+		FROM alternates a JOIN line_items l ON a.line_item_id = l.id
+		JOIN heads h ON l.version_id = h.version_id
+		WHERE a.manufacturer = ? AND a.mpn = ? AND a.status != ?
+		ORDER BY 1, 2, 4`, manufacturer, mpn, manufacturer, mpn, AltRejected)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	uses := []PartUse{}
+	for rows.Next() {
+		var pu PartUse
+		if err := rows.Scan(&pu.Owner, &pu.Name, &pu.Version, &pu.AltStatus, &pu.Qty); err != nil {
+			return nil, err
+		}
+		uses = append(uses, pu)
+	}
+	return uses, rows.Err()
+}
+
+// How widely a part is used across the head versions of all BOMs.
+type PartStat struct {
+	Manufacturer string `json:"manufacturer"`
+	Mpn          string `json:"mpn"`
+	Boms         int    `json:"boms"`
+	Qty          int    `json:"qty"`
+	// BOMs listing it as an alternate which hasn't been rejected
+	AltBoms int `json:"alternate_boms"`
+}
+
+// The most widely used parts, by number of BOMs and then total quantity, and
+// then by how many BOMs have them as an alternate.
+func (s *SQLBomStore) PartStats(limit int) ([]PartStat, error) {
+	rows, err := s.db.Query(`WITH heads AS (`+sqlHeadVersions+`),
+		uses AS (
+			SELECT l.manufacturer, l.mpn, h.bom_id, 0 AS alt,
+				(SELECT COUNT(*) FROM elements e WHERE e.line_item_id = l.id) AS qty
+			FROM line_items l JOIN heads h ON l.version_id = h.version_id
+			UNION ALL
+			SELECT a.manufacturer, a.mpn, h.bom_id, 1, 0
+			FROM alternates a JOIN line_items l ON a.line_item_id = l.id
+			JOIN heads h ON l.version_id = h.version_id
+			WHERE a.status != ?)
+		SELECT manufacturer, mpn, COUNT(DISTINCT CASE WHEN alt = 0 THEN bom_id END), SUM(qty),
+			COUNT(DISTINCT CASE WHEN alt = 1 THEN bom_id END)
+		FROM uses WHERE mpn != ''
+		GROUP BY manufacturer, mpn
+		ORDER BY 3 DESC, 4 DESC, 5 DESC, manufacturer, mpn
+		LIMIT ?`, AltRejected, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := []PartStat{}
+	for rows.Next() {
+		var ps PartStat
+		if err := rows.Scan(&ps.Manufacturer, &ps.Mpn, &ps.Boms, &ps.Qty, &ps.AltBoms); err != nil {
+			return nil, err
+		}
+		stats = append(stats, ps)
+	}
+	return stats, rows.Err()
+}
+
+// An offer for a part, as recorded in the head version of a BOM.
+type PartOffer struct {
+	Owner string `json:"owner_name"`
+	Name  string `json:"name"`
+	Offer
+}
+
+// Every offer for the given part in the head versions of all BOMs, whether
+// it's on the line's primary part or on an alternate.
+func (s *SQLBomStore) PartOffers(manufacturer, mpn string) ([]PartOffer, error) {
+	manufacturer = CanonicalManufacturer(manufacturer)
+	rows, err := s.db.Query(`WITH heads AS (`+sqlHeadVersions+`)
+		SELECT o.id, h.owner, h.name, o.distributor, o.sku, o.available, o.lead_weeks
+		FROM offers o JOIN line_items l ON o.line_item_id = l.id
+		JOIN heads h ON l.version_id = h.version_id
+		LEFT JOIN alternates a ON o.alternate_id = a.id
+		WHERE (a.id IS NULL AND l.manufacturer = ? AND l.mpn = ?)
+			OR (a.manufacturer = ? AND a.mpn = ?)
+		ORDER BY h.owner, h.name, o.id`, manufacturer, mpn, manufacturer, mpn)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	offers := []PartOffer{}
+	for rows.Next() {
+		var id int64
+		var po PartOffer
+		if err := rows.Scan(&id, &po.Owner, &po.Name, &po.Distributor, &po.Sku, &po.Available, &po.LeadWeeks); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		offers = append(offers, po)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if offers[i].Prices, err = s.offerPrices(id); err != nil {
+			return nil, err
+		}
+	}
+	return offers, nil
+}
+
+func (s *SQLBomStore) offerPrices(offerId int64) ([]OfferPrice, error) {
+	rows, err := s.db.Query("SELECT currency, min_qty, price FROM offer_prices WHERE offer_id = ? ORDER BY min_qty", offerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	prices := []OfferPrice{}
+	for rows.Next() {
+		var op OfferPrice
+		var price int64
+		if err := rows.Scan(&op.Currency, &op.MinQty, &price); err != nil {
+			return nil, err
+		}
+		op.Price = Money(price)
+		prices = append(prices, op)
+	}
+	return prices, rows.Err()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestSQLStore(t *testing.T) (*SQLBomStore, func()) {
+	dir, err := ioutil.TempDir("", "bommom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenSQLiteBomStore(dir + "/test.db")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSQLStoreRoundTrip(t *testing.T) {
+	s, cleanup := openTestSQLStore(t)
+	defer cleanup()
+
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	bm.LintSuppress = []string{"no_offers", "case_duplicate:x"}
+	b.Created = time.Now()
+	if err := s.Persist(bm, b, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	b.LineItems = b.LineItems[1:]
+	if err := s.Persist(bm, b, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Persist(bm, b, "v2"); StoreErrorKind(err) != ErrExists {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+
+	gbm, gb, err := s.GetHead("tester", "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gbm.HeadVersion != "v2" || gbm.Description != bm.Description || len(gbm.LintSuppress) != 2 {
+		t.Errorf("unexpected meta: %+v", gbm)
+	}
+	if gb.Hash() != b.Hash() || len(gbm.Hashes) != 2 {
+		t.Errorf("head doesn't match what was persisted")
+	}
+	versions, err := s.ListVersions("tester", "widget")
+	if err != nil || len(versions) != 2 || versions[1].Version != "v2" {
+		t.Fatalf("unexpected versions: %+v %v", versions, err)
+	}
+	if versions[1].Lines != len(b.LineItems) || versions[1].Hash != gbm.Hashes["v2"] {
+		t.Errorf("unexpected version summary: %+v", versions[1])
+	}
+
+	// tampering is noticed, same as the file store
+	if _, err := s.db.Exec(`UPDATE versions SET content = replace(content, '"mpn":"', '"mpn":"X')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetBom("tester", "widget", "v1"); StoreErrorKind(err) != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+
+	if _, err := s.GetBom("tester", "widget", "v9"); StoreErrorKind(err) != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.DeleteVersion("tester", "widget", "v2"); StoreErrorKind(err) != ErrInvalid {
+		t.Errorf("expected ErrInvalid deleting head, got %v", err)
+	}
+	if err := s.DeleteVersion("tester", "widget", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	var lines int
+	s.db.QueryRow("SELECT COUNT(*) FROM line_items").Scan(&lines)
+	if lines != len(b.LineItems) {
+		t.Errorf("deleted version's lines left behind: %d", lines)
+	}
+}
+
+func TestSQLStoreMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bommom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := OpenSQLiteBomStore(dir + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	if err := s.Persist(bm, b, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// reopening doesn't re-run migrations or lose anything
+	s, err = OpenSQLiteBomStore(dir + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var version int
+	if err := s.db.QueryRow("SELECT version FROM schema_version").Scan(&version); err != nil || version != len(sqlMigrations) {
+		t.Errorf("unexpected schema version %d: %v", version, err)
+	}
+	if _, _, err := s.GetHead("tester", "widget"); err != nil {
+		t.Error(err)
+	}
+
+	s.db.Exec("UPDATE schema_version SET version = ?", len(sqlMigrations)+1)
+	if _, err := OpenSQLiteBomStore(dir + "/test.db"); err == nil {
+		t.Errorf("expected error opening a newer schema")
+	}
+}
+
+// Databases from before alternates were broken out get them from the
+// versions' content.
+func TestSQLStoreRelineMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bommom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", "file:"+dir+"/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b := makeTestBom()
+	b.Version = "v1"
+	content, _ := json.Marshal(b)
+	stmts := append([]string{}, sqlMigrations[:sqlLinesMigration-1]...)
+	stmts = append(stmts, "CREATE TABLE schema_version (version INTEGER NOT NULL)",
+		"INSERT INTO schema_version (version) VALUES (2)",
+		"INSERT INTO boms (id, owner, name, head_version) VALUES (1, 'tester', 'widget', 'v1')")
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO versions (bom_id, version, created, content) VALUES (1, 'v1', ?, ?)",
+		b.Created.UTC().Format(time.RFC3339Nano), string(content)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := OpenSQLiteBomStore(dir + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	alt := b.LineItems[0].Alternates[0]
+	if uses, err := s.WhereUsed(alt.Manufacturer, alt.Mpn); err != nil || len(uses) != 1 {
+		t.Errorf("alternate not broken out: %+v %v", uses, err)
+	}
+	if versions, _ := s.ListVersions("tester", "widget"); len(versions) != 1 || versions[0].Lines != len(b.LineItems) {
+		t.Errorf("lines not broken out: %+v", versions)
+	}
+}
+
+func TestSQLStoreQueries(t *testing.T) {
+	s, cleanup := openTestSQLStore(t)
+	defer cleanup()
+
+	for _, name := range []string{"widget", "gadget", "gizmo"} {
+		bm, b := makeTestBom()
+		bm.Owner, bm.Name = "tester", name
+		if name == "gizmo" {
+			b.LineItems = b.LineItems[:1]
+		}
+		if err := s.Persist(bm, b, "v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SetArchived("tester", "gadget", true)
+
+	_, b := makeTestBom()
+	li := b.LineItems[0]
+	uses, err := s.WhereUsed(li.Manufacturer, li.Mpn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uses) != 2 || uses[0].Name != "gizmo" || uses[1].Name != "widget" || uses[0].Qty != len(li.Elements) {
+		t.Errorf("unexpected where-used: %+v", uses)
+	}
+	if uses, _ := s.WhereUsed("Nobody", "nothing"); len(uses) != 0 {
+		t.Errorf("expected no uses: %+v", uses)
+	}
+
+	stats, err := s.PartStats(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) == 0 || stats[0].Mpn != li.Mpn || stats[0].Boms != 2 || stats[0].Qty != 2*len(li.Elements) {
+		t.Errorf("unexpected part stats: %+v", stats)
+	}
+
+	// alternates count too, unless they've been rejected
+	alt := li.Alternates[0]
+	uses, _ = s.WhereUsed(alt.Manufacturer, alt.Mpn)
+	if len(uses) != 2 || uses[0].AltStatus != AltApproved || uses[0].Qty != len(li.Elements) {
+		t.Errorf("unexpected where-used for an alternate: %+v", uses)
+	}
+	last := stats[len(stats)-1]
+	if last.Mpn != alt.Mpn || last.Boms != 0 || last.AltBoms != 2 {
+		t.Errorf("unexpected part stats for an alternate: %+v", last)
+	}
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	b.LineItems[0].Alternates[0].Status = AltRejected
+	mustStore(t, s.Persist(bm, b, "v2"))
+	if uses, _ := s.WhereUsed(alt.Manufacturer, alt.Mpn); len(uses) != 1 || uses[0].Name != "gizmo" {
+		t.Errorf("rejected alternate counted: %+v", uses)
+	}
+
+	offers, err := s.PartOffers(alt.Manufacturer, alt.Mpn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offers) != 2 || offers[0].Name != "gizmo" || offers[0].Sku != alt.Offers[0].Sku {
+		t.Fatalf("unexpected alternate offers: %+v", offers)
+	}
+	if prices := offers[0].Prices; len(prices) != 1 || prices[0] != alt.Offers[0].Prices[0] {
+		t.Errorf("prices didn't round trip: %+v", prices)
+	}
+	if offers, _ := s.PartOffers(li.Manufacturer, li.Mpn); len(offers) != 2 || len(offers[0].Prices) != 2 {
+		t.Errorf("unexpected offers: %+v", offers)
+	}
+}
+
+func TestSQLStoreConformance(t *testing.T) {
+	testBomStore(t, func(t *testing.T) (BomStore, func()) {
+		return openTestSQLStore(t)
+	})
+}
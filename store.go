@@ -2,9 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
 // TODO: who owns returned BOMs? Caller? need "free" methods?
@@ -13,11 +18,183 @@ type BomStore interface {
 	GetHead(user, name ShortName) (*BomMeta, *Bom, error)
 	GetBom(user, name, version ShortName) (*Bom, error)
 	Persist(bm *BomMeta, b *Bom, version ShortName) error
-	ListBoms(user ShortName) ([]BomMeta, error)
+	// archived BOMs are only included if includeArchived is set
+	ListBoms(user ShortName, includeArchived bool) ([]BomMeta, error)
+	// oldest first
+	ListVersions(user, name ShortName) ([]BomVersion, error)
+	// refuses to delete the head version
+	DeleteVersion(user, name, version ShortName) error
+	SetArchived(user, name ShortName, archived bool) error
+	// replaces the BomMeta.LintSuppress list
+	SetLintSuppress(user, name ShortName, suppress []string) error
+	// points the head at an existing version, eg to revert
+	SetHead(user, name, version ShortName) error
+	// copies one version (the head, if version is "") to a new BOM
+	Fork(user, name, version, newOwner, newName ShortName) error
+	// copies every version to a new BOM
+	CopyWithHistory(user, name, newOwner, newName ShortName) error
+	// moves a BOM, with all its versions, to a different owner
+	TransferOwnership(user, name, newOwner ShortName) error
+}
+
+// Stores which record who added each version, eg as a git commit's author.
+type authoredPersister interface {
+	PersistAs(author ShortName, bm *BomMeta, b *Bom, version ShortName) error
+}
+
+// Persist, crediting the version to author (the logged in user, say) in
+// stores which record that. An empty author means the BOM's owner.
+func PersistAs(bs BomStore, author ShortName, bm *BomMeta, b *Bom, version ShortName) error {
+	if ap, ok := bs.(authoredPersister); ok {
+		return ap.PersistAs(author, bm, b, version)
+	}
+	return bs.Persist(bm, b, version)
+}
+
+// Kinds of error which every BomStore returns (wrapped in a StoreError), so
+// callers can tell a missing BOM from a broken one. Check with errors.Is() or
+// StoreErrorKind().
+const (
+	ErrNotFound = Error("not found")
+	ErrExists   = Error("already exists")
+	ErrInvalid  = Error("invalid")
+	ErrCorrupt  = Error("corrupt")
+)
+
+type StoreError struct {
+	Kind Error
+	Msg  string
+	Err  error // underlying error, if any
+}
+
+func (e *StoreError) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StoreError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func storeError(kind Error, msg string, err error) error {
+	return &StoreError{Kind: kind, Msg: msg, Err: err}
+}
+
+// The Kind of a StoreError, or "" for any other error (eg, I/O failures).
+func StoreErrorKind(err error) Error {
+	var se *StoreError
+	if errors.As(err, &se) {
+		return se.Kind
+	}
+	return ""
+}
+
+// Returns an ErrInvalid StoreError unless all the names are ShortNames.
+func checkNames(names ...ShortName) error {
+	for _, s := range names {
+		if !isShortName(string(s)) {
+			return storeError(ErrInvalid, "not a valid name: \""+string(s)+"\"", nil)
+		}
+	}
+	return nil
+}
+
+// Summary of one persisted version of a Bom, for browsing history.
+type BomVersion struct {
+	Version  string    `json:"version"`
+	Created  time.Time `json:"created_ts"`
+	Progeny  string    `json:"progeny"`
+	Lines    int       `json:"lines"`    // number of LineItems
+	Elements int       `json:"elements"` // total quantity of parts
+	Hash     string    `json:"hash,omitempty"`
+}
+
+func newBomVersion(bm *BomMeta, b *Bom) BomVersion {
+	bv := BomVersion{Version: b.Version,
+		Created: b.Created,
+		Progeny: b.Progeny,
+		Lines:   len(b.LineItems),
+		Hash:    bm.Hashes[b.Version]}
+	for i := range b.LineItems {
+		bv.Elements += len(b.LineItems[i].Elements)
+	}
+	return bv
+}
+
+func sortBomVersions(versions []BomVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if !versions[i].Created.Equal(versions[j].Created) {
+			return versions[i].Created.Before(versions[j].Created)
+		}
+		return versions[i].Version < versions[j].Version
+	})
+}
+
+// The BomMeta to Persist a new version of user/name with, given the meta (if
+// any) which came with the uploaded file. If the BOM already exists its stored
+// meta is used, so fork links, archiving, lint suppressions and public flags
+// survive an upload; only a description or homepage given in the file are
+// taken from it, and changed is whether they differ from what's stored. A new
+// BOM gets the uploaded meta, less anything only the store should set.
+func metaForUpload(bs BomStore, uploaded *BomMeta, user, name ShortName) (bm *BomMeta, changed bool, err error) {
+	if uploaded == nil {
+		uploaded = &BomMeta{}
+	}
+	bm, err = bs.GetBomMeta(user, name)
+	if StoreErrorKind(err) == ErrNotFound {
+		bm = uploaded
+		bm.HeadVersion, bm.Hashes, bm.IsArchived, bm.ForkOf = "", nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	} else {
+		if uploaded.Description != "" && uploaded.Description != bm.Description {
+			bm.Description, changed = uploaded.Description, true
+		}
+		if uploaded.Homepage != "" && uploaded.Homepage != bm.Homepage {
+			bm.Homepage, changed = uploaded.Homepage, true
+		}
+	}
+	bm.Owner, bm.Name = string(user), string(name)
+	return bm, changed, nil
+}
+
+// Opens the BomStore described by spec, which is the kind of store and its
+// location separated by a colon: "json:<directory>", "sqlite:<file>" or
+// "git:<repository>". "memory:" is an empty store which only lasts as long as
+// the process.
+func OpenBomStore(spec string) (BomStore, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return nil, Error("BOM store should be kind:location, eg sqlite:bommom.db; got \"" + spec + "\"")
+	}
+	kind, location := spec[:i], spec[i+1:]
+	switch kind {
+	case "json":
+		return OpenJSONFileBomStore(location)
+	case "sqlite":
+		return OpenSQLiteBomStore(location)
+	case "git":
+		return OpenGitBomStore(location)
+	case "memory":
+		return NewMemoryBomStore(), nil
+	}
+	return nil, Error("unknown kind of BOM store: " + kind)
 }
 
 // Basic BomStore backend using a directory structure of JSON files saved to
 // disk.
+//
+// Files are only ever replaced by writing a temporary file and renaming it
+// into place, and a version file is always written before the _meta.json
+// which points at it, so readers (which don't lock) never see a partial file
+// or a head version which doesn't exist. Writers take a per-BOM lock on a
+// file under .locks/, which works between processes.
 type JSONFileBomStore struct {
 	Rootfpath string
 }
@@ -38,7 +215,25 @@ func OpenJSONFileBomStore(fpath string) (*JSONFileBomStore, error) {
 	return &JSONFileBomStore{Rootfpath: fpath}, nil
 }
 
+func (jfbs *JSONFileBomStore) bomPath(user, name ShortName) string {
+	return jfbs.Rootfpath + "/" + string(user) + "/" + string(name)
+}
+
+// Blocks until nobody else (in this or another process) is writing to
+// user/name. Call the returned function to unlock.
+func (jfbs *JSONFileBomStore) lockBom(user, name ShortName) (func(), error) {
+	lockDir := jfbs.Rootfpath + "/.locks"
+	if err := os.MkdirAll(lockDir, os.ModePerm|os.ModeDir); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	// ShortNames can't contain '.', so this is unambiguous
+	return lockFile(lockDir + "/" + string(user) + "." + string(name))
+}
+
 func (jfbs *JSONFileBomStore) GetBomMeta(user, name ShortName) (*BomMeta, error) {
+	if err := checkNames(user, name); err != nil {
+		return nil, err
+	}
 	fpath := jfbs.Rootfpath + "/" + string(user) + "/" + string(name) + "/_meta.json"
 	bm := BomMeta{}
 	if err := readJsonBomMeta(fpath, &bm); err != nil {
@@ -54,53 +249,104 @@ func (jfbs *JSONFileBomStore) GetHead(user, name ShortName) (*BomMeta, *Bom, err
 	}
 	version := bm.HeadVersion
 	if version == "" {
-		log.Fatal("Tried to read undefined HEAD for " + string(user) + "/" + string(name))
+		return nil, nil, storeError(ErrCorrupt, "no head version for "+string(user)+"/"+string(name), nil)
 	}
 	b, err := jfbs.GetBom(user, name, ShortName(version))
 	return bm, b, err
 }
 
 func (jfbs *JSONFileBomStore) GetBom(user, name, version ShortName) (*Bom, error) {
+	if err := checkNames(user, name, version); err != nil {
+		return nil, err
+	}
 	fpath := jfbs.Rootfpath + "/" + string(user) + "/" + string(name) + "/" + string(version) + ".json"
 	b := Bom{}
 	if err := readJsonBom(fpath, &b); err != nil {
 		return nil, err
 	}
+	if b.Version != string(version) {
+		return nil, storeError(ErrCorrupt, "bom file for "+string(version)+" has version "+b.Version, nil)
+	}
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyBom(bm, &b); err != nil {
+		return nil, err
+	}
 	return &b, nil
 }
 
-func (jfbs *JSONFileBomStore) ListBoms(user ShortName) ([]BomMeta, error) {
+func (jfbs *JSONFileBomStore) ListBoms(user ShortName, includeArchived bool) ([]BomMeta, error) {
 	if user != "" {
-		return jfbs.listBomsForUser(user)
+		if err := checkNames(user); err != nil {
+			return nil, err
+		}
+		return jfbs.listBomsForUser(user, includeArchived)
 	}
 	// else iterator over all users...
 	rootDir, err := os.Open(jfbs.Rootfpath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rootDir.Close()
 	bmList := []BomMeta{}
 	dirInfo, err := rootDir.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
 	for _, node := range dirInfo {
 		if !node.IsDir() || !isShortName(node.Name()) {
 			continue
 		}
-		uList, err := jfbs.listBomsForUser(ShortName(node.Name()))
+		uList, err := jfbs.listBomsForUser(ShortName(node.Name()), includeArchived)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		bmList = append(bmList, uList...)
 	}
 	return bmList, nil
 }
 
-func (jfbs *JSONFileBomStore) listBomsForUser(user ShortName) ([]BomMeta, error) {
+func (jfbs *JSONFileBomStore) ListVersions(user, name ShortName) ([]BomVersion, error) {
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := jfbs.Rootfpath + "/" + string(user) + "/" + string(name)
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	fnames, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+	versions := []BomVersion{}
+	for _, fname := range fnames {
+		version := strings.TrimSuffix(fname, ".json")
+		if version == fname || !isShortName(version) {
+			// _meta.json, or something else
+			continue
+		}
+		b := Bom{}
+		if err := readJsonBom(dirPath+"/"+fname, &b); err != nil {
+			return nil, err
+		}
+		b.Version = version
+		versions = append(versions, newBomVersion(bm, &b))
+	}
+	sortBomVersions(versions)
+	return versions, nil
+}
+
+func (jfbs *JSONFileBomStore) listBomsForUser(user ShortName, includeArchived bool) ([]BomMeta, error) {
 	bmList := []BomMeta{}
 	uDirPath := jfbs.Rootfpath + "/" + string(user)
 	uDir, err := os.Open(uDirPath)
 	if err != nil {
-		if e, ok := err.(*os.PathError); ok && e.Err.Error() == "no such file or directory" {
-			// XXX: should probably check for a specific syscall error? same below
+		if os.IsNotExist(err) {
 			return bmList, nil
 		}
 		return nil, err
@@ -117,12 +363,20 @@ func (jfbs *JSONFileBomStore) listBomsForUser(user ShortName) ([]BomMeta, error)
 		fpath := jfbs.Rootfpath + "/" + string(user) + "/" + node.Name() + "/_meta.json"
 		bm := BomMeta{}
 		if err := readJsonBomMeta(fpath, &bm); err != nil {
-			if e, ok := err.(*os.PathError); ok && e.Err.Error() == "no such file or directory" {
+			switch StoreErrorKind(err) {
+			case ErrNotFound:
 				// no _meta.json in there
 				continue
+			case ErrCorrupt:
+				// one broken BOM shouldn't hide all the others
+				log.Println("skipping " + string(user) + "/" + node.Name() + ": " + err.Error())
+				continue
 			}
 			return nil, err
 		}
+		if bm.IsArchived && !includeArchived {
+			continue
+		}
 		bmList = append(bmList, bm)
 	}
 	return bmList, nil
@@ -133,81 +387,304 @@ func (jfbs *JSONFileBomStore) Persist(bm *BomMeta, b *Bom, version ShortName) er
 	b.Version = string(version)
 	bm.HeadVersion = string(version)
 	if err := bm.Validate(); err != nil {
-		return err
+		return storeError(ErrInvalid, "invalid bom meta", err)
 	}
 	if err := b.Validate(); err != nil {
+		return storeError(ErrInvalid, "invalid bom", err)
+	}
+
+	unlock, err := jfbs.lockBom(ShortName(bm.Owner), ShortName(bm.Name))
+	if err != nil {
 		return err
 	}
+	defer unlock()
 
 	b_fpath := jfbs.Rootfpath + "/" + string(bm.Owner) + "/" + string(bm.Name) + "/" + string(version) + ".json"
 	bm_fpath := jfbs.Rootfpath + "/" + string(bm.Owner) + "/" + string(bm.Name) + "/_meta.json"
 
-	if f, err := os.Open(b_fpath); err == nil {
-		f.Close()
-		return Error("bom with same owner, name, and version already exists")
+	if _, err := os.Stat(b_fpath); err == nil {
+		return storeError(ErrExists, "bom with same owner, name, and version already exists", nil)
 	}
-	if err := writeJsonBomMeta(bm_fpath, bm); err != nil {
-		log.Fatal(err)
+	// keep the hashes of earlier versions, even if bm is a fresh BomMeta
+	hashes := make(map[string]string)
+	if old, err := jfbs.GetBomMeta(ShortName(bm.Owner), ShortName(bm.Name)); err == nil {
+		for v, h := range old.Hashes {
+			hashes[v] = h
+		}
+	} else if StoreErrorKind(err) != ErrNotFound {
+		return err
+	}
+	for v, h := range bm.Hashes {
+		hashes[v] = h
 	}
+	hashes[string(version)] = b.Hash()
+	bm.Hashes = hashes
 	if err := writeJsonBom(b_fpath, b); err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if err := writeJsonBomMeta(bm_fpath, bm); err != nil {
+		return err
+	}
+	if bm.ForkOf != nil {
+		// the version is saved either way; fsck -repair fixes the index
+		if err := jfbs.addForkIndex(bm.ForkOf, ShortName(bm.Owner), ShortName(bm.Name)); err != nil {
+			log.Println("couldn't update fork index: " + err.Error())
+		}
 	}
 	return nil
 }
 
-func readJsonBomMeta(fpath string, bm *BomMeta) error {
-	f, err := os.Open(path.Clean(fpath))
+func (jfbs *JSONFileBomStore) DeleteVersion(user, name, version ShortName) error {
+	if err := checkNames(user, name, version); err != nil {
+		return err
+	}
+	unlock, err := jfbs.lockBom(user, name)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	dec := json.NewDecoder(f)
-	if err = dec.Decode(&bm); err != nil {
+	defer unlock()
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
 		return err
 	}
-	return nil
+	if string(version) == bm.HeadVersion {
+		return storeError(ErrInvalid, "can't delete the head version ("+bm.HeadVersion+"); set a different head first", nil)
+	}
+	b_fpath := jfbs.Rootfpath + "/" + string(user) + "/" + string(name) + "/" + string(version) + ".json"
+	if err := os.Remove(b_fpath); err != nil {
+		if os.IsNotExist(err) {
+			return storeError(ErrNotFound, "no such version: "+string(version), err)
+		}
+		return err
+	}
+	delete(bm.Hashes, string(version))
+	return writeJsonBomMeta(jfbs.bomPath(user, name)+"/_meta.json", bm)
 }
 
-func writeJsonBomMeta(fpath string, bm *BomMeta) error {
-	err := os.MkdirAll(path.Dir(fpath), os.ModePerm|os.ModeDir)
-	if err != nil && !os.IsExist(err) {
+func (jfbs *JSONFileBomStore) SetArchived(user, name ShortName, archived bool) error {
+	if err := checkNames(user, name); err != nil {
 		return err
 	}
-	f, err := os.Create(fpath)
+	unlock, err := jfbs.lockBom(user, name)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	enc := json.NewEncoder(f)
-	if err = enc.Encode(&bm); err != nil {
+	defer unlock()
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	bm.IsArchived = archived
+	return writeJsonBomMeta(jfbs.bomPath(user, name)+"/_meta.json", bm)
+}
+
+func (jfbs *JSONFileBomStore) SetLintSuppress(user, name ShortName, suppress []string) error {
+	if err := checkNames(user, name); err != nil {
+		return err
+	}
+	unlock, err := jfbs.lockBom(user, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	bm.LintSuppress = suppress
+	return writeJsonBomMeta(jfbs.bomPath(user, name)+"/_meta.json", bm)
+}
+
+func (jfbs *JSONFileBomStore) SetHead(user, name, version ShortName) error {
+	if err := checkNames(user, name, version); err != nil {
+		return err
+	}
+	unlock, err := jfbs.lockBom(user, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
+		return err
+	}
+	// also checks that the version exists and is intact
+	if _, err := jfbs.GetBom(user, name, version); err != nil {
+		return err
+	}
+	bm.HeadVersion = string(version)
+	return writeJsonBomMeta(jfbs.bomPath(user, name)+"/_meta.json", bm)
+}
+
+func (jfbs *JSONFileBomStore) Fork(user, name, version, newOwner, newName ShortName) error {
+	return forkBom(jfbs, user, name, version, newOwner, newName, false)
+}
+
+func (jfbs *JSONFileBomStore) CopyWithHistory(user, name, newOwner, newName ShortName) error {
+	return forkBom(jfbs, user, name, "", newOwner, newName, true)
+}
+
+func (jfbs *JSONFileBomStore) TransferOwnership(user, name, newOwner ShortName) error {
+	forks, err := jfbs.indexedForks(user, name)
+	if err != nil {
+		return err
+	}
+	bm, err := jfbs.moveBom(user, name, newOwner)
+	if err != nil {
+		return err
+	}
+	if bm.ForkOf != nil {
+		if err := jfbs.addForkIndex(bm.ForkOf, newOwner, name); err != nil {
+			return err
+		}
+		if err := jfbs.removeForkIndex(bm.ForkOf, user, name); err != nil {
+			return err
+		}
+	}
+	// keep forks pointing at the right place; Parent links in their versions
+	// are left alone, as a record of where they came from at the time
+	moved := &BomRef{Owner: string(newOwner), Name: string(name)}
+	for _, fork := range forks {
+		if err := jfbs.retargetFork(ShortName(fork.Owner), ShortName(fork.Name), newOwner); err != nil {
+			return err
+		}
+		if err := jfbs.addForkIndex(moved, ShortName(fork.Owner), ShortName(fork.Name)); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(jfbs.forkIndexPath(user, name))
+}
+
+// Returns the moved BOM's (updated) meta.
+func (jfbs *JSONFileBomStore) moveBom(user, name, newOwner ShortName) (*BomMeta, error) {
+	if err := checkNames(user, name, newOwner); err != nil {
+		return nil, err
+	}
+	// always lock in the same order, so two transfers can't deadlock
+	first, second := user, newOwner
+	if second < first {
+		first, second = second, first
+	}
+	unlock, err := jfbs.lockBom(first, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if first != second {
+		unlock2, err := jfbs.lockBom(second, name)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock2()
+	}
+
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := jfbs.GetBomMeta(newOwner, name); err == nil {
+		return nil, storeError(ErrExists, "bom already exists: "+string(newOwner)+"/"+string(name), nil)
+	} else if StoreErrorKind(err) != ErrNotFound {
+		return nil, err
+	}
+	newDir := jfbs.bomPath(newOwner, name)
+	if err := os.MkdirAll(path.Dir(newDir), os.ModePerm|os.ModeDir); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	if err := os.Rename(jfbs.bomPath(user, name), newDir); err != nil {
+		return nil, err
+	}
+	bm.Owner = string(newOwner)
+	return bm, writeJsonBomMeta(newDir+"/_meta.json", bm)
+}
+
+func (jfbs *JSONFileBomStore) retargetFork(user, name, newOwner ShortName) error {
+	unlock, err := jfbs.lockBom(user, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	bm, err := jfbs.GetBomMeta(user, name)
+	if err != nil {
 		return err
 	}
+	bm.ForkOf.Owner = string(newOwner)
+	return writeJsonBomMeta(jfbs.bomPath(user, name)+"/_meta.json", bm)
+}
+
+func readJsonBomMeta(fpath string, bm *BomMeta) error {
+	f, err := os.Open(path.Clean(fpath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return storeError(ErrNotFound, "no such bom", err)
+		}
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	if err = dec.Decode(&bm); err != nil {
+		return storeError(ErrCorrupt, "couldn't parse "+fpath, err)
+	}
 	return nil
 }
 
+func writeJsonBomMeta(fpath string, bm *BomMeta) error {
+	return writeJsonAtomic(fpath, bm)
+}
+
 func readJsonBom(fpath string, b *Bom) error {
 	f, err := os.Open(path.Clean(fpath))
 	if err != nil {
+		if os.IsNotExist(err) {
+			return storeError(ErrNotFound, "no such version", err)
+		}
 		return err
 	}
 	defer f.Close()
 	dec := json.NewDecoder(f)
 	if err = dec.Decode(&b); err != nil {
-		return err
+		return storeError(ErrCorrupt, "couldn't parse "+fpath, err)
 	}
 	return nil
 }
 
-// Need to write the BomMeta before writing the Bom
+// Need to write the Bom before the BomMeta pointing at it
 func writeJsonBom(fpath string, b *Bom) error {
-	f, err := os.Create(fpath)
+	return writeJsonAtomic(fpath, b)
+}
+
+// Writes v to a temporary file next to fpath, flushes it to disk, then renames
+// it over fpath, so a crash leaves either the old file or the new one. The
+// temporary name starts with '.', so it's never mistaken for a version.
+func writeJsonAtomic(fpath string, v interface{}) error {
+	dir := path.Dir(fpath)
+	err := os.MkdirAll(dir, os.ModePerm|os.ModeDir)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+path.Base(fpath)+".tmp")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	tmpPath := f.Name()
 	enc := json.NewEncoder(f)
-	if err = enc.Encode(&b); err != nil {
+	if err = enc.Encode(v); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, fpath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
+	// make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 	return nil
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// Opens a JSONFileBomStore in a new temporary directory, failing the test if
+// it can't. Call the returned function to remove it.
+func openTestJSONStore(t *testing.T) (*JSONFileBomStore, string, func()) {
+	dir, err := ioutil.TempDir("", "bommom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jfbs, err := OpenJSONFileBomStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return jfbs, dir, func() { os.RemoveAll(dir) }
+}
+
+func TestForkIndex(t *testing.T) {
+	jfbs, dir, cleanup := openTestJSONStore(t)
+	defer cleanup()
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "common", "widget"
+	mustStore(t, jfbs.Persist(bm, b, "v1"))
+	mustStore(t, jfbs.Fork("common", "widget", "", "alice", "gadget"))
+	if _, err := os.Stat(dir + "/.forks/common.widget/alice.gadget"); err != nil {
+		t.Errorf("fork not indexed: %v", err)
+	}
+
+	// a store from before the index gets one built
+	os.RemoveAll(dir + "/.forks")
+	if forks, err := ListForks(jfbs, "common", "widget"); err != nil || len(forks) != 1 {
+		t.Errorf("expected the index to be rebuilt: %+v %v", forks, err)
+	}
+
+	// stale entries are skipped, and fsck cleans them up
+	ioutil.WriteFile(dir+"/.forks/common.widget/bob.thing", nil, 0666)
+	os.Remove(dir + "/.forks/common.widget/alice.gadget")
+	if forks, _ := ListForks(jfbs, "common", "widget"); len(forks) != 0 {
+		t.Errorf("expected nothing from a broken index: %+v", forks)
+	}
+	problems, err := jfbs.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 || !problems[0].Repaired || !problems[1].Repaired {
+		t.Errorf("expected two repaired index problems: %+v", problems)
+	}
+	if forks, _ := ListForks(jfbs, "common", "widget"); len(forks) != 1 {
+		t.Errorf("expected the index to be repaired: %+v", forks)
+	}
+}
+
+// More writers than the conformance suite uses, since the JSON file store's
+// locking is the easiest to get wrong.
+func TestConcurrentPersist(t *testing.T) {
+	jfbs, _, cleanup := openTestJSONStore(t)
+	defer cleanup()
+
+	_, first := makeTestBom()
+	if err := jfbs.Persist(&BomMeta{Owner: "tester", Name: "widget"}, first, "v0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers shouldn't ever see a head which is missing or half written
+	done := make(chan bool)
+	readErrs := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-done:
+				close(readErrs)
+				return
+			default:
+			}
+			if _, _, err := jfbs.GetHead("tester", "widget"); err != nil {
+				readErrs <- err
+				close(readErrs)
+				return
+			}
+		}
+	}()
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			bm, b := makeTestBom()
+			bm.Owner, bm.Name = "tester", "widget"
+			b.Progeny = fmt.Sprintf("writer %d", i)
+			errs <- jfbs.Persist(bm, b, ShortName(fmt.Sprintf("v%d", i+1)))
+		}(i)
+		// everybody fighting over the same version name; only one can win
+		go func(i int) {
+			defer wg.Done()
+			bm, b := makeTestBom()
+			bm.Owner, bm.Name = "tester", "widget"
+			b.LineItems = b.LineItems[:1+i%len(b.LineItems)]
+			if err := jfbs.Persist(bm, b, "same"); err == nil {
+				errs <- nil
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	if err := <-readErrs; err != nil {
+		t.Errorf("read during writes failed: %v", err)
+	}
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err != nil {
+			t.Errorf("persist failed: %v", err)
+		} else {
+			succeeded++
+		}
+	}
+	if succeeded != writers+1 {
+		t.Errorf("expected %d successful writes, got %d", writers+1, succeeded)
+	}
+
+	bm, err := jfbs.GetBomMeta("tester", "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Hashes) != writers+2 {
+		t.Errorf("expected %d hashes, got %d", writers+2, len(bm.Hashes))
+	}
+	versions, err := jfbs.ListVersions("tester", "widget")
+	if err != nil || len(versions) != writers+2 {
+		t.Fatalf("expected %d versions: %d %v", writers+2, len(versions), err)
+	}
+	for _, bv := range versions {
+		if _, err := jfbs.GetBom("tester", "widget", ShortName(bv.Version)); err != nil {
+			t.Errorf("version %s: %v", bv.Version, err)
+		}
+	}
+	if _, _, err := jfbs.GetHead("tester", "widget"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInterruptedWrite(t *testing.T) {
+	jfbs, dir, cleanup := openTestJSONStore(t)
+	defer cleanup()
+
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	if err := jfbs.Persist(bm, b, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	// as left behind by a crash part way through writing v2
+	tmp := dir + "/tester/widget/.v2.json.tmp123"
+	if err := ioutil.WriteFile(tmp, []byte(`{"version": "v2", "line_it`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := jfbs.ListVersions("tester", "widget")
+	if err != nil || len(versions) != 1 {
+		t.Errorf("temporary file should be ignored: %v %v", versions, err)
+	}
+	if err := jfbs.Persist(bm, b, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, b2, err := jfbs.GetHead("tester", "widget"); err != nil || b2.Version != "v2" {
+		t.Errorf("unexpected head after retry: %v", err)
+	}
+}
+
+// What can go wrong with the files under a JSON store, beyond what the
+// conformance suite covers.
+func TestCorruptStore(t *testing.T) {
+	jfbs, dir, cleanup := openTestJSONStore(t)
+	defer cleanup()
+
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	mustStore(t, jfbs.Persist(bm, b, "v1"))
+	bm.Name = "broken"
+	mustStore(t, jfbs.Persist(bm, b, "v1"))
+	ioutil.WriteFile(dir+"/tester/broken/_meta.json", []byte("{not json"), 0666)
+
+	_, err := jfbs.GetBomMeta("tester", "broken")
+	if StoreErrorKind(err) != ErrCorrupt || !errors.Is(err, ErrCorrupt) {
+		t.Errorf("corrupt meta: expected %q, got %v", ErrCorrupt, err)
+	}
+	// a corrupt BOM is skipped, rather than breaking the whole listing
+	if list, err := jfbs.ListBoms("", true); err != nil || len(list) != 1 {
+		t.Errorf("expected just the good bom: %v %v", list, err)
+	}
+
+	ioutil.WriteFile(dir+"/tester/widget/v1.json", []byte(`{"version": "v1"}`), 0666)
+	if _, _, err := jfbs.GetHead("tester", "widget"); StoreErrorKind(err) != ErrCorrupt {
+		t.Errorf("hash mismatch: expected %q, got %v", ErrCorrupt, err)
+	}
+	if StoreErrorKind(Error("something else")) != "" {
+		t.Errorf("plain errors shouldn't have a kind")
+	}
+}
+
+func TestJSONFileStoreConformance(t *testing.T) {
+	testBomStore(t, func(t *testing.T) (BomStore, func()) {
+		jfbs, _, cleanup := openTestJSONStore(t)
+		return jfbs, cleanup
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+// Whole-store archives, for backups and for moving BOMs between BomStore
+// backends. An archive is a gzipped tarball:
+//
+//	bommom-store.json                   manifest: format and list of BOMs
+//	boms/<owner>/<name>/_meta.json      BomMeta, including content hashes
+//	boms/<owner>/<name>/_history.json   BomVersion list, oldest first
+//	boms/<owner>/<name>/<version>.json  one Bom per version
+//
+// The manifest comes first and each BOM's files are together, so an archive
+// can be restored in one pass, one BOM at a time.
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	archiveManifestName = "bommom-store.json"
+	archiveFormat       = 1
+)
+
+type storeManifest struct {
+	Format   int       `json:"format"`
+	Exported time.Time `json:"exported_ts"`
+	Boms     []BomRef  `json:"boms"`
+}
+
+// Counts of what was exported or imported.
+type ArchiveStats struct {
+	Boms     int
+	Versions int
+}
+
+func archiveError(msg string, err error) error {
+	return storeError(ErrCorrupt, "bad store archive: "+msg, err)
+}
+
+// Writes every BOM in bs, archived or not, with all of its versions to w.
+// Each version is checked against its hash on the way out, so a corrupt store
+// can't produce an archive which looks good.
+func ExportStore(bs BomStore, w io.Writer) (ArchiveStats, error) {
+	stats := ArchiveStats{}
+	all, err := bs.ListBoms("", true)
+	if err != nil {
+		return stats, err
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Owner != all[j].Owner {
+			return all[i].Owner < all[j].Owner
+		}
+		return all[i].Name < all[j].Name
+	})
+	now := time.Now()
+	manifest := storeManifest{Format: archiveFormat, Exported: now, Boms: []BomRef{}}
+	for _, bm := range all {
+		manifest.Boms = append(manifest.Boms, BomRef{Owner: bm.Owner, Name: bm.Name})
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, v interface{}) error {
+		content, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		return err
+	}
+	if err := add(archiveManifestName, manifest); err != nil {
+		return stats, err
+	}
+	for i := range all {
+		bm := &all[i]
+		user, name := ShortName(bm.Owner), ShortName(bm.Name)
+		versions, err := bs.ListVersions(user, name)
+		if err != nil {
+			return stats, err
+		}
+		dir := "boms/" + bm.Owner + "/" + bm.Name + "/"
+		if err := add(dir+"_meta.json", bm); err != nil {
+			return stats, err
+		}
+		if err := add(dir+"_history.json", versions); err != nil {
+			return stats, err
+		}
+		for _, bv := range versions {
+			b, err := bs.GetBom(user, name, ShortName(bv.Version))
+			if err != nil {
+				return stats, err
+			}
+			if err := add(dir+bv.Version+".json", b); err != nil {
+				return stats, err
+			}
+			stats.Versions++
+		}
+		stats.Boms++
+	}
+	if err := tw.Close(); err != nil {
+		return stats, err
+	}
+	return stats, gz.Close()
+}
+
+// One BOM being read out of an archive.
+type archivedBom struct {
+	meta    *BomMeta
+	history []BomVersion
+	boms    map[string]*Bom
+}
+
+// Restores an archive written by ExportStore into bs, keeping each BOM's
+// versions in the same order, its head, archived flag and fork links. None of
+// the archived BOMs may already exist in bs (that's checked before anything is
+// written), and every version which has a recorded hash must match it.
+func ImportStore(bs BomStore, r io.Reader) (ArchiveStats, error) {
+	stats := ArchiveStats{}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return stats, archiveError("not gzipped", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != archiveManifestName {
+		return stats, archiveError("no manifest", err)
+	}
+	manifest := storeManifest{}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return stats, archiveError("couldn't parse manifest", err)
+	}
+	if manifest.Format != archiveFormat {
+		return stats, archiveError("unknown format "+strconv.Itoa(manifest.Format), nil)
+	}
+	expected := make(map[string]bool)
+	for _, ref := range manifest.Boms {
+		if err := checkNames(ShortName(ref.Owner), ShortName(ref.Name)); err != nil {
+			return stats, err
+		}
+		if _, err := bs.GetBomMeta(ShortName(ref.Owner), ShortName(ref.Name)); err == nil {
+			return stats, storeError(ErrExists, "bom already exists: "+ref.String(), nil)
+		} else if StoreErrorKind(err) != ErrNotFound {
+			return stats, err
+		}
+		expected[ref.String()] = true
+	}
+
+	var current *archivedBom
+	currentRef := ""
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, archiveError("couldn't read", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		parts := strings.Split(path.Clean(hdr.Name), "/")
+		if hdr.Typeflag != tar.TypeReg || len(parts) != 4 || parts[0] != "boms" {
+			return stats, archiveError("unexpected file "+hdr.Name, nil)
+		}
+		ref := parts[1] + "/" + parts[2]
+		if ref != currentRef {
+			if current != nil {
+				return stats, archiveError("incomplete bom "+currentRef, nil)
+			}
+			if !expected[ref] {
+				return stats, archiveError("bom not in manifest (or repeated): "+ref, nil)
+			}
+			current, currentRef = &archivedBom{boms: make(map[string]*Bom)}, ref
+		}
+		switch fname := parts[3]; {
+		case fname == "_meta.json":
+			current.meta = &BomMeta{}
+			err = json.NewDecoder(tr).Decode(current.meta)
+		case fname == "_history.json":
+			err = json.NewDecoder(tr).Decode(&current.history)
+		case strings.HasSuffix(fname, ".json") && isShortName(strings.TrimSuffix(fname, ".json")):
+			b := &Bom{}
+			err = json.NewDecoder(tr).Decode(b)
+			current.boms[strings.TrimSuffix(fname, ".json")] = b
+		default:
+			return stats, archiveError("unexpected file "+hdr.Name, nil)
+		}
+		if err != nil {
+			return stats, archiveError("couldn't parse "+hdr.Name, err)
+		}
+		if current.meta == nil || current.history == nil || len(current.boms) < len(current.history) {
+			continue
+		}
+		if current.meta.Owner+"/"+current.meta.Name != ref {
+			return stats, archiveError("meta for "+ref+" is for "+current.meta.Owner+"/"+current.meta.Name, nil)
+		}
+		if err := restoreBom(bs, current); err != nil {
+			return stats, err
+		}
+		stats.Boms++
+		stats.Versions += len(current.history)
+		delete(expected, ref)
+		current = nil
+	}
+	if current != nil {
+		return stats, archiveError("incomplete bom "+currentRef, nil)
+	}
+	if len(expected) != 0 {
+		return stats, archiveError(strconv.Itoa(len(expected))+" boms in the manifest are missing", nil)
+	}
+	return stats, nil
+}
+
+func restoreBom(bs BomStore, ab *archivedBom) error {
+	bm := ab.meta
+	if len(ab.history) == 0 {
+		return archiveError("no versions of "+bm.Owner+"/"+bm.Name, nil)
+	}
+	for _, bv := range ab.history {
+		b, ok := ab.boms[bv.Version]
+		if !ok || b.Version != bv.Version {
+			return archiveError("missing version "+bm.Owner+"/"+bm.Name+"/"+bv.Version, nil)
+		}
+		// versions stored before hashes were recorded have none in either
+		if bv.Hash != bm.Hashes[bv.Version] {
+			return archiveError("history and meta disagree about the hash of "+bm.Owner+"/"+bm.Name+"/"+bv.Version, nil)
+		}
+		if err := VerifyBom(bm, b); err != nil {
+			return archiveError(bm.Owner+"/"+bm.Name+"/"+bv.Version, err)
+		}
+	}
+	// Persist fills in the head and hashes as it goes
+	nbm := *bm
+	nbm.Hashes, nbm.IsArchived = nil, false
+	for _, bv := range ab.history {
+		if err := bs.Persist(&nbm, ab.boms[bv.Version], ShortName(bv.Version)); err != nil {
+			return err
+		}
+	}
+	user, name := ShortName(bm.Owner), ShortName(bm.Name)
+	if bm.HeadVersion != nbm.HeadVersion {
+		if err := bs.SetHead(user, name, ShortName(bm.HeadVersion)); err != nil {
+			return err
+		}
+	}
+	if bm.IsArchived {
+		return bs.SetArchived(user, name, true)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A JSON file store with some history worth keeping: versions out of name
+// order, a reverted head, a deleted version, an archived BOM and a fork.
+func makeArchiveSource(t *testing.T) (BomStore, func()) {
+	jfbs, _, cleanup := openTestJSONStore(t)
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "common", "widget"
+	start := time.Now().Truncate(time.Second)
+	for i, v := range []string{"zeta", "alpha", "mid", "gone"} {
+		b.Created = start.Add(time.Duration(i) * time.Minute)
+		b.Progeny = "step " + v
+		b.LineItems = b.LineItems[:1+i%2]
+		if err := jfbs.Persist(bm, b, ShortName(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustStore(t, jfbs.SetHead("common", "widget", "alpha"))
+	mustStore(t, jfbs.DeleteVersion("common", "widget", "gone"))
+	mustStore(t, jfbs.Fork("common", "widget", "zeta", "tester", "gadget"))
+	mustStore(t, jfbs.SetArchived("tester", "gadget", true))
+	return jfbs, cleanup
+}
+
+func mustStore(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreArchiveRoundTrip(t *testing.T) {
+	src, cleanup := makeArchiveSource(t)
+	defer cleanup()
+	archive := &bytes.Buffer{}
+	stats, err := ExportStore(src, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Boms != 2 || stats.Versions != 4 {
+		t.Errorf("unexpected export stats: %+v", stats)
+	}
+
+	targets := map[string]func(t *testing.T) (BomStore, func()){
+		"memory": func(t *testing.T) (BomStore, func()) { return NewMemoryBomStore(), func() {} },
+		"sqlite": func(t *testing.T) (BomStore, func()) { return openTestSQLStore(t) },
+		"git": func(t *testing.T) (BomStore, func()) {
+			if _, err := exec.LookPath("git"); err != nil {
+				t.Skip("git not installed")
+			}
+			g, _, cleanup := openTestGitStore(t)
+			return g, cleanup
+		},
+	}
+	for kind, open := range targets {
+		t.Run(kind, func(t *testing.T) {
+			dst, cleanup := open(t)
+			defer cleanup()
+			stats, err := ImportStore(dst, bytes.NewReader(archive.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Boms != 2 || stats.Versions != 4 {
+				t.Errorf("unexpected import stats: %+v", stats)
+			}
+			compareStores(t, src, dst)
+
+			// importing again would clobber what's there
+			if _, err := ImportStore(dst, bytes.NewReader(archive.Bytes())); StoreErrorKind(err) != ErrExists {
+				t.Errorf("expected ErrExists importing twice, got %v", err)
+			}
+		})
+	}
+}
+
+// Checks that every BOM in a is the same in b: meta, version order, hashes
+// and content.
+func compareStores(t *testing.T, a, b BomStore) {
+	aList, _ := a.ListBoms("", true)
+	bList, _ := b.ListBoms("", true)
+	if len(aList) != len(bList) {
+		t.Fatalf("expected %d boms, got %d", len(aList), len(bList))
+	}
+	for _, abm := range aList {
+		user, name := ShortName(abm.Owner), ShortName(abm.Name)
+		bbm, err := b.GetBomMeta(user, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bbm.HeadVersion != abm.HeadVersion || bbm.IsArchived != abm.IsArchived || bbm.Description != abm.Description ||
+			len(bbm.Hashes) != len(abm.Hashes) || (abm.ForkOf != nil && !bbm.ForkOf.Is(ShortName(abm.ForkOf.Owner), ShortName(abm.ForkOf.Name))) {
+			t.Errorf("%s/%s: metas differ: %+v %+v", user, name, abm, bbm)
+		}
+		aVersions, _ := a.ListVersions(user, name)
+		bVersions, _ := b.ListVersions(user, name)
+		if len(aVersions) != len(bVersions) {
+			t.Fatalf("%s/%s: expected %d versions, got %d", user, name, len(aVersions), len(bVersions))
+		}
+		for i, av := range aVersions {
+			bv := bVersions[i]
+			if av.Version != bv.Version || av.Hash != bv.Hash || !av.Created.Equal(bv.Created) || bbm.Hashes[av.Version] != av.Hash {
+				t.Errorf("%s/%s: version %d differs: %+v %+v", user, name, i, av, bv)
+			}
+			if got, err := b.GetBom(user, name, ShortName(av.Version)); err != nil || got.Hash() != av.Hash {
+				t.Errorf("%s/%s/%s unreadable or changed: %v", user, name, av.Version, err)
+			}
+		}
+	}
+}
+
+// Stores from before content hashes were recorded still move between
+// backends, and pick up hashes on the way.
+func TestStoreArchiveWithoutHashes(t *testing.T) {
+	jfbs, dir, cleanup := openTestJSONStore(t)
+	defer cleanup()
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "widget"
+	for _, v := range []string{"v1", "v2"} {
+		mustStore(t, jfbs.Persist(bm, b, ShortName(v)))
+	}
+	bm.Hashes = nil
+	mustStore(t, writeJsonBomMeta(dir+"/tester/widget/_meta.json", bm))
+
+	archive := &bytes.Buffer{}
+	if _, err := ExportStore(jfbs, archive); err != nil {
+		t.Fatal(err)
+	}
+	ms := NewMemoryBomStore()
+	if stats, err := ImportStore(ms, archive); err != nil || stats.Versions != 2 {
+		t.Fatalf("import failed: %+v %v", stats, err)
+	}
+	v1, _ := jfbs.GetBom("tester", "widget", "v1")
+	ibm, ib, err := ms.GetHead("tester", "widget")
+	if err != nil || ib.Version != "v2" || ibm.Hashes["v1"] != v1.Hash() {
+		t.Errorf("unexpected import: %+v %v", ibm, err)
+	}
+}
+
+// Copies an archive, letting change rewrite (or drop, by returning nil) the
+// content of each file.
+func rewriteArchive(t *testing.T, archive []byte, change func(name string, content []byte) []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	out := &bytes.Buffer{}
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		if content = change(hdr.Name, content); content == nil {
+			continue
+		}
+		hdr.Size = int64(len(content))
+		tw.WriteHeader(hdr)
+		tw.Write(content)
+	}
+	tw.Close()
+	gzw.Close()
+	return out.Bytes()
+}
+
+func TestStoreArchiveErrors(t *testing.T) {
+	src, cleanup := makeArchiveSource(t)
+	defer cleanup()
+	archive := &bytes.Buffer{}
+	if _, err := ExportStore(src, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		archive []byte
+		kind    Error
+	}{
+		"not an archive": {[]byte("hello"), ErrCorrupt},
+		"tampered version": {rewriteArchive(t, archive.Bytes(), func(name string, content []byte) []byte {
+			if strings.HasSuffix(name, "/widget/mid.json") {
+				return bytes.Replace(content, []byte("WIDG0001"), []byte("WIDG0002"), 1)
+			}
+			return content
+		}), ErrCorrupt},
+		"missing version": {rewriteArchive(t, archive.Bytes(), func(name string, content []byte) []byte {
+			if strings.HasSuffix(name, "/widget/zeta.json") {
+				return nil
+			}
+			return content
+		}), ErrCorrupt},
+		"stray file": {rewriteArchive(t, archive.Bytes(), func(name string, content []byte) []byte {
+			if name == archiveManifestName {
+				return bytes.Replace(content, []byte(`,{"owner_name":"tester","name":"gadget"}`), nil, 1)
+			}
+			return content
+		}), ErrCorrupt},
+	}
+	for what, test := range tests {
+		ms := NewMemoryBomStore()
+		if _, err := ImportStore(ms, bytes.NewReader(test.archive)); StoreErrorKind(err) != test.kind {
+			t.Errorf("%s: expected %q, got %v", what, test.kind, err)
+		}
+	}
+
+	// nothing is written if any of the BOMs already exist
+	ms := NewMemoryBomStore()
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", "gadget"
+	mustStore(t, ms.Persist(bm, b, "v1"))
+	if _, err := ImportStore(ms, bytes.NewReader(archive.Bytes())); StoreErrorKind(err) != ErrExists {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if list, _ := ms.ListBoms("", true); len(list) != 1 {
+		t.Errorf("failed import wrote boms: %+v", list)
+	}
+}
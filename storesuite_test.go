@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Conformance tests which every BomStore must pass. Each backend's tests call
+// testBomStore with a function which opens a new, empty store and returns it
+// along with a cleanup function.
+func testBomStore(t *testing.T, open func(t *testing.T) (BomStore, func())) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, bs BomStore)
+	}{
+		{"PersistGet", suitePersistGet},
+		{"DuplicateVersion", suiteDuplicateVersion},
+		{"Listing", suiteListing},
+		{"Manage", suiteManage},
+		{"Names", suiteNames},
+		{"Forks", suiteForks},
+		{"Errors", suiteErrors},
+		{"Concurrent", suiteConcurrent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs, cleanup := open(t)
+			defer cleanup()
+			test.fn(t, bs)
+		})
+	}
+}
+
+// Persists versions of tester/name, one minute apart, and fails the test if
+// any can't be.
+func suitePersistVersions(t *testing.T, bs BomStore, name string, versions ...string) (*BomMeta, *Bom) {
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "tester", name
+	start := time.Now().Truncate(time.Second)
+	for i, v := range versions {
+		b.Created = start.Add(time.Duration(i) * time.Minute)
+		b.Progeny = "step " + v
+		if err := bs.Persist(bm, b, ShortName(v)); err != nil {
+			t.Fatalf("persisting %s/%s: %v", name, v, err)
+		}
+	}
+	return bm, b
+}
+
+func suitePersistGet(t *testing.T, bs BomStore) {
+	bm, b := suitePersistVersions(t, bs, "widget", "v1", "v2")
+	if b.Version != "v2" || bm.HeadVersion != "v2" || len(bm.Hashes) != 2 {
+		t.Errorf("Persist should set the version, head and hashes: %s %s %v", b.Version, bm.HeadVersion, bm.Hashes)
+	}
+
+	gbm, gb, err := bs.GetHead("tester", "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gbm.HeadVersion != "v2" || gbm.Owner != "tester" || gbm.Description != bm.Description {
+		t.Errorf("unexpected meta: %+v", gbm)
+	}
+	if gb.Version != "v2" || gb.Hash() != b.Hash() || gbm.Hashes["v2"] != b.Hash() {
+		t.Errorf("head doesn't match what was persisted")
+	}
+	b1, err := bs.GetBom("tester", "widget", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b1.Version != "v1" || b1.Progeny != "step v1" || len(b1.LineItems) != len(b.LineItems) {
+		t.Errorf("unexpected v1: %+v", b1)
+	}
+
+	// callers don't share anything with the store
+	b1.LineItems[0].Mpn = "CHANGED"
+	b.LineItems[0].Mpn = "CHANGED"
+	gbm.Hashes["v1"] = "changed"
+	if again, err := bs.GetBom("tester", "widget", "v1"); err != nil || again.LineItems[0].Mpn == "CHANGED" {
+		t.Errorf("stored version was changed through a returned or persisted Bom: %v", err)
+	}
+
+	// a fresh BomMeta doesn't lose the hashes of earlier versions
+	_, b3 := makeTestBom()
+	b3.LineItems = b3.LineItems[:1]
+	if err := bs.Persist(&BomMeta{Owner: "tester", Name: "widget"}, b3, "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if gbm, err := bs.GetBomMeta("tester", "widget"); err != nil || len(gbm.Hashes) != 3 || gbm.HeadVersion != "v3" {
+		t.Errorf("unexpected meta after v3: %+v %v", gbm, err)
+	}
+	if _, err := bs.GetBom("tester", "widget", "v1"); err != nil {
+		t.Errorf("v1 unreadable after v3: %v", err)
+	}
+}
+
+func suiteDuplicateVersion(t *testing.T, bs BomStore) {
+	bm, b := suitePersistVersions(t, bs, "widget", "v1")
+	before := b.Hash()
+	b.LineItems = b.LineItems[:1]
+	if err := bs.Persist(bm, b, "v1"); StoreErrorKind(err) != ErrExists {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	gbm, gb, err := bs.GetHead("tester", "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gb.Hash() != before || gbm.Hashes["v1"] != before {
+		t.Errorf("rejected version replaced the original")
+	}
+	if versions, _ := bs.ListVersions("tester", "widget"); len(versions) != 1 {
+		t.Errorf("expected 1 version, got %+v", versions)
+	}
+}
+
+func suiteListing(t *testing.T, bs BomStore) {
+	suitePersistVersions(t, bs, "widget", "zeta", "alpha", "mid")
+	suitePersistVersions(t, bs, "gadget", "v1")
+	bm, b := makeTestBom()
+	bm.Owner, bm.Name = "other", "widget"
+	if err := bs.Persist(bm, b, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := bs.ListBoms("", false); err != nil || len(list) != 3 {
+		t.Errorf("expected 3 boms: %+v %v", list, err)
+	}
+	list, err := bs.ListBoms("tester", false)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected 2 boms for tester: %+v %v", list, err)
+	}
+	for _, bm := range list {
+		if bm.Owner != "tester" || bm.HeadVersion == "" {
+			t.Errorf("unexpected listing: %+v", bm)
+		}
+	}
+	if list, err := bs.ListBoms("nobody", true); err != nil || len(list) != 0 {
+		t.Errorf("expected no boms for an unknown user: %+v %v", list, err)
+	}
+
+	if err := bs.SetArchived("tester", "gadget", true); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := bs.ListBoms("tester", false); len(list) != 1 || list[0].Name != "widget" {
+		t.Errorf("archived bom still listed: %+v", list)
+	}
+	if list, _ := bs.ListBoms("", true); len(list) != 3 {
+		t.Errorf("archived bom missing with includeArchived: %+v", list)
+	}
+
+	if err := bs.SetLintSuppress("tester", "widget", []string{"unpriced", "case_duplicate:yageo::rc0603"}); err != nil {
+		t.Fatal(err)
+	}
+	if bm, err := bs.GetBomMeta("tester", "widget"); err != nil || len(bm.LintSuppress) != 2 || bm.LintSuppress[1] != "case_duplicate:yageo::rc0603" || bm.HeadVersion != "mid" {
+		t.Errorf("lint suppressions not saved: %+v %v", bm, err)
+	}
+
+	// oldest first, not in name order
+	versions, err := bs.ListVersions("tester", "widget")
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("expected 3 versions: %+v %v", versions, err)
+	}
+	if versions[0].Version != "zeta" || versions[1].Version != "alpha" || versions[2].Version != "mid" {
+		t.Errorf("versions out of order: %+v", versions)
+	}
+	if bv := versions[1]; bv.Progeny != "step alpha" || bv.Lines != len(b.LineItems) || bv.Hash == "" {
+		t.Errorf("unexpected version summary: %+v", bv)
+	}
+}
+
+// Reverting, deleting and archiving, which the web history page drives.
+func suiteManage(t *testing.T, bs BomStore) {
+	suitePersistVersions(t, bs, "widget", "v1", "v2", "v3")
+	mustStore(t, bs.SetHead("tester", "widget", "v1"))
+	if bm, b, err := bs.GetHead("tester", "widget"); err != nil || bm.HeadVersion != "v1" || b.Version != "v1" {
+		t.Errorf("head not reverted: %+v %v", bm, err)
+	}
+	mustStore(t, bs.DeleteVersion("tester", "widget", "v3"))
+	if versions, _ := bs.ListVersions("tester", "widget"); len(versions) != 2 {
+		t.Errorf("expected 2 versions after delete: %+v", versions)
+	}
+	if bm, _ := bs.GetBomMeta("tester", "widget"); len(bm.Hashes) != 2 {
+		t.Errorf("deleted version's hash kept: %+v", bm.Hashes)
+	}
+	if _, err := bs.GetBom("tester", "widget", "v3"); StoreErrorKind(err) != ErrNotFound {
+		t.Errorf("expected deleted version to be gone, got %v", err)
+	}
+
+	mustStore(t, bs.SetArchived("tester", "widget", true))
+	if bm, _, err := bs.GetHead("tester", "widget"); err != nil || !bm.IsArchived {
+		t.Errorf("archived bom should still be readable: %+v %v", bm, err)
+	}
+	mustStore(t, bs.SetArchived("tester", "widget", false))
+	if list, _ := bs.ListBoms("tester", false); len(list) != 1 || list[0].IsArchived {
+		t.Errorf("unarchived bom not listed: %+v", list)
+	}
+}
+
+// ListForks (which backends may answer from an index) follows forks being
+// archived and transferred, and the source being transferred.
+func suiteForks(t *testing.T, bs BomStore) {
+	suitePersistVersions(t, bs, "widget", "v1", "v2")
+	mustStore(t, bs.Fork("tester", "widget", "v1", "alice", "gadget"))
+	mustStore(t, bs.CopyWithHistory("tester", "widget", "bob", "widget"))
+	mustStore(t, bs.Fork("alice", "gadget", "", "carol", "gizmo"))
+	fbm, fb, err := bs.GetHead("alice", "gadget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.Version != "v1" || fb.Parent == nil || fb.Parent.String() != "tester/widget/v1" || !fbm.ForkOf.Is("tester", "widget") {
+		t.Errorf("unexpected fork: %+v %+v", fbm, fb.Parent)
+	}
+	if versions, _ := bs.ListVersions("alice", "gadget"); len(versions) != 1 {
+		t.Errorf("a fork should only have one version: %+v", versions)
+	}
+	if versions, _ := bs.ListVersions("bob", "widget"); len(versions) != 2 {
+		t.Errorf("a copy should have every version: %+v", versions)
+	}
+	if cbm, _ := bs.GetBomMeta("bob", "widget"); cbm.HeadVersion != "v2" || !cbm.ForkOf.Is("tester", "widget") {
+		t.Errorf("unexpected copy: %+v", cbm)
+	}
+	forkNames := func(user, name ShortName) string {
+		forks, err := ListForks(bs, user, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := ""
+		for _, bm := range forks {
+			names += " " + bm.Owner + "/" + bm.Name
+		}
+		return names
+	}
+	if names := forkNames("tester", "widget"); names != " alice/gadget bob/widget" {
+		t.Errorf("unexpected forks:%s", names)
+	}
+	mustStore(t, bs.SetArchived("bob", "widget", true))
+	mustStore(t, bs.TransferOwnership("alice", "gadget", "dave"))
+	if names := forkNames("tester", "widget"); names != " dave/gadget" {
+		t.Errorf("expected the moved fork, without the archived one:%s", names)
+	}
+	if names := forkNames("dave", "gadget"); names != " carol/gizmo" {
+		t.Errorf("forks of a moved bom should follow it:%s", names)
+	}
+	if names := forkNames("alice", "gadget"); names != "" {
+		t.Errorf("forks left behind:%s", names)
+	}
+}
+
+func suiteNames(t *testing.T, bs BomStore) {
+	suitePersistVersions(t, bs, "widget", "v1")
+	bad := []ShortName{"", "../etc", "has space", "dot.ted", "1st"}
+	for _, name := range bad {
+		checks := map[string]error{}
+		_, checks["GetBomMeta"] = bs.GetBomMeta("tester", name)
+		_, _, checks["GetHead"] = bs.GetHead(name, "widget")
+		_, checks["GetBom"] = bs.GetBom("tester", "widget", name)
+		_, checks["ListVersions"] = bs.ListVersions("tester", name)
+		checks["DeleteVersion"] = bs.DeleteVersion("tester", "widget", name)
+		checks["SetHead"] = bs.SetHead("tester", "widget", name)
+		checks["TransferOwnership"] = bs.TransferOwnership("tester", "widget", name)
+		bm, b := makeTestBom()
+		bm.Owner, bm.Name = "tester", string(name)
+		checks["Persist name"] = bs.Persist(bm, b, "v1")
+		bm.Name = "widget"
+		checks["Persist version"] = bs.Persist(bm, b, name)
+		if name != "" {
+			_, checks["ListBoms"] = bs.ListBoms(name, true)
+		}
+		for what, err := range checks {
+			if StoreErrorKind(err) != ErrInvalid {
+				t.Errorf("%s(%q): expected ErrInvalid, got %v", what, name, err)
+			}
+		}
+	}
+	if list, _ := bs.ListBoms("", true); len(list) != 1 {
+		t.Errorf("bad names shouldn't have been stored: %+v", list)
+	}
+}
+
+func suiteErrors(t *testing.T, bs BomStore) {
+	suitePersistVersions(t, bs, "widget", "v1", "v2")
+	suitePersistVersions(t, bs, "gadget", "v1")
+
+	checks := map[string]Error{}
+	errs := map[string]error{}
+	check := func(what string, err error, kind Error) {
+		checks[what], errs[what] = kind, err
+	}
+	_, err := bs.GetBomMeta("tester", "nothing")
+	check("missing bom", err, ErrNotFound)
+	_, _, err = bs.GetHead("nobody", "widget")
+	check("missing head", err, ErrNotFound)
+	_, err = bs.GetBom("tester", "widget", "v9")
+	check("missing version", err, ErrNotFound)
+	_, err = bs.GetBom("tester", "nothing", "v1")
+	check("version of missing bom", err, ErrNotFound)
+	_, err = bs.ListVersions("tester", "nothing")
+	check("versions of missing bom", err, ErrNotFound)
+	check("deleting head", bs.DeleteVersion("tester", "widget", "v2"), ErrInvalid)
+	check("deleting missing version", bs.DeleteVersion("tester", "widget", "v9"), ErrNotFound)
+	check("head to missing version", bs.SetHead("tester", "widget", "v9"), ErrNotFound)
+	check("archiving missing bom", bs.SetArchived("tester", "nothing", true), ErrNotFound)
+	check("suppressing on missing bom", bs.SetLintSuppress("tester", "nothing", nil), ErrNotFound)
+	check("fork onto existing", bs.Fork("tester", "widget", "", "tester", "gadget"), ErrExists)
+	check("fork of missing bom", bs.Fork("tester", "nothing", "", "other", "nothing"), ErrNotFound)
+	check("transfer of missing bom", bs.TransferOwnership("tester", "nothing", "other"), ErrNotFound)
+	suitePersistVersions(t, bs, "spare", "v1")
+	if err := bs.TransferOwnership("tester", "spare", "other"); err != nil {
+		t.Fatal(err)
+	}
+	suitePersistVersions(t, bs, "spare", "v1")
+	check("transfer onto existing", bs.TransferOwnership("tester", "spare", "other"), ErrExists)
+
+	for what, kind := range checks {
+		err := errs[what]
+		if err == nil {
+			t.Errorf("%s: expected an error", what)
+		} else if StoreErrorKind(err) != kind || !errors.Is(err, kind) {
+			t.Errorf("%s: expected %q, got %v", what, kind, err)
+		}
+	}
+
+	// failed operations didn't change anything
+	if bm, err := bs.GetBomMeta("tester", "widget"); err != nil || bm.HeadVersion != "v2" || len(bm.Hashes) != 2 {
+		t.Errorf("bom changed by failed operations: %+v %v", bm, err)
+	}
+}
+
+func suiteConcurrent(t *testing.T, bs BomStore) {
+	_, first := makeTestBom()
+	if err := bs.Persist(&BomMeta{Owner: "tester", Name: "widget"}, first, "v0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers shouldn't ever see a head which is missing or half written
+	done := make(chan bool)
+	readErrs := make(chan error, 1)
+	go func() {
+		defer close(readErrs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, _, err := bs.GetHead("tester", "widget"); err != nil {
+				readErrs <- err
+				return
+			}
+		}
+	}()
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			bm, b := makeTestBom()
+			bm.Owner, bm.Name = "tester", "widget"
+			b.Progeny = fmt.Sprintf("writer %d", i)
+			errs <- bs.Persist(bm, b, ShortName(fmt.Sprintf("v%d", i+1)))
+		}(i)
+		// everybody fighting over the same version name; only one can win
+		go func(i int) {
+			defer wg.Done()
+			bm, b := makeTestBom()
+			bm.Owner, bm.Name = "tester", "widget"
+			b.LineItems = b.LineItems[:1+i%len(b.LineItems)]
+			b.Progeny = fmt.Sprintf("same %d", i)
+			if err := bs.Persist(bm, b, "same"); StoreErrorKind(err) != ErrExists {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	if err := <-readErrs; err != nil {
+		t.Errorf("read during writes failed: %v", err)
+	}
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err != nil {
+			t.Errorf("persist failed: %v", err)
+		} else {
+			succeeded++
+		}
+	}
+	if succeeded != writers+1 {
+		t.Errorf("expected %d successful writes, got %d", writers+1, succeeded)
+	}
+
+	bm, err := bs.GetBomMeta("tester", "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Hashes) != writers+2 {
+		t.Errorf("expected %d hashes, got %d", writers+2, len(bm.Hashes))
+	}
+	versions, err := bs.ListVersions("tester", "widget")
+	if err != nil || len(versions) != writers+2 {
+		t.Fatalf("expected %d versions: %d %v", writers+2, len(versions), err)
+	}
+	for _, bv := range versions {
+		if _, err := bs.GetBom("tester", "widget", ShortName(bv.Version)); err != nil {
+			t.Errorf("version %s: %v", bv.Version, err)
+		}
+	}
+	if _, _, err := bs.GetHead("tester", "widget"); err != nil {
+		t.Error(err)
+	}
+}
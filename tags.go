@@ -0,0 +1,73 @@
+package main
+
+// LineItem tags, eg for subsystem ownership ("power", "rf", "mechanical").
+
+import (
+	"sort"
+	"strings"
+)
+
+// Splits a comma seperated list into normalized (trimmed, lowercase) tags,
+// dropping blanks and duplicates.
+func ParseTags(s string) []string {
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func (li *LineItem) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range li.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the Bom with only the LineItems having the given tag.
+func (b *Bom) FilterByTag(tag string) *Bom {
+	filtered := *b
+	filtered.LineItems = []LineItem{}
+	for _, li := range b.LineItems {
+		if li.HasTag(tag) {
+			filtered.LineItems = append(filtered.LineItems, li)
+		}
+	}
+	return &filtered
+}
+
+type TagCount struct {
+	Tag   string
+	Lines int
+}
+
+// Every tag used in the Bom with the number of lines having it; most used
+// first, then alphabetical.
+func TagCloud(b *Bom) []TagCount {
+	counts := make(map[string]int)
+	for _, li := range b.LineItems {
+		for _, tag := range li.Tags {
+			counts[tag]++
+		}
+	}
+	cloud := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		cloud = append(cloud, TagCount{Tag: tag, Lines: n})
+	}
+	sort.Slice(cloud, func(i, j int) bool {
+		if cloud[i].Lines != cloud[j].Lines {
+			return cloud[i].Lines > cloud[j].Lines
+		}
+		return cloud[i].Tag < cloud[j].Tag
+	})
+	return cloud
+}
@@ -0,0 +1,120 @@
+package main
+
+// Assembly variants: several builds of the same board from one design, where
+// some parts are left unpopulated or swapped for a different value.
+
+// A change to a single circuit element (designator) in a Variant. Either the
+// element is not fitted at all (DNP, "do not populate"), or a Substitute part
+// is fitted instead of the one from the design.
+type VariantOverride struct {
+	Element    string    `json:"element"`
+	Dnp        bool      `json:"dnp"`
+	Substitute *LineItem `json:"substitute,omitempty"`
+}
+
+type Variant struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Overrides   []VariantOverride `json:"overrides"`
+}
+
+func (b *Bom) GetVariant(name string) *Variant {
+	for i := range b.Variants {
+		if b.Variants[i].Name == name {
+			return &b.Variants[i]
+		}
+	}
+	return nil
+}
+
+// Returns a new Bom with only the fitted parts for the named variant. DNP
+// elements are dropped, substituted elements are moved to the substitute
+// LineItem, and lines left with no elements are removed. The original Bom is
+// not modified. Overrides of elements which aren't in the Bom are an error.
+func (b *Bom) ForVariant(name string) (*Bom, error) {
+	v := b.GetVariant(name)
+	if v == nil {
+		return nil, Error("no such variant: \"" + name + "\"")
+	}
+	if err := v.Validate(b); err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]*VariantOverride)
+	for i := range v.Overrides {
+		overrides[v.Overrides[i].Element] = &v.Overrides[i]
+	}
+
+	fitted := *b
+	fitted.Variant = v.Name
+	fitted.Variants = nil
+	fitted.LineItems = []LineItem{}
+	substitutes := []LineItem{}
+	for _, li := range b.LineItems {
+		elements := []Element{}
+		for _, el := range li.Elements {
+			ov, ok := overrides[el.Id]
+			switch {
+			case el.Dnp:
+				// never fitted, in any variant
+			case !ok || el.Id == "":
+				elements = append(elements, el)
+			case ov.Dnp:
+				// not fitted
+			case ov.Substitute != nil:
+				substitutes = addElementToLines(substitutes, ov.Substitute, el)
+			default:
+				elements = append(elements, el)
+			}
+		}
+		if len(elements) == 0 {
+			continue
+		}
+		li.Elements = elements
+		fitted.LineItems = append(fitted.LineItems, li)
+	}
+	for _, sub := range substitutes {
+		for _, el := range sub.Elements {
+			fitted.LineItems = addElementToLines(fitted.LineItems, &sub, el)
+		}
+	}
+	return &fitted, nil
+}
+
+// Appends element to the line matching li (by Id), adding a copy of li to
+// lines if there isn't one yet.
+func addElementToLines(lines []LineItem, li *LineItem, element Element) []LineItem {
+	for i := range lines {
+		if lines[i].Id() == li.Id() {
+			lines[i].Elements = append(lines[i].Elements, element)
+			return lines
+		}
+	}
+	sub := *li
+	sub.Elements = []Element{element}
+	return append(lines, sub)
+}
+
+// Checks the variant against b, the Bom it belongs to: every override has to
+// name an element which is in b.
+func (v *Variant) Validate(b *Bom) error {
+	if !isShortName(v.Name) {
+		return Error("variant name not a ShortName: \"" + v.Name + "\"")
+	}
+	designators := make(map[string]bool)
+	for i := range b.LineItems {
+		for _, el := range b.LineItems[i].Elements {
+			if el.Id != "" {
+				designators[el.Id] = true
+			}
+		}
+	}
+	for _, ov := range v.Overrides {
+		if !designators[ov.Element] {
+			return Error("variant " + v.Name + " overrides element \"" + ov.Element + "\", which isn't in the BOM")
+		}
+		if ov.Dnp && ov.Substitute != nil {
+			return Error("variant " + v.Name + " both DNPs and substitutes element " + ov.Element)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestForVariant(t *testing.T) {
+	b := NewBom("v001")
+	b.AddLineItem(&LineItem{Manufacturer: "Yageo", Mpn: "RC0603-10K", Elements: NewElements("R1", "R2", "R3")})
+	b.AddLineItem(&LineItem{Manufacturer: "Atmel", Mpn: "ATTINY85", Elements: NewElements("U1")})
+	sub := &LineItem{Manufacturer: "Yageo", Mpn: "RC0603-4K7"}
+	b.Variants = []Variant{{Name: "lite",
+		Overrides: []VariantOverride{
+			{Element: "U1", Dnp: true},
+			{Element: "R2", Substitute: sub},
+			{Element: "R3", Substitute: sub}}}}
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	fitted, err := b.ForVariant("lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted.LineItems) != 2 {
+		t.Fatalf("expected 2 fitted lines, got %d", len(fitted.LineItems))
+	}
+	if li := fitted.GetLineItem("Atmel", "ATTINY85"); li != nil {
+		t.Errorf("DNP-only line should have been dropped")
+	}
+	if li := fitted.GetLineItem("Yageo", "RC0603-4K7"); li == nil || len(li.Elements) != 2 {
+		t.Errorf("substitute line should have both substituted elements")
+	}
+	if li := fitted.GetLineItem("Yageo", "RC0603-10K"); li == nil || len(li.Elements) != 1 {
+		t.Errorf("original line should only keep R1")
+	}
+	if len(b.LineItems[0].Elements) != 3 {
+		t.Errorf("original Bom was modified")
+	}
+	if _, err := b.ForVariant("nonesuch"); err == nil {
+		t.Errorf("expected error for unknown variant")
+	}
+
+	// R4 isn't in the design, eg a typo or a part since removed
+	b.Variants[0].Overrides = append(b.Variants[0].Overrides, VariantOverride{Element: "R4", Dnp: true})
+	if err := b.Validate(); err == nil {
+		t.Errorf("expected error for an override of a missing element")
+	}
+	if _, err := b.ForVariant("lite"); err == nil {
+		t.Errorf("expected error fitting a variant with a missing element")
+	}
+}